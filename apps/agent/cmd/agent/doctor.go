@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/synexim/panel-agent/internal/client"
+	"github.com/synexim/panel-agent/internal/config"
+	"github.com/synexim/panel-agent/internal/doctor"
+)
+
+// runDoctor checks the installation and prints a pass/fail report, exiting
+// with status 1 when a check failed
+func runDoctor(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to config file (optional, uses env vars if not set)")
+	send := fs.Bool("send", false, "Send the report to Panel")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	fs.Parse(args)
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	panelClient := client.New(cfg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	report := doctor.New(cfg, panelClient, version).Run(ctx)
+
+	if *asJSON {
+		if err := printJSON(report); err != nil {
+			return err
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, check := range report.Checks {
+			fmt.Fprintf(w, "[%s]\t%s\t%s\n", strings.ToUpper(check.Status), check.Name, check.Detail)
+		}
+		w.Flush()
+	}
+
+	if *send {
+		if err := panelClient.ReportDoctor(ctx, report); err != nil {
+			fmt.Fprintf(os.Stderr, "doctor: send report: %v\n", err)
+		} else if !*asJSON {
+			fmt.Println("report sent to Panel")
+		}
+	}
+	if !report.Healthy {
+		return errSilent
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/synexim/panel-agent/internal/client"
+	"github.com/synexim/panel-agent/internal/config"
+	"github.com/synexim/panel-agent/internal/importer"
+	"github.com/synexim/panel-agent/internal/xray"
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// runImport converts an Xray config.json and/or an x-ui database into a node
+// config and users, and prints, writes or uploads them
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to config file (optional, uses env vars if not set)")
+	xrayFile := fs.String("xray", "", "Xray config.json to import")
+	xuiFile := fs.String("xui", "", "x-ui database (x-ui.db) or its JSON export to import")
+	dir := fs.String("dir", "", "Write node.json and users.json for standalone mode to this directory")
+	upload := fs.Bool("upload", false, "Upload the result to Panel")
+	fs.Parse(args)
+
+	if *xrayFile == "" && *xuiFile == "" {
+		return errors.New("-xray or -xui is required")
+	}
+
+	var xrayResult, xuiResult *importer.Result
+	if *xrayFile != "" {
+		data, err := os.ReadFile(*xrayFile)
+		if err != nil {
+			return err
+		}
+		if xrayResult, err = importer.FromXray(data); err != nil {
+			return err
+		}
+	}
+	if *xuiFile != "" {
+		export, err := importer.LoadXUI(*xuiFile)
+		if err != nil {
+			return err
+		}
+		if xuiResult, err = importer.FromXUI(export); err != nil {
+			return err
+		}
+	}
+
+	source, result := "xray", xrayResult
+	switch {
+	case xuiResult != nil && xrayResult != nil:
+		source, result = "x-ui", importer.Combine(xuiResult, xrayResult)
+	case xuiResult != nil:
+		source, result = "x-ui", xuiResult
+	}
+
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(os.Stderr, "import: %s\n", warning)
+	}
+	for _, diag := range xray.ValidateNodeConfig(result.Config, nil) {
+		fmt.Fprintf(os.Stderr, "import: %s %s: %s\n", diag.Severity, diag.Path, diag.Message)
+	}
+	request := result.Request(source)
+
+	switch {
+	case *upload:
+		cfg, err := config.Load(*configPath)
+		if err != nil {
+			return fmt.Errorf("load config: %w", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		resp, err := client.New(cfg).ImportNode(ctx, request)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("imported %d inbounds and %d users to Panel\n", resp.Inbounds, resp.Users)
+		for _, skipped := range resp.Skipped {
+			fmt.Printf("  skipped %s, already exists\n", skipped)
+		}
+		return nil
+	case *dir != "":
+		return writeStandalone(*dir, request)
+	default:
+		return printJSON(request)
+	}
+}
+
+// writeStandalone writes the files standalone mode reads
+func writeStandalone(dir string, request *types.ImportRequest) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	files := map[string]interface{}{
+		"node.json":  request.Config,
+		"users.json": types.UserListResponse{Users: request.Users},
+	}
+	for name, v := range files {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		// users.json holds credentials
+		if err := os.WriteFile(filepath.Join(dir, name), append(data, '\n'), 0600); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("wrote %d inbounds to %s and %d users to %s\n",
+		len(request.Config.Inbounds), filepath.Join(dir, "node.json"),
+		len(request.Users), filepath.Join(dir, "users.json"))
+	return nil
+}
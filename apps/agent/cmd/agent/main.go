@@ -1,53 +1,86 @@
 package main
 
 import (
-	"context"
-	"flag"
+	"errors"
+	"fmt"
 	"os"
-	"os/signal"
-	"syscall"
+	"strings"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"github.com/synexim/panel-agent/internal/di"
 )
 
-var (
-	configPath = flag.String("config", "", "Path to config file (optional, uses env vars if not set)")
-	version    = "dev"
-)
+var version = "dev"
 
-func main() {
-	flag.Parse()
+// errSilent exits with status 1 after the command already printed why
+var errSilent = errors.New("")
+
+// command is an agent subcommand
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"run", "run [-config path]", "Run the agent (default)", runAgent},
+	{"status", "status [-json]", "Show the state of the running agent", runStatus},
+	{"sync", "sync [config|users]", "Make the running agent sync from Panel now", runSync},
+	{"render", "render [-core xray|singbox]", "Fetch the config from Panel and print the generated core config", runRender},
+	{"validate", "validate [-file node.json]", "Validate the Panel config, or a node config file", runValidate},
+	{"users", "users [-all] [-json]", "List online users and their traffic", runUsers},
+	{"kick", "kick <email>", "Drop a user's connections", runKick},
+	{"top", "top [-sort key] [-email x]", "Live per-user bandwidth, throughput and events", runTop},
+	{"doctor", "doctor [-send] [-json]", "Check the installation and print a pass/fail report", runDoctor},
+	{"import", "import -xray file -xui file", "Convert an Xray config or x-ui database to the Panel format", runImport},
+	{"version", "version", "Print the agent version", runVersion},
+}
 
-	// Setup logging
+func main() {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 
-	log.Info().Str("version", version).Msg("Panel Agent starting")
-
-	// Initialize manager with Wire DI
-	mgr, err := di.InitializeManager(*configPath)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to initialize agent")
+	// Without a subcommand the agent runs, so "agent -config x" keeps working
+	args := os.Args[1:]
+	name := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		usage()
+		return
 	}
 
-	// Create context with cancellation
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	// Start manager
-	if err := mgr.Start(ctx); err != nil {
-		log.Fatal().Err(err).Msg("Failed to start agent")
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		if cmd.name != "run" {
+			// Keep command output readable, only warnings go to stderr
+			zerolog.SetGlobalLevel(zerolog.WarnLevel)
+		}
+		if err := cmd.run(args); err != nil {
+			if err != errSilent {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			}
+			os.Exit(1)
+		}
+		return
 	}
 
-	// Wait for shutdown signal
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	<-sigCh
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
 
-	log.Info().Msg("Shutdown signal received")
-	cancel()
-	mgr.Stop()
-	log.Info().Msg("Panel Agent stopped")
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: agent <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-30s %s\n", cmd.usage, cmd.summary)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Every command accepts -config to read the agent config (admin socket, Panel URL).")
 }
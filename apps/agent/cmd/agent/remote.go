@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/synexim/panel-agent/internal/admin"
+	"github.com/synexim/panel-agent/internal/config"
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// remoteFlags are the flags of commands that talk to the running agent
+type remoteFlags struct {
+	configPath string
+	listen     string
+}
+
+func (r *remoteFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&r.configPath, "config", "", "Path to config file, for the admin socket and token")
+	fs.StringVar(&r.listen, "admin", "", "Admin API address, overrides admin.listen")
+}
+
+// client connects to the admin API of the running agent
+func (r *remoteFlags) client() (*admin.Client, error) {
+	cfg, err := config.Load(r.configPath)
+	if err != nil {
+		return nil, fmt.Errorf("load config: %w", err)
+	}
+	if r.listen != "" {
+		cfg.Admin.Listen = r.listen
+	}
+	return admin.NewClient(cfg.Admin), nil
+}
+
+// runStatus prints the state of the running agent
+func runStatus(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	var remote remoteFlags
+	remote.register(fs)
+	asJSON := fs.Bool("json", false, "Print the raw state as JSON")
+	fs.Parse(args)
+
+	c, err := remote.client()
+	if err != nil {
+		return err
+	}
+	state, err := c.State(context.Background())
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(state)
+	}
+	printState(state)
+	return nil
+}
+
+func printState(state *types.AgentState) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	started := time.Unix(state.StartedAt, 0)
+	fmt.Fprintf(w, "Started\t%s (up %s)\n", started.Format(time.RFC3339), time.Since(started).Round(time.Second))
+	fmt.Fprintf(w, "Core mode\t%s\n", state.CoreMode)
+	fmt.Fprintf(w, "Config\t%s\t%s\n", orNone(state.Active.ConfigVersion), shortHash(state.Active.ConfigHash))
+	fmt.Fprintf(w, "Users\t%s\t%d total, %d online\n", orNone(state.Active.UsersVersion), state.Users.Total, state.Users.Online)
+	if len(state.ExcludedInbounds) > 0 {
+		fmt.Fprintf(w, "Excluded\t%s\n", strings.Join(state.ExcludedInbounds, ", "))
+	}
+
+	fmt.Fprintln(w, "\nCORE\tVERSION\tRUNNING\tREADY\tERROR")
+	for _, core := range state.Cores {
+		fmt.Fprintf(w, "%s\t%s\t%t\t%t\t%s\n", core.CoreType, core.Version, core.Running, core.Ready, core.Error)
+	}
+
+	fmt.Fprintln(w, "\nTASK\tEVERY\tLAST RUN\tNEXT RUN\tDURATION\tRESULT")
+	for _, name := range sortedKeys(state.Tasks) {
+		task := state.Tasks[name]
+		every, lastRun, nextRun, result := "-", "never", "-", "ok"
+		if task.IntervalMs > 0 {
+			every = (time.Duration(task.IntervalMs) * time.Millisecond).String()
+		}
+		if task.LastRun > 0 {
+			lastRun = time.Since(time.Unix(task.LastRun, 0)).Round(time.Second).String() + " ago"
+		} else {
+			result = "-"
+		}
+		if task.NextRun > 0 {
+			nextRun = "in " + max(time.Until(time.Unix(task.NextRun, 0)), 0).Round(time.Second).String()
+		}
+		if task.LastRun > 0 && !task.OK {
+			result = fmt.Sprintf("failed x%d: %s", task.Failures, task.Error)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%dms\t%s\n", name, every, lastRun, nextRun, task.DurationMs, result)
+	}
+
+	if len(state.LastApply) > 0 {
+		fmt.Fprintln(w, "\nAPPLY\tVERSION\tSTATUS\tAT\tDETAILS")
+		for _, kind := range sortedKeys(state.LastApply) {
+			apply := state.LastApply[kind]
+			details := apply.Reason
+			if details == "" {
+				details = strings.Join(apply.Errors, "; ")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", kind, orNone(apply.Version), apply.Status, time.Unix(apply.AppliedAt, 0).Format(time.RFC3339), details)
+		}
+	}
+}
+
+// runSync makes the running agent sync its config, users or both
+func runSync(args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	var remote remoteFlags
+	remote.register(fs)
+	fs.Parse(args)
+
+	what := fs.Arg(0)
+	if what != "" && what != "config" && what != "users" {
+		return fmt.Errorf("unknown target %q, want config or users", what)
+	}
+	c, err := remote.client()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if what == "" || what == "config" {
+		if err := c.SyncConfig(ctx); err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+		fmt.Println("config synced")
+	}
+	if what == "" || what == "users" {
+		if err := c.SyncUsers(ctx); err != nil {
+			return fmt.Errorf("users: %w", err)
+		}
+		fmt.Println("users synced")
+	}
+	return nil
+}
+
+// runUsers lists online users with the traffic collected since the agent started
+func runUsers(args []string) error {
+	fs := flag.NewFlagSet("users", flag.ExitOnError)
+	var remote remoteFlags
+	remote.register(fs)
+	all := fs.Bool("all", false, "Include users that are offline and have no traffic")
+	asJSON := fs.Bool("json", false, "Print the users as JSON")
+	fs.Parse(args)
+
+	c, err := remote.client()
+	if err != nil {
+		return err
+	}
+	users, err := c.LiveUsers(context.Background(), *all)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(users)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		ti, tj := users[i].Upload+users[i].Download, users[j].Upload+users[j].Download
+		if ti != tj {
+			return ti > tj
+		}
+		return users[i].Email < users[j].Email
+	})
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "EMAIL\tSESSIONS\tUPLOAD\tDOWNLOAD")
+	for _, u := range users {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", u.Email, u.Sessions, formatBytes(u.Upload), formatBytes(u.Download))
+	}
+	return nil
+}
+
+// runKick drops a user's connections on the running agent
+func runKick(args []string) error {
+	fs := flag.NewFlagSet("kick", flag.ExitOnError)
+	var remote remoteFlags
+	remote.register(fs)
+	fs.Parse(args)
+
+	email := fs.Arg(0)
+	if email == "" {
+		return fmt.Errorf("usage: agent kick <email>")
+	}
+	c, err := remote.client()
+	if err != nil {
+		return err
+	}
+	if err := c.KickUser(context.Background(), email); err != nil {
+		return err
+	}
+	fmt.Printf("kicked %s\n", email)
+	return nil
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// formatBytes formats a byte count with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
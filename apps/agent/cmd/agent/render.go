@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/synexim/panel-agent/internal/di"
+	"github.com/synexim/panel-agent/internal/xray"
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// runRender fetches the config and users from Panel and prints the generated
+// core config without writing or applying it
+func runRender(args []string) error {
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to config file (optional, uses env vars if not set)")
+	core := fs.String("core", "", "Core to render (xray or singbox), default every configured core")
+	fs.Parse(args)
+
+	mgr, err := di.InitializeManager(*configPath)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	nodeConfig, users, err := mgr.FetchConfig(ctx)
+	if err != nil {
+		return err
+	}
+	configs, err := mgr.RenderConfigs(nodeConfig, users)
+	if err != nil {
+		return err
+	}
+
+	if *core != "" {
+		cfg, ok := configs[*core]
+		if !ok {
+			return fmt.Errorf("no %s core configured", *core)
+		}
+		return printJSON(cfg)
+	}
+	if len(configs) == 1 {
+		for _, cfg := range configs {
+			return printJSON(cfg)
+		}
+	}
+	return printJSON(configs)
+}
+
+// runValidate validates the config from Panel, or a node config file, and
+// exits with status 1 when it has errors
+func runValidate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to config file (optional, uses env vars if not set)")
+	file := fs.String("file", "", "Validate a node config JSON file instead of the config from Panel")
+	fs.Parse(args)
+
+	mgr, err := di.InitializeManager(*configPath)
+	if err != nil {
+		return err
+	}
+
+	var nodeConfig *types.NodeConfig
+	if *file != "" {
+		data, err := os.ReadFile(*file)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &nodeConfig); err != nil {
+			return fmt.Errorf("parse %s: %w", *file, err)
+		}
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if nodeConfig, _, err = mgr.FetchConfig(ctx); err != nil {
+			return err
+		}
+	}
+
+	diagnostics := mgr.ValidateConfig(nodeConfig)
+	for _, d := range diagnostics {
+		fmt.Printf("%-7s %-22s %-32s %s\n", d.Severity, d.Code, d.Path, d.Message)
+	}
+	if xray.HasErrors(diagnostics) {
+		return errSilent
+	}
+	fmt.Printf("config %s is valid\n", orNone(nodeConfig.Version))
+	return nil
+}
+
+// runVersion prints the agent version
+func runVersion(args []string) error {
+	fmt.Printf("panel-agent %s (%s, %s/%s)\n", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return nil
+}
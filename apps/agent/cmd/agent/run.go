@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+	"github.com/synexim/panel-agent/internal/config"
+	"github.com/synexim/panel-agent/internal/di"
+	"github.com/synexim/panel-agent/internal/logging"
+)
+
+// runAgent runs the agent until SIGINT or SIGTERM, reloading its config on
+// SIGHUP or when the config file changes
+func runAgent(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to config file (optional, uses env vars if not set)")
+	fs.Parse(args)
+
+	log.Info().Str("version", version).Msg("Panel Agent starting")
+
+	// Initialize manager, admin API and metrics with Wire DI
+	agent, err := di.InitializeAgent(*configPath)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize agent")
+	}
+	mgr := agent.Manager
+	if err := logging.Setup(agent.Config.Log); err != nil {
+		log.Fatal().Err(err).Msg("Failed to set up logging")
+	}
+	defer logging.Close()
+
+	// Create context with cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Start manager
+	if err := mgr.Start(ctx); err != nil {
+		log.Fatal().Err(err).Msg("Failed to start agent")
+	}
+
+	// The admin API is optional, the agent keeps running without it
+	if err := agent.Admin.Start(); err != nil {
+		log.Warn().Err(err).Msg("Failed to start admin API")
+	}
+	if err := agent.Metrics.Start(); err != nil {
+		log.Warn().Err(err).Msg("Failed to start metrics server")
+	}
+
+	// Reload on SIGHUP and on changes of the config file
+	reloader := &configReloader{agent: agent, current: agent.Config}
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	var fileCh <-chan struct{}
+	if agent.Config.File != "" {
+		if fileCh, err = config.Watch(ctx, agent.Config.File); err != nil {
+			log.Warn().Err(err).Msg("Failed to watch config file, reload with SIGHUP")
+		}
+	}
+
+	// Wait for shutdown signal
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	for running := true; running; {
+		select {
+		case <-hupCh:
+			log.Info().Msg("SIGHUP received, reloading config")
+			reloader.reload(ctx)
+		case _, ok := <-fileCh:
+			if !ok {
+				fileCh = nil
+				continue
+			}
+			log.Info().Str("file", agent.Config.File).Msg("Config file changed, reloading")
+			reloader.reload(ctx)
+		case <-sigCh:
+			running = false
+		}
+	}
+
+	log.Info().Msg("Shutdown signal received")
+	cancel()
+	agent.Admin.Stop()
+	agent.Metrics.Stop()
+	mgr.Stop()
+	log.Info().Msg("Panel Agent stopped")
+	return nil
+}
+
+// configReloader applies the live settings of a reloaded config
+type configReloader struct {
+	agent   *di.Agent
+	current *config.Config // last config applied
+}
+
+// reload reads the config file again and applies it. An invalid config is
+// rejected as a whole and the agent keeps running with the current one.
+func (r *configReloader) reload(ctx context.Context) {
+	cfg, err := config.Load(r.agent.Config.File)
+	if err != nil {
+		log.Error().Err(err).Msg("Config reload failed, keeping the current config")
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Error().Err(err).Msg("Reloaded config is invalid, keeping the current config")
+		return
+	}
+
+	// Set up even when unchanged, which reopens a log file moved by logrotate
+	if err := logging.Setup(cfg.Log); err != nil {
+		log.Error().Err(err).Msg("Failed to apply log settings, keeping the current ones")
+		cfg.Log = r.current.Log
+	} else if !reflect.DeepEqual(cfg.Log, r.current.Log) {
+		log.Info().Str("logLevel", cfg.Log.Level).Str("format", cfg.Log.Format).Str("file", cfg.Log.File).Msg("Log settings changed")
+	}
+	r.agent.Manager.Reload(ctx, cfg)
+	// Compared with the startup config, the warning stays until the restart
+	if sections := config.RestartRequired(r.agent.Config, cfg); len(sections) > 0 {
+		log.Warn().Strs("sections", sections).Msg("Changed settings take effect after a restart")
+	}
+	r.current = cfg
+	log.Info().Msg("Config reloaded")
+}
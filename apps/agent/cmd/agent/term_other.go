@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+var errNoTerminal = errors.New("interactive mode needs a Linux terminal")
+
+func makeRaw(fd int) (func(), error) {
+	return nil, errNoTerminal
+}
+
+func terminalSize(fd int) (int, int, error) {
+	return 0, 0, errNoTerminal
+}
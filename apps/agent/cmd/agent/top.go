@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/synexim/panel-agent/internal/admin"
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// topSorts are the user orders `top` cycles through
+var topSorts = []struct {
+	name string
+	less func(a, b *types.TopUser) bool
+}{
+	{"down", func(a, b *types.TopUser) bool { return a.DownloadRate > b.DownloadRate }},
+	{"up", func(a, b *types.TopUser) bool { return a.UploadRate > b.UploadRate }},
+	{"total", func(a, b *types.TopUser) bool { return a.UploadRate+a.DownloadRate > b.UploadRate+b.DownloadRate }},
+	{"ips", func(a, b *types.TopUser) bool { return a.IPs > b.IPs }},
+	{"email", func(a, b *types.TopUser) bool { return a.Email < b.Email }},
+}
+
+// topView is the state of the dashboard
+type topView struct {
+	snapshot *types.TopSnapshot
+	err      error
+
+	sort          int
+	reverse       bool
+	emailFilter   string
+	inboundFilter string
+
+	editing string // "email" or "inbound" while a filter is typed
+	input   string
+}
+
+// runTop shows live per-user bandwidth, tag throughput, core state and
+// recent events of the running agent
+func runTop(args []string) error {
+	fs := flag.NewFlagSet("top", flag.ExitOnError)
+	var remote remoteFlags
+	remote.register(fs)
+	interval := fs.Duration("interval", 2*time.Second, "Refresh interval")
+	once := fs.Bool("once", false, "Print one snapshot and exit")
+	sortBy := fs.String("sort", "down", "Sort users by down, up, total, ips or email")
+	email := fs.String("email", "", "Show users whose email contains this")
+	inbound := fs.String("inbound", "", "Show users of inbounds whose tag contains this")
+	fs.Parse(args)
+
+	c, err := remote.client()
+	if err != nil {
+		return err
+	}
+	view := &topView{emailFilter: *email, inboundFilter: *inbound}
+	for i, s := range topSorts {
+		if s.name == *sortBy {
+			view.sort = i
+		}
+	}
+
+	fd := int(os.Stdin.Fd())
+	restore, rawErr := makeRaw(fd)
+	if *once || rawErr != nil {
+		// Rates need two samples, the first call only starts the window
+		view.refresh(c)
+		time.Sleep(*interval)
+		view.refresh(c)
+		if view.err != nil {
+			return view.err
+		}
+		fmt.Print(strings.Join(view.render(120, 0), "\n") + "\n")
+		return nil
+	}
+	defer restore()
+
+	// Alternate screen, hidden cursor
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan byte)
+	go readKeys(keys)
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	view.refresh(c)
+	view.draw(fd)
+	for {
+		select {
+		case <-ticker.C:
+			view.refresh(c)
+		case key, ok := <-keys:
+			if !ok || view.handleKey(key) {
+				return nil
+			}
+		}
+		view.draw(fd)
+	}
+}
+
+// readKeys sends single key presses, dropping escape sequences such as arrows
+func readKeys(keys chan<- byte) {
+	buf := make([]byte, 16)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			close(keys)
+			return
+		}
+		if n > 1 && buf[0] == 0x1b {
+			continue
+		}
+		for _, b := range buf[:n] {
+			keys <- b
+		}
+	}
+}
+
+func (v *topView) refresh(c *admin.Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	snapshot, err := c.Top(ctx)
+	v.err = err
+	if err == nil {
+		v.snapshot = snapshot
+	}
+}
+
+// handleKey applies a key press and reports whether to quit
+func (v *topView) handleKey(key byte) bool {
+	if v.editing != "" {
+		switch key {
+		case '\r', '\n':
+			if v.editing == "email" {
+				v.emailFilter = v.input
+			} else {
+				v.inboundFilter = v.input
+			}
+			v.editing = ""
+		case 0x1b:
+			v.editing = ""
+		case 0x7f, 0x08:
+			if len(v.input) > 0 {
+				v.input = v.input[:len(v.input)-1]
+			}
+		case 0x03:
+			return true
+		default:
+			if key >= 0x20 && key < 0x7f {
+				v.input += string(key)
+			}
+		}
+		return false
+	}
+
+	switch key {
+	case 'q', 0x03:
+		return true
+	case 's':
+		v.sort = (v.sort + 1) % len(topSorts)
+	case 'r':
+		v.reverse = !v.reverse
+	case '/', 'e':
+		v.editing, v.input = "email", v.emailFilter
+	case 'i':
+		v.editing, v.input = "inbound", v.inboundFilter
+	case 'c':
+		v.emailFilter, v.inboundFilter = "", ""
+	}
+	return false
+}
+
+func (v *topView) draw(fd int) {
+	width, height, err := terminalSize(fd)
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 120, 40
+	}
+	lines := v.render(width, height)
+	fmt.Print("\x1b[H\x1b[2J" + strings.Join(lines, "\n"))
+}
+
+// render lays the dashboard out in lines of at most width columns. A height
+// of 0 prints every user and event.
+func (v *topView) render(width, height int) []string {
+	var lines []string
+	add := func(format string, args ...interface{}) {
+		line := []rune(fmt.Sprintf(format, args...))
+		if len(line) > width {
+			line = line[:width]
+		}
+		lines = append(lines, string(line))
+	}
+
+	s := v.snapshot
+	order := "▼"
+	if v.reverse {
+		order = "▲"
+	}
+	add("agent top  %s  sort: %s %s  email: %s  inbound: %s", time.Now().Format("15:04:05"), topSorts[v.sort].name, order, orNone(v.emailFilter), orNone(v.inboundFilter))
+	if v.err != nil {
+		add("error: %v", v.err)
+	}
+	if s == nil {
+		return lines
+	}
+
+	aliveAge := "never"
+	if s.AliveAt > 0 {
+		aliveAge = time.Since(time.Unix(s.AliveAt, 0)).Round(time.Second).String() + " ago"
+	}
+	add("rates over %.1fs, online IPs polled %s", s.Window, aliveAge)
+
+	var cores []string
+	for _, c := range s.Cores {
+		state := "down"
+		switch {
+		case c.Running && c.Ready:
+			state = "ready"
+		case c.Running:
+			state = "not ready"
+		}
+		if c.RolledBack {
+			state += ", rolled back"
+		}
+		cores = append(cores, fmt.Sprintf("%s %s", c.CoreType, state))
+	}
+	add("cores: %s", strings.Join(cores, " | "))
+	add("in:  %s", tagRates(s.Inbounds, 4))
+	add("out: %s", tagRates(s.Outbounds, 4))
+	add("")
+
+	users := v.filteredUsers()
+	add("%-36s %-20s %12s %12s %4s %10s", "EMAIL", "INBOUNDS", "UP/s", "DOWN/s", "IPS", "TOTAL")
+	events := s.Events
+	eventRows := 5
+	if height == 0 {
+		eventRows = len(events)
+	}
+	if len(events) > eventRows {
+		events = events[len(events)-eventRows:]
+	}
+	userRows := len(users)
+	if height > 0 {
+		// header, blank, events title, events, help line
+		userRows = height - len(lines) - len(events) - 3
+	}
+	for i, u := range users {
+		if i >= userRows {
+			add("... %d more", len(users)-i)
+			break
+		}
+		add("%-36s %-20s %12s %12s %4d %10s", truncate(u.Email, 36), truncate(strings.Join(u.Inbounds, ","), 20),
+			formatBytes(u.UploadRate), formatBytes(u.DownloadRate), u.IPs, formatBytes(u.Upload+u.Download))
+	}
+
+	add("")
+	add("recent events:")
+	for _, e := range events {
+		add("  %s  %-6s %s", time.Unix(e.At, 0).Format("15:04:05"), e.Kind, e.Message)
+	}
+	if height > 0 {
+		if v.editing != "" {
+			add("%s filter: %s_  (enter apply, esc cancel)", v.editing, v.input)
+		} else {
+			add("q quit  s sort  r reverse  / email filter  i inbound filter  c clear filters")
+		}
+	}
+	return lines
+}
+
+// filteredUsers applies the filters and sort order
+func (v *topView) filteredUsers() []types.TopUser {
+	var users []types.TopUser
+	for _, u := range v.snapshot.Users {
+		if v.emailFilter != "" && !strings.Contains(strings.ToLower(u.Email), strings.ToLower(v.emailFilter)) {
+			continue
+		}
+		if v.inboundFilter != "" && !containsTag(u.Inbounds, v.inboundFilter) {
+			continue
+		}
+		users = append(users, u)
+	}
+
+	less := topSorts[v.sort].less
+	sort.SliceStable(users, func(i, j int) bool {
+		if v.reverse {
+			return less(&users[j], &users[i])
+		}
+		return less(&users[i], &users[j])
+	})
+	return users
+}
+
+func containsTag(tags []string, filter string) bool {
+	for _, tag := range tags {
+		if strings.Contains(tag, filter) {
+			return true
+		}
+	}
+	return false
+}
+
+// tagRates formats the busiest tags on one line
+func tagRates(rates []types.TagRate, limit int) string {
+	if len(rates) == 0 {
+		return "-"
+	}
+	var parts []string
+	for i, r := range rates {
+		if i >= limit {
+			parts = append(parts, fmt.Sprintf("+%d", len(rates)-limit))
+			break
+		}
+		parts = append(parts, fmt.Sprintf("%s ↑%s/s ↓%s/s", r.Tag, formatBytes(r.UploadRate), formatBytes(r.DownloadRate)))
+	}
+	return strings.Join(parts, "  ")
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
@@ -169,6 +169,7 @@ After=network.target
 [Service]
 Type=simple
 ExecStart=${INSTALL_DIR}/agent -config ${CONFIG_DIR}/config.yaml
+ExecReload=/bin/kill -HUP \$MAINPID
 Restart=always
 RestartSec=5
 LimitNOFILE=65535
@@ -0,0 +1,126 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/synexim/panel-agent/internal/config"
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// Client talks to the admin API of a running agent
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// NewClient creates a client for the admin API at cfg.Listen
+func NewClient(cfg config.AdminConfig) *Client {
+	network, address := ParseListen(cfg.Listen)
+	c := &Client{
+		baseURL:    "http://" + address,
+		token:      cfg.Token,
+		httpClient: &http.Client{Timeout: 2 * time.Minute},
+	}
+	if network == "unix" {
+		c.baseURL = "http://agent"
+		dialer := &net.Dialer{}
+		c.httpClient.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", address)
+			},
+		}
+	}
+	return c
+}
+
+// State returns the agent's state
+func (c *Client) State(ctx context.Context) (*types.AgentState, error) {
+	var state types.AgentState
+	return &state, c.do(ctx, http.MethodGet, "/v1/state", nil, &state)
+}
+
+// LiveUsers returns the online users, or every synced user when all is set
+func (c *Client) LiveUsers(ctx context.Context, all bool) ([]types.LiveUser, error) {
+	path := "/v1/users/live"
+	if all {
+		path += "?all=true"
+	}
+	var users []types.LiveUser
+	return users, c.do(ctx, http.MethodGet, path, nil, &users)
+}
+
+// Top returns the live rates, core state and recent events
+func (c *Client) Top(ctx context.Context) (*types.TopSnapshot, error) {
+	var snapshot types.TopSnapshot
+	return &snapshot, c.do(ctx, http.MethodGet, "/v1/top", nil, &snapshot)
+}
+
+// SyncConfig makes the agent sync and apply its config now
+func (c *Client) SyncConfig(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/v1/sync/config", nil, nil)
+}
+
+// SyncUsers makes the agent sync and apply its users now
+func (c *Client) SyncUsers(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/v1/sync/users", nil, nil)
+}
+
+// KickUser drops a user's connections
+func (c *Client) KickUser(ctx context.Context, email string) error {
+	return c.do(ctx, http.MethodPost, "/v1/users/kick", KickRequest{Email: email}, nil)
+}
+
+// do sends a request and decodes the response into out, turning error
+// responses into errors
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var bodyReader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("marshal body: %w", err)
+		}
+		bodyReader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bodyReader)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("connect to agent: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s", apiErr.Error)
+		}
+		return fmt.Errorf("admin api: %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+// Package admin serves the agent's local admin API, on a unix socket by
+// default or on TCP with a bearer token
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/synexim/panel-agent/internal/config"
+	"github.com/synexim/panel-agent/internal/manager"
+)
+
+// Server serves the admin API on top of the Manager
+type Server struct {
+	cfg config.AdminConfig
+	mgr *manager.Manager
+	mux *http.ServeMux
+
+	server *http.Server
+}
+
+// NewServer creates the admin API server
+func NewServer(cfg *config.Config, mgr *manager.Manager) *Server {
+	s := &Server{
+		cfg: cfg.Admin,
+		mgr: mgr,
+		mux: http.NewServeMux(),
+	}
+	s.routes()
+	return s
+}
+
+// ParseListen splits a listen address into network and address:
+// "unix:/path" or "/path" for a unix socket, "host:port" for TCP
+func ParseListen(listen string) (string, string) {
+	if path, ok := strings.CutPrefix(listen, "unix:"); ok {
+		return "unix", strings.TrimPrefix(path, "//")
+	}
+	if strings.HasPrefix(listen, "/") {
+		return "unix", listen
+	}
+	return "tcp", listen
+}
+
+// Handle registers an additional handler on the admin API
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Start listens on the configured address and serves in the background
+func (s *Server) Start() error {
+	if !s.cfg.Enabled {
+		return nil
+	}
+
+	network, address := ParseListen(s.cfg.Listen)
+	if network == "tcp" && s.cfg.Token == "" {
+		return fmt.Errorf("admin api on tcp %s requires admin.token", address)
+	}
+	if network == "unix" {
+		if err := os.MkdirAll(filepath.Dir(address), 0755); err != nil {
+			return fmt.Errorf("create admin socket dir: %w", err)
+		}
+		// Remove a socket left behind by a previous run
+		os.Remove(address)
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return fmt.Errorf("listen admin api: %w", err)
+	}
+	if network == "unix" {
+		if err := os.Chmod(address, 0660); err != nil {
+			listener.Close()
+			return fmt.Errorf("chmod admin socket: %w", err)
+		}
+	}
+
+	s.server = &http.Server{
+		Handler:           s.authenticate(s.mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("Admin API stopped")
+		}
+	}()
+
+	log.Info().Str("network", network).Str("address", address).Msg("Admin API listening")
+	return nil
+}
+
+// Stop shuts the server down
+func (s *Server) Stop() {
+	if s.server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.server.Shutdown(ctx)
+}
+
+// authenticate requires the bearer token when one is configured
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	if s.cfg.Token == "" {
+		return next
+	}
+	expected := []byte("Bearer " + s.cfg.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("invalid admin token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) routes() {
+	s.mux.HandleFunc("GET /v1/state", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.mgr.State(r.Context()))
+	})
+	s.mux.HandleFunc("GET /v1/config", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.mgr.NodeConfig())
+	})
+	s.mux.HandleFunc("GET /v1/users", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.mgr.UserCounts(r.Context()))
+	})
+	s.mux.HandleFunc("GET /v1/top", func(w http.ResponseWriter, r *http.Request) {
+		snapshot, err := s.mgr.Top(r.Context())
+		writeResult(w, snapshot, err)
+	})
+	s.mux.HandleFunc("GET /v1/users/live", func(w http.ResponseWriter, r *http.Request) {
+		all := r.URL.Query().Get("all") == "true"
+		writeJSON(w, http.StatusOK, s.mgr.LiveUsers(r.Context(), all))
+	})
+
+	s.mux.HandleFunc("POST /v1/sync/config", func(w http.ResponseWriter, r *http.Request) {
+		writeResult(w, nil, s.mgr.SyncConfig())
+	})
+	s.mux.HandleFunc("POST /v1/sync/users", func(w http.ResponseWriter, r *http.Request) {
+		writeResult(w, nil, s.mgr.SyncUsers())
+	})
+	s.mux.HandleFunc("POST /v1/traffic/flush", func(w http.ResponseWriter, r *http.Request) {
+		count, err := s.mgr.FlushTraffic(r.Context())
+		writeResult(w, map[string]int{"count": count}, err)
+	})
+	s.mux.HandleFunc("POST /v1/users/kick", func(w http.ResponseWriter, r *http.Request) {
+		var req KickRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+			writeError(w, http.StatusBadRequest, errors.New("body must be {\"email\": \"...\"}"))
+			return
+		}
+		writeResult(w, nil, s.mgr.KickUser(r.Context(), req.Email))
+	})
+	s.mux.HandleFunc("POST /v1/core/restart", func(w http.ResponseWriter, r *http.Request) {
+		var req RestartRequest
+		if r.ContentLength > 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		writeResult(w, nil, s.mgr.RestartCore(req.Core))
+	})
+}
+
+// KickRequest is the body of POST /v1/users/kick
+type KickRequest struct {
+	Email string `json:"email"`
+}
+
+// RestartRequest is the body of POST /v1/core/restart; an empty core restarts all
+type RestartRequest struct {
+	Core string `json:"core,omitempty"`
+}
+
+// writeResult answers an action with its result, or its error
+func writeResult(w http.ResponseWriter, result interface{}, err error) {
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if result == nil {
+		result = map[string]bool{"ok": true}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -7,15 +7,16 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 	"time"
 
-	"github.com/rs/zerolog/log"
 	"github.com/synexim/panel-agent/internal/config"
 	"github.com/synexim/panel-agent/pkg/types"
 )
 
 // Client represents the Panel API client
 type Client struct {
+	mu          sync.RWMutex // guards the connection settings and ETags, see Reconfigure
 	baseURL     string
 	apiBasePath string
 	nodeToken   string
@@ -25,6 +26,8 @@ type Client struct {
 	// ETag cache
 	configETag string
 	usersETag  string
+
+	metrics requestMetrics
 }
 
 // New creates a new Panel API client (Wire provider)
@@ -40,6 +43,44 @@ func New(cfg *config.Config) *Client {
 	}
 }
 
+// Reconfigure applies changed connection settings from a reloaded config.
+// The ETags are dropped when the URL or token change, the new Panel may
+// not know them.
+func (c *Client) Reconfigure(cfg *config.Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cfg.Panel.URL != c.baseURL || cfg.Panel.Token != c.nodeToken {
+		c.configETag = ""
+		c.usersETag = ""
+	}
+	c.baseURL = cfg.Panel.URL
+	c.nodeToken = cfg.Panel.Token
+	c.httpClient = &http.Client{Timeout: cfg.HTTP.Timeout}
+	c.retryCount = cfg.HTTP.RetryCount
+}
+
+// connection returns the current connection settings
+func (c *Client) connection() (baseURL, token string, httpClient *http.Client, retryCount int) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.baseURL, c.nodeToken, c.httpClient, c.retryCount
+}
+
+// etag returns the ETag cached in *field
+func (c *Client) etag(field *string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return *field
+}
+
+// setETag caches an ETag in *field
+func (c *Client) setETag(field *string, etag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*field = etag
+}
+
 // doRequest performs HTTP request with retry logic
 func (c *Client) doRequest(ctx context.Context, method, path string, body interface{}, headers map[string]string) (*http.Response, error) {
 	var bodyReader io.Reader
@@ -51,25 +92,37 @@ func (c *Client) doRequest(ctx context.Context, method, path string, body interf
 		bodyReader = bytes.NewReader(data)
 	}
 
+	started := time.Now()
+	attempts := 0
+	resp, err := c.sendWithRetry(ctx, method, path, bodyReader, headers, &attempts)
+	c.metrics.observe(c.endpointName(path), started, attempts, err)
+	return resp, err
+}
+
+// sendWithRetry sends the request until it succeeds or the retries run out,
+// counting the attempts made
+func (c *Client) sendWithRetry(ctx context.Context, method, path string, bodyReader io.Reader, headers map[string]string, attempts *int) (*http.Response, error) {
+	baseURL, token, httpClient, retryCount := c.connection()
 	var lastErr error
-	for i := 0; i <= c.retryCount; i++ {
+	for i := 0; i <= retryCount; i++ {
+		*attempts = i + 1
 		if i > 0 {
 			time.Sleep(time.Duration(i) * time.Second)
 			log.Debug().Int("attempt", i+1).Str("path", path).Msg("Retrying request")
 		}
 
-		req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bodyReader)
+		req, err := http.NewRequestWithContext(ctx, method, baseURL+path, bodyReader)
 		if err != nil {
 			return nil, fmt.Errorf("create request: %w", err)
 		}
 
 		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("X-Node-Token", c.nodeToken)
+		req.Header.Set("X-Node-Token", token)
 		for k, v := range headers {
 			req.Header.Set(k, v)
 		}
 
-		resp, err := c.httpClient.Do(req)
+		resp, err := httpClient.Do(req)
 		if err != nil {
 			lastErr = err
 			continue
@@ -84,7 +137,7 @@ func (c *Client) doRequest(ctx context.Context, method, path string, body interf
 		return resp, nil
 	}
 
-	return nil, fmt.Errorf("request failed after %d retries: %w", c.retryCount, lastErr)
+	return nil, fmt.Errorf("request failed after %d retries: %w", retryCount, lastErr)
 }
 
 // Register registers the node with Panel
@@ -111,8 +164,8 @@ func (c *Client) Register(ctx context.Context, req *types.RegisterRequest) (*typ
 // GetConfig fetches node configuration from Panel
 func (c *Client) GetConfig(ctx context.Context) (*types.NodeConfig, bool, error) {
 	headers := make(map[string]string)
-	if c.configETag != "" {
-		headers["If-None-Match"] = c.configETag
+	if etag := c.etag(&c.configETag); etag != "" {
+		headers["If-None-Match"] = etag
 	}
 
 	resp, err := c.doRequest(ctx, http.MethodGet, c.apiBasePath+"/agent/config", nil, headers)
@@ -140,15 +193,15 @@ func (c *Client) GetConfig(ctx context.Context) (*types.NodeConfig, bool, error)
 	}
 
 	wrapper.Config.ETag = wrapper.ETag
-	c.configETag = wrapper.ETag
+	c.setETag(&c.configETag, wrapper.ETag)
 	return &wrapper.Config, true, nil
 }
 
 // GetUsers fetches user list from Panel
 func (c *Client) GetUsers(ctx context.Context) (*types.UserListResponse, bool, error) {
 	headers := make(map[string]string)
-	if c.usersETag != "" {
-		headers["If-None-Match"] = c.usersETag
+	if etag := c.etag(&c.usersETag); etag != "" {
+		headers["If-None-Match"] = etag
 	}
 
 	resp, err := c.doRequest(ctx, http.MethodGet, c.apiBasePath+"/agent/users", nil, headers)
@@ -171,6 +224,40 @@ func (c *Client) GetUsers(ctx context.Context) (*types.UserListResponse, bool, e
 		return nil, false, fmt.Errorf("decode response: %w", err)
 	}
 
-	c.usersETag = result.ETag
+	c.setETag(&c.usersETag, result.ETag)
 	return &result, true, nil
 }
+
+// ProbeResult is the answer of Panel to a single authenticated request
+type ProbeResult struct {
+	StatusCode int
+	ServerTime time.Time // from the Date header, zero when missing
+	Latency    time.Duration
+}
+
+// Probe sends one authenticated request without retries to check that Panel
+// is reachable and accepts the node token
+func (c *Client) Probe(ctx context.Context) (*ProbeResult, error) {
+	baseURL, token, httpClient, _ := c.connection()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+c.apiBasePath+"/agent/config", nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("X-Node-Token", token)
+
+	started := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := &ProbeResult{
+		StatusCode: resp.StatusCode,
+		Latency:    time.Since(started),
+	}
+	if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		result.ServerTime = date
+	}
+	return result, nil
+}
@@ -0,0 +1,6 @@
+package client
+
+import "github.com/synexim/panel-agent/internal/logging"
+
+// log is the Panel client's logger, its level is log.components.client
+var log = logging.Component("client")
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/synexim/panel-agent/internal/metrics"
+)
+
+// requestMetrics counts Panel API requests per endpoint
+type requestMetrics struct {
+	mu        sync.Mutex
+	endpoints map[string]*endpointMetrics
+}
+
+type endpointMetrics struct {
+	requests int64
+	errors   int64
+	retries  int64
+	seconds  float64
+}
+
+// observe records one request including its retries; err is the final error
+func (r *requestMetrics) observe(endpoint string, started time.Time, attempts int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.endpoints == nil {
+		r.endpoints = make(map[string]*endpointMetrics)
+	}
+	e := r.endpoints[endpoint]
+	if e == nil {
+		e = &endpointMetrics{}
+		r.endpoints[endpoint] = e
+	}
+	e.requests++
+	e.seconds += time.Since(started).Seconds()
+	if attempts > 1 {
+		e.retries += int64(attempts - 1)
+	}
+	if err != nil {
+		e.errors++
+	}
+}
+
+// endpointName strips the API prefix so the label stays short, e.g. "/agent/config"
+func (c *Client) endpointName(path string) string {
+	return strings.TrimPrefix(path, c.apiBasePath)
+}
+
+// CollectMetrics writes the Panel API request metrics
+func (c *Client) CollectMetrics(_ context.Context, w *metrics.Writer) {
+	c.metrics.mu.Lock()
+	defer c.metrics.mu.Unlock()
+
+	names := make([]string, 0, len(c.metrics.endpoints))
+	for name := range c.metrics.endpoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		e := c.metrics.endpoints[name]
+		w.Summary("panel_agent_panel_request_duration_seconds", "Panel API request latency including retries", e.seconds, e.requests, "endpoint", name)
+	}
+	for _, name := range names {
+		w.Counter("panel_agent_panel_request_errors_total", "Panel API requests that failed after all retries", float64(c.metrics.endpoints[name].errors), "endpoint", name)
+	}
+	for _, name := range names {
+		w.Counter("panel_agent_panel_request_retries_total", "Panel API request retries", float64(c.metrics.endpoints[name].retries), "endpoint", name)
+	}
+}
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"context"
+
+	"github.com/synexim/panel-agent/internal/config"
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// Panel is the control plane the manager syncs config and users from and
+// reports to: the Panel API (Client) or local files in standalone mode
+type Panel interface {
+	Register(ctx context.Context, req *types.RegisterRequest) (*types.RegisterResponse, error)
+	GetConfig(ctx context.Context) (*types.NodeConfig, bool, error)
+	GetUsers(ctx context.Context) (*types.UserListResponse, bool, error)
+
+	ReportTraffic(ctx context.Context, traffics []types.TrafficReport) error
+	ReportStatus(ctx context.Context, status *types.StatusReport) error
+	ReportAlive(ctx context.Context, users []types.AliveUser) (*types.AliveResponse, error)
+	ReportEgressIPs(ctx context.Context, ips []types.EgressIP) error
+	ReportEgressBindings(ctx context.Context, issues []types.EgressBindingIssue) error
+	ReportPortConflicts(ctx context.Context, report *types.PortConflictReport) error
+	ReportConfigValidation(ctx context.Context, report *types.ConfigValidationReport) error
+	ReportApplyResult(ctx context.Context, result *types.ApplyResult) error
+}
+
+// Watcher is implemented by panels that signal changes as they happen
+// instead of waiting for the next poll. Watch sends "config" or "users".
+type Watcher interface {
+	Watch(ctx context.Context) (<-chan string, error)
+}
+
+// Reconfigurer is implemented by panels whose connection settings can change
+// while the agent runs
+type Reconfigurer interface {
+	Reconfigure(cfg *config.Config)
+}
+
+var (
+	_ Panel        = (*Client)(nil)
+	_ Reconfigurer = (*Client)(nil)
+)
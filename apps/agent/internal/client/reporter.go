@@ -90,3 +90,108 @@ func (c *Client) ReportEgressIPs(ctx context.Context, ips []types.EgressIP) erro
 
 	return nil
 }
+
+// ReportPortConflicts reports inbounds whose ports cannot be bound
+func (c *Client) ReportPortConflicts(ctx context.Context, report *types.PortConflictReport) error {
+	resp, err := c.doRequest(ctx, http.MethodPost, c.apiBasePath+"/agent/port-conflicts", report, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("report port conflicts failed: %d - %s", resp.StatusCode, string(respBody))
+	}
+
+	return nil
+}
+
+// ReportApplyResult acknowledges the outcome of applying a config or user sync
+func (c *Client) ReportApplyResult(ctx context.Context, result *types.ApplyResult) error {
+	resp, err := c.doRequest(ctx, http.MethodPost, c.apiBasePath+"/agent/apply-result", result, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("report apply result failed: %d - %s", resp.StatusCode, string(respBody))
+	}
+
+	return nil
+}
+
+// ReportConfigValidation reports the diagnostics of a synced config
+func (c *Client) ReportConfigValidation(ctx context.Context, report *types.ConfigValidationReport) error {
+	resp, err := c.doRequest(ctx, http.MethodPost, c.apiBasePath+"/agent/config-validation", report, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("report config validation failed: %d - %s", resp.StatusCode, string(respBody))
+	}
+
+	return nil
+}
+
+// ReportEgressBindings reports per-user egress IP bindings that cannot be honored
+func (c *Client) ReportEgressBindings(ctx context.Context, issues []types.EgressBindingIssue) error {
+	body := map[string]interface{}{
+		"issues": issues,
+	}
+
+	resp, err := c.doRequest(ctx, http.MethodPost, c.apiBasePath+"/agent/egress-bindings", body, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("report egress bindings failed: %d - %s", resp.StatusCode, string(respBody))
+	}
+
+	return nil
+}
+
+// ReportDoctor sends the result of `agent doctor`
+func (c *Client) ReportDoctor(ctx context.Context, report *types.DoctorReport) error {
+	resp, err := c.doRequest(ctx, http.MethodPost, c.apiBasePath+"/agent/doctor", report, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("report doctor failed: %d - %s", resp.StatusCode, string(respBody))
+	}
+
+	return nil
+}
+
+// ImportNode uploads a node imported from an existing Xray or x-ui
+// installation, creating its inbounds and users on Panel
+func (c *Client) ImportNode(ctx context.Context, req *types.ImportRequest) (*types.ImportResponse, error) {
+	resp, err := c.doRequest(ctx, http.MethodPost, c.apiBasePath+"/agent/import", req, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("import failed: %d - %s", resp.StatusCode, string(respBody))
+	}
+
+	var result types.ImportResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return &result, nil
+}
@@ -9,11 +9,18 @@ import (
 
 // Config represents the agent configuration
 type Config struct {
-	Panel    PanelConfig    `mapstructure:"panel"`
-	Xray     XrayConfig     `mapstructure:"xray"`
-	Interval IntervalConfig `mapstructure:"interval"`
-	HTTP     HTTPConfig     `mapstructure:"http"`
-	Log      LogConfig      `mapstructure:"log"`
+	Panel      PanelConfig      `mapstructure:"panel"`
+	Core       CoreConfig       `mapstructure:"core"`
+	Xray       XrayConfig       `mapstructure:"xray"`
+	Singbox    SingboxConfig    `mapstructure:"singbox"`
+	Interval   IntervalConfig   `mapstructure:"interval"`
+	HTTP       HTTPConfig       `mapstructure:"http"`
+	Log        LogConfig        `mapstructure:"log"`
+	Admin      AdminConfig      `mapstructure:"admin"`
+	Metrics    MetricsConfig    `mapstructure:"metrics"`
+	Standalone StandaloneConfig `mapstructure:"standalone"`
+
+	File string `mapstructure:"-"` // config file read, empty when only env vars are used
 }
 
 // PanelConfig represents Panel API connection settings
@@ -23,12 +30,51 @@ type PanelConfig struct {
 	APIPrefix string `mapstructure:"api_prefix"`
 }
 
+// CoreModeDual runs Xray and sing-box side by side, split by inbound protocol
+const CoreModeDual = "dual"
+
+// CoreConfig selects the proxy core the agent runs
+type CoreConfig struct {
+	Type         string        `mapstructure:"type"`          // xray, singbox, dual
+	ReadyTimeout time.Duration `mapstructure:"ready_timeout"` // API and inbound readiness after (re)start
+
+	PortConflictPolicy string `mapstructure:"port_conflict_policy"` // exclude or reject
+
+	Probation ProbationConfig `mapstructure:"probation"`
+}
+
+// ProbationConfig watches a newly applied config and rolls it back when the
+// node's health degrades within the window
+type ProbationConfig struct {
+	Enabled           bool          `mapstructure:"enabled"`
+	Window            time.Duration `mapstructure:"window"`
+	CheckInterval     time.Duration `mapstructure:"check_interval"`
+	MinTrafficRatio   float64       `mapstructure:"min_traffic_ratio"` // of the traffic rate before apply
+	MinBaselineRate   int64         `mapstructure:"min_baseline_rate"` // bytes/s below which traffic is not compared
+	MinOnlineRatio    float64       `mapstructure:"min_online_ratio"`  // of the online users before apply
+	MinBaselineOnline int           `mapstructure:"min_baseline_online"`
+}
+
 // XrayConfig represents Xray paths and settings
 type XrayConfig struct {
 	BinaryPath string `mapstructure:"binary_path"`
 	ConfigPath string `mapstructure:"config_path"`
 	AssetPath  string `mapstructure:"asset_path"`
 	APIAddress string `mapstructure:"api_address"`
+	Embedded   bool   `mapstructure:"embedded"` // run xray-core in-process instead of the binary
+
+	// Blue/green restarts: the replacement process serves its API on the standby
+	// address while the old one drains for the grace period
+	Handover          bool          `mapstructure:"handover"`
+	StandbyAPIAddress string        `mapstructure:"standby_api_address"`
+	HandoverGrace     time.Duration `mapstructure:"handover_grace"`
+}
+
+// SingboxConfig represents sing-box paths and settings
+type SingboxConfig struct {
+	BinaryPath string `mapstructure:"binary_path"`
+	ConfigPath string `mapstructure:"config_path"`
+	APIAddress string `mapstructure:"api_address"`
 }
 
 // IntervalConfig represents polling/reporting intervals
@@ -38,6 +84,7 @@ type IntervalConfig struct {
 	TrafficReport time.Duration `mapstructure:"traffic_report"`
 	StatusReport  time.Duration `mapstructure:"status_report"`
 	AlivePoll     time.Duration `mapstructure:"alive_poll"`
+	Jitter        float64       `mapstructure:"jitter"` // fraction each period varies by, spreads a fleet's requests
 }
 
 // HTTPConfig represents HTTP client settings
@@ -48,8 +95,37 @@ type HTTPConfig struct {
 
 // LogConfig represents logging settings
 type LogConfig struct {
-	Level string `mapstructure:"level"`
-	File  string `mapstructure:"file"`
+	Level      string            `mapstructure:"level"`
+	File       string            `mapstructure:"file"`        // stderr when empty
+	Format     string            `mapstructure:"format"`      // console or json
+	MaxSize    int               `mapstructure:"max_size"`    // MB before the file is rotated, 0 disables
+	MaxAge     time.Duration     `mapstructure:"max_age"`     // rotate the file after this long, 0 disables
+	MaxBackups int               `mapstructure:"max_backups"` // rotated files kept, 0 keeps all
+	Components map[string]string `mapstructure:"components"`  // level per component: manager, xray, client
+	Redact     bool              `mapstructure:"redact"`      // mask tokens, passwords and UUIDs
+}
+
+// AdminConfig represents the local admin API settings
+type AdminConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Listen  string `mapstructure:"listen"` // "unix:/path" or "host:port"
+	Token   string `mapstructure:"token"`  // bearer token, required on TCP
+}
+
+// MetricsConfig represents the Prometheus endpoint settings
+type MetricsConfig struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	Listen   string `mapstructure:"listen"`
+	PerUser  bool   `mapstructure:"per_user"`  // export per-user traffic with an email label
+	MaxUsers int    `mapstructure:"max_users"` // first users with traffic get their own label, the rest are "_other"
+}
+
+// StandaloneConfig runs the agent from local files instead of Panel
+type StandaloneConfig struct {
+	Enabled     bool   `mapstructure:"enabled"`
+	ConfigFile  string `mapstructure:"config_file"`  // NodeConfig, YAML or JSON
+	UsersFile   string `mapstructure:"users_file"`   // users and rate limits, YAML or JSON
+	TrafficFile string `mapstructure:"traffic_file"` // user traffic accumulated locally
 }
 
 // Load loads configuration from file and environment variables
@@ -88,6 +164,7 @@ func Load(configPath string) (*Config, error) {
 	if err := v.Unmarshal(&cfg); err != nil {
 		return nil, err
 	}
+	cfg.File = v.ConfigFileUsed()
 
 	return &cfg, nil
 }
@@ -98,11 +175,32 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("panel.token", "")
 	v.SetDefault("panel.api_prefix", "/api")
 
+	// Core defaults
+	v.SetDefault("core.type", "xray")
+	v.SetDefault("core.ready_timeout", "15s")
+	v.SetDefault("core.port_conflict_policy", "exclude")
+	v.SetDefault("core.probation.enabled", false)
+	v.SetDefault("core.probation.window", "2m")
+	v.SetDefault("core.probation.check_interval", "10s")
+	v.SetDefault("core.probation.min_traffic_ratio", 0.3)
+	v.SetDefault("core.probation.min_baseline_rate", 10240)
+	v.SetDefault("core.probation.min_online_ratio", 0.3)
+	v.SetDefault("core.probation.min_baseline_online", 5)
+
 	// Xray defaults
 	v.SetDefault("xray.binary_path", "/usr/local/bin/xray")
 	v.SetDefault("xray.config_path", "/etc/xray/config.json")
 	v.SetDefault("xray.asset_path", "/usr/local/share/xray")
 	v.SetDefault("xray.api_address", "127.0.0.1:10085")
+	v.SetDefault("xray.embedded", false)
+	v.SetDefault("xray.handover", false)
+	v.SetDefault("xray.standby_api_address", "127.0.0.1:10087")
+	v.SetDefault("xray.handover_grace", "30s")
+
+	// sing-box defaults
+	v.SetDefault("singbox.binary_path", "/usr/local/bin/sing-box")
+	v.SetDefault("singbox.config_path", "/etc/sing-box/config.json")
+	v.SetDefault("singbox.api_address", "127.0.0.1:10086")
 
 	// Interval defaults
 	v.SetDefault("interval.config_poll", "30s")
@@ -110,6 +208,7 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("interval.traffic_report", "10s")
 	v.SetDefault("interval.status_report", "10s")
 	v.SetDefault("interval.alive_poll", "60s")
+	v.SetDefault("interval.jitter", 0.1)
 
 	// HTTP defaults
 	v.SetDefault("http.timeout", "30s")
@@ -118,6 +217,28 @@ func setDefaults(v *viper.Viper) {
 	// Log defaults
 	v.SetDefault("log.level", "info")
 	v.SetDefault("log.file", "")
+	v.SetDefault("log.format", "console")
+	v.SetDefault("log.max_size", 100)
+	v.SetDefault("log.max_age", "0s")
+	v.SetDefault("log.max_backups", 5)
+	v.SetDefault("log.redact", true)
+
+	// Admin API defaults
+	v.SetDefault("admin.enabled", true)
+	v.SetDefault("admin.listen", "unix:/run/panel-agent/admin.sock")
+	v.SetDefault("admin.token", "")
+
+	// Metrics defaults
+	v.SetDefault("metrics.enabled", false)
+	v.SetDefault("metrics.listen", "127.0.0.1:9550")
+	v.SetDefault("metrics.per_user", true)
+	v.SetDefault("metrics.max_users", 200)
+
+	// Standalone defaults
+	v.SetDefault("standalone.enabled", false)
+	v.SetDefault("standalone.config_file", "/etc/panel-agent/node.yaml")
+	v.SetDefault("standalone.users_file", "/etc/panel-agent/users.yaml")
+	v.SetDefault("standalone.traffic_file", "/var/lib/panel-agent/traffic.json")
 }
 
 func bindEnvVars(v *viper.Viper) {
@@ -128,7 +249,22 @@ func bindEnvVars(v *viper.Viper) {
 	v.BindEnv("xray.config_path", "XRAY_CONFIG_PATH")
 	v.BindEnv("xray.asset_path", "XRAY_ASSET_PATH")
 	v.BindEnv("xray.api_address", "XRAY_API_ADDRESS")
+	v.BindEnv("xray.embedded", "XRAY_EMBEDDED")
+	v.BindEnv("xray.handover", "XRAY_HANDOVER")
+	v.BindEnv("core.type", "CORE_TYPE")
+	v.BindEnv("core.port_conflict_policy", "PORT_CONFLICT_POLICY")
+	v.BindEnv("core.probation.enabled", "CORE_PROBATION")
+	v.BindEnv("singbox.binary_path", "SINGBOX_BINARY_PATH")
+	v.BindEnv("singbox.config_path", "SINGBOX_CONFIG_PATH")
+	v.BindEnv("singbox.api_address", "SINGBOX_API_ADDRESS")
 	v.BindEnv("log.level", "LOG_LEVEL")
+	v.BindEnv("log.file", "LOG_FILE")
+	v.BindEnv("log.format", "LOG_FORMAT")
+	v.BindEnv("admin.listen", "ADMIN_LISTEN")
+	v.BindEnv("admin.token", "ADMIN_TOKEN")
+	v.BindEnv("metrics.enabled", "METRICS_ENABLED")
+	v.BindEnv("metrics.listen", "METRICS_LISTEN")
+	v.BindEnv("standalone.enabled", "STANDALONE")
 }
 
 // NewConfig is a Wire provider for Config
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// watchDebounce collects the write, chmod and rename events of one save
+const watchDebounce = 500 * time.Millisecond
+
+// Validate checks the settings a reload applies live, so a broken edit is
+// rejected instead of stopping the loops or the Panel connection
+func (c *Config) Validate() error {
+	var errs []error
+	for name, d := range map[string]time.Duration{
+		"interval.config_poll":    c.Interval.ConfigPoll,
+		"interval.user_poll":      c.Interval.UserPoll,
+		"interval.traffic_report": c.Interval.TrafficReport,
+		"interval.status_report":  c.Interval.StatusReport,
+		"interval.alive_poll":     c.Interval.AlivePoll,
+		"http.timeout":            c.HTTP.Timeout,
+	} {
+		if d <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive, got %s", name, d))
+		}
+	}
+	if c.Interval.Jitter < 0 || c.Interval.Jitter > 0.5 {
+		errs = append(errs, fmt.Errorf("interval.jitter must be between 0 and 0.5, got %g", c.Interval.Jitter))
+	}
+	if c.HTTP.RetryCount < 0 {
+		errs = append(errs, fmt.Errorf("http.retry_count must not be negative, got %d", c.HTTP.RetryCount))
+	}
+	if _, err := zerolog.ParseLevel(c.Log.Level); err != nil {
+		errs = append(errs, fmt.Errorf("log.level: %w", err))
+	}
+	for name, level := range c.Log.Components {
+		if _, err := zerolog.ParseLevel(level); err != nil {
+			errs = append(errs, fmt.Errorf("log.components.%s: %w", name, err))
+		}
+	}
+	if c.Log.Format != "" && c.Log.Format != "console" && c.Log.Format != "json" {
+		errs = append(errs, fmt.Errorf("log.format must be console or json, got %q", c.Log.Format))
+	}
+	if c.Log.MaxSize < 0 || c.Log.MaxAge < 0 || c.Log.MaxBackups < 0 {
+		errs = append(errs, errors.New("log.max_size, log.max_age and log.max_backups must not be negative"))
+	}
+	if !c.Standalone.Enabled && c.Panel.URL == "" {
+		errs = append(errs, errors.New("panel.url is not set"))
+	}
+	return errors.Join(errs...)
+}
+
+// RestartRequired lists the sections that changed between two configs but
+// are only read at startup: cores, servers and the source of config and users
+func RestartRequired(old, updated *Config) []string {
+	var sections []string
+	for _, section := range []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"panel.api_prefix", old.Panel.APIPrefix, updated.Panel.APIPrefix},
+		{"core", old.Core, updated.Core},
+		{"xray", old.Xray, updated.Xray},
+		{"singbox", old.Singbox, updated.Singbox},
+		{"admin", old.Admin, updated.Admin},
+		{"metrics", old.Metrics, updated.Metrics},
+		{"standalone", old.Standalone, updated.Standalone},
+	} {
+		if !reflect.DeepEqual(section.old, section.new) {
+			sections = append(sections, section.name)
+		}
+	}
+	return sections
+}
+
+// Watch signals when the config file changes. The directory is watched so
+// editors and config management that replace the file are picked up too.
+func Watch(ctx context.Context, path string) (<-chan struct{}, error) {
+	path = filepath.Clean(path)
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("create watcher: %w", err)
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("watch %s: %w", filepath.Dir(path), err)
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer watcher.Close()
+		defer close(changes)
+
+		timer := time.NewTimer(watchDebounce)
+		timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) == path {
+					timer.Reset(watchDebounce)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Warn().Err(err).Str("file", path).Msg("Config watch error")
+			case <-timer.C:
+				select {
+				case changes <- struct{}{}:
+				default: // a reload is already pending
+				}
+			}
+		}
+	}()
+	return changes, nil
+}
@@ -0,0 +1,16 @@
+package di
+
+import (
+	"github.com/synexim/panel-agent/internal/admin"
+	"github.com/synexim/panel-agent/internal/config"
+	"github.com/synexim/panel-agent/internal/manager"
+	"github.com/synexim/panel-agent/internal/metrics"
+)
+
+// Agent bundles the manager with the servers running next to it
+type Agent struct {
+	Config  *config.Config // as loaded at startup
+	Manager *manager.Manager
+	Admin   *admin.Server
+	Metrics *metrics.Server
+}
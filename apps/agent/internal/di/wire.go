@@ -4,11 +4,17 @@
 package di
 
 import (
+	"strings"
+
 	"github.com/google/wire"
+	"github.com/synexim/panel-agent/internal/admin"
 	"github.com/synexim/panel-agent/internal/client"
 	"github.com/synexim/panel-agent/internal/config"
 	"github.com/synexim/panel-agent/internal/manager"
+	"github.com/synexim/panel-agent/internal/metrics"
 	"github.com/synexim/panel-agent/internal/reporter"
+	"github.com/synexim/panel-agent/internal/singbox"
+	"github.com/synexim/panel-agent/internal/standalone"
 	"github.com/synexim/panel-agent/internal/xray"
 )
 
@@ -22,42 +28,94 @@ func ProvideClient(cfg *config.Config) *client.Client {
 	return client.New(cfg)
 }
 
+// ProvideStandaloneSource provides the local config, users and traffic files
+func ProvideStandaloneSource(cfg *config.Config) *standalone.Source {
+	return standalone.NewSource(cfg)
+}
+
+// ProvidePanel provides the source of config and users: Panel, or the local
+// files in standalone mode
+func ProvidePanel(cfg *config.Config, c *client.Client, src *standalone.Source) client.Panel {
+	if cfg.Standalone.Enabled {
+		return src
+	}
+	return c
+}
+
 // ProvideConfigGenerator provides Xray ConfigGenerator
 func ProvideConfigGenerator(cfg *config.Config) *xray.ConfigGenerator {
-	return xray.NewConfigGenerator(cfg.Xray.ConfigPath)
+	generator := xray.NewConfigGenerator(cfg.Xray.ConfigPath, cfg.Xray.APIAddress)
+	if cfg.Xray.Embedded {
+		generator.DisableAPI()
+	}
+	if cfg.Xray.Handover {
+		generator.ReserveAPIAddress(cfg.Xray.StandbyAPIAddress)
+	}
+	if strings.EqualFold(cfg.Core.Type, config.CoreModeDual) {
+		generator.ReserveAPIAddress(cfg.Singbox.APIAddress)
+	}
+	return generator
+}
+
+// ProvideSingboxGenerator provides sing-box ConfigGenerator
+func ProvideSingboxGenerator(cfg *config.Config) *singbox.ConfigGenerator {
+	return singbox.NewConfigGenerator(cfg.Singbox.ConfigPath, cfg.Singbox.APIAddress)
 }
 
-// ProvideProcessManager provides Xray ProcessManager
-func ProvideProcessManager(cfg *config.Config) *xray.ProcessManager {
+// ProvideEmbeddedCore provides the in-process Xray core
+func ProvideEmbeddedCore(cfg *config.Config) *xray.EmbeddedCore {
+	return xray.NewEmbeddedCore(cfg.Xray.ConfigPath, cfg.Xray.AssetPath)
+}
+
+// ProvideProcessManager provides the Xray process, embedded or external binary
+func ProvideProcessManager(cfg *config.Config, embedded *xray.EmbeddedCore) xray.CoreProcess {
+	if cfg.Xray.Embedded {
+		return embedded
+	}
 	return xray.NewProcessManager(cfg.Xray.BinaryPath, cfg.Xray.ConfigPath, cfg.Xray.AssetPath)
 }
 
+// ProvideSingboxProcess provides sing-box ProcessManager
+func ProvideSingboxProcess(cfg *config.Config) *manager.SingboxProcess {
+	return &manager.SingboxProcess{
+		Process: xray.NewCoreProcessManager("sing-box", cfg.Singbox.BinaryPath, cfg.Singbox.ConfigPath, cfg.Xray.AssetPath),
+		GRPC:    xray.NewGRPCClient(cfg.Singbox.APIAddress),
+	}
+}
+
 // ProvideStatsCollector provides StatsCollector
 func ProvideStatsCollector(cfg *config.Config) *reporter.StatsCollector {
 	return reporter.NewStatsCollector(cfg.Xray.APIAddress)
 }
 
-// ProvideGRPCClient provides Xray GRPCClient
-func ProvideGRPCClient(cfg *config.Config) *xray.GRPCClient {
+// ProvideGRPCClient provides the Xray API, embedded or gRPC
+func ProvideGRPCClient(cfg *config.Config, embedded *xray.EmbeddedCore) xray.CoreAPI {
+	if cfg.Xray.Embedded {
+		return embedded
+	}
 	return xray.NewGRPCClient(cfg.Xray.APIAddress)
 }
 
 // ProvideManagerParams provides ManagerParams for Manager
 func ProvideManagerParams(
 	cfg *config.Config,
-	client *client.Client,
+	panel client.Panel,
 	generator *xray.ConfigGenerator,
-	process *xray.ProcessManager,
+	singboxGenerator *singbox.ConfigGenerator,
+	process xray.CoreProcess,
+	singboxProcess *manager.SingboxProcess,
 	stats *reporter.StatsCollector,
-	grpc *xray.GRPCClient,
+	api xray.CoreAPI,
 ) manager.ManagerParams {
 	return manager.ManagerParams{
-		Cfg:       cfg,
-		Client:    client,
-		Generator: generator,
-		Process:   process,
-		Stats:     stats,
-		GRPC:      grpc,
+		Cfg:            cfg,
+		Client:         panel,
+		Generator:      generator,
+		Singbox:        singboxGenerator,
+		Process:        process,
+		SingboxProcess: singboxProcess,
+		Stats:          stats,
+		API:            api,
 	}
 }
 
@@ -66,16 +124,43 @@ func ProvideManager(params manager.ManagerParams) *manager.Manager {
 	return manager.New(params)
 }
 
+// ProvideAdminServer provides the local admin API server
+func ProvideAdminServer(cfg *config.Config, mgr *manager.Manager, src *standalone.Source) *admin.Server {
+	server := admin.NewServer(cfg, mgr)
+	if cfg.Standalone.Enabled {
+		server.Handle("/v1/traffic", src.TrafficHandler())
+	}
+	return server
+}
+
+// ProvideMetricsServer provides the Prometheus metrics server
+func ProvideMetricsServer(cfg *config.Config, mgr *manager.Manager, c *client.Client) *metrics.Server {
+	return metrics.NewServer(cfg, mgr, c)
+}
+
+// ProvideAgent provides Agent
+func ProvideAgent(cfg *config.Config, mgr *manager.Manager, adminServer *admin.Server, metricsServer *metrics.Server) *Agent {
+	return &Agent{Config: cfg, Manager: mgr, Admin: adminServer, Metrics: metricsServer}
+}
+
 // ProviderSet is the Wire provider set for all dependencies
 var ProviderSet = wire.NewSet(
 	ProvideConfig,
 	ProvideClient,
+	ProvideStandaloneSource,
+	ProvidePanel,
 	ProvideConfigGenerator,
+	ProvideSingboxGenerator,
+	ProvideEmbeddedCore,
 	ProvideProcessManager,
+	ProvideSingboxProcess,
 	ProvideStatsCollector,
 	ProvideGRPCClient,
 	ProvideManagerParams,
 	ProvideManager,
+	ProvideAdminServer,
+	ProvideMetricsServer,
+	ProvideAgent,
 )
 
 // InitializeManager creates a Manager with all dependencies injected
@@ -83,3 +168,9 @@ func InitializeManager(configPath string) (*manager.Manager, error) {
 	wire.Build(ProviderSet)
 	return nil, nil
 }
+
+// InitializeAgent creates the Manager and the admin API and metrics servers around it
+func InitializeAgent(configPath string) (*Agent, error) {
+	wire.Build(ProviderSet)
+	return nil, nil
+}
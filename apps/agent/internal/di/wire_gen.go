@@ -7,10 +7,16 @@
 package di
 
 import (
+	"strings"
+
+	"github.com/synexim/panel-agent/internal/admin"
 	"github.com/synexim/panel-agent/internal/client"
 	"github.com/synexim/panel-agent/internal/config"
 	"github.com/synexim/panel-agent/internal/manager"
+	"github.com/synexim/panel-agent/internal/metrics"
 	"github.com/synexim/panel-agent/internal/reporter"
+	"github.com/synexim/panel-agent/internal/singbox"
+	"github.com/synexim/panel-agent/internal/standalone"
 	"github.com/synexim/panel-agent/internal/xray"
 )
 
@@ -21,15 +27,44 @@ func InitializeManager(configPath string) (*manager.Manager, error) {
 		return nil, err
 	}
 	panelClient := ProvideClient(cfg)
+	source := ProvideStandaloneSource(cfg)
+	panel := ProvidePanel(cfg, panelClient, source)
 	configGenerator := ProvideConfigGenerator(cfg)
-	processManager := ProvideProcessManager(cfg)
+	singboxGenerator := ProvideSingboxGenerator(cfg)
+	embeddedCore := ProvideEmbeddedCore(cfg)
+	coreProcess := ProvideProcessManager(cfg, embeddedCore)
+	singboxProcess := ProvideSingboxProcess(cfg)
 	statsCollector := ProvideStatsCollector(cfg)
-	grpcClient := ProvideGRPCClient(cfg)
-	managerParams := ProvideManagerParams(cfg, panelClient, configGenerator, processManager, statsCollector, grpcClient)
+	coreAPI := ProvideGRPCClient(cfg, embeddedCore)
+	managerParams := ProvideManagerParams(cfg, panel, configGenerator, singboxGenerator, coreProcess, singboxProcess, statsCollector, coreAPI)
 	mgr := ProvideManager(managerParams)
 	return mgr, nil
 }
 
+// InitializeAgent creates the Manager and the admin API and metrics servers around it
+func InitializeAgent(configPath string) (*Agent, error) {
+	cfg, err := ProvideConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	panelClient := ProvideClient(cfg)
+	source := ProvideStandaloneSource(cfg)
+	panel := ProvidePanel(cfg, panelClient, source)
+	configGenerator := ProvideConfigGenerator(cfg)
+	singboxGenerator := ProvideSingboxGenerator(cfg)
+	embeddedCore := ProvideEmbeddedCore(cfg)
+	coreProcess := ProvideProcessManager(cfg, embeddedCore)
+	singboxProcess := ProvideSingboxProcess(cfg)
+	statsCollector := ProvideStatsCollector(cfg)
+	coreAPI := ProvideGRPCClient(cfg, embeddedCore)
+	managerParams := ProvideManagerParams(cfg, panel, configGenerator, singboxGenerator, coreProcess, singboxProcess, statsCollector, coreAPI)
+	mgr := ProvideManager(managerParams)
+	adminServer := ProvideAdminServer(cfg, mgr, source)
+	metricsServer := ProvideMetricsServer(cfg, mgr, panelClient)
+	agent := ProvideAgent(cfg, mgr, adminServer, metricsServer)
+	return agent, nil
+}
+
 // ProvideConfig provides Config from config path
 func ProvideConfig(configPath string) (*config.Config, error) {
 	return config.Load(configPath)
@@ -40,42 +75,94 @@ func ProvideClient(cfg *config.Config) *client.Client {
 	return client.New(cfg)
 }
 
+// ProvideStandaloneSource provides the local config, users and traffic files
+func ProvideStandaloneSource(cfg *config.Config) *standalone.Source {
+	return standalone.NewSource(cfg)
+}
+
+// ProvidePanel provides the source of config and users: Panel, or the local
+// files in standalone mode
+func ProvidePanel(cfg *config.Config, c *client.Client, src *standalone.Source) client.Panel {
+	if cfg.Standalone.Enabled {
+		return src
+	}
+	return c
+}
+
 // ProvideConfigGenerator provides Xray ConfigGenerator
 func ProvideConfigGenerator(cfg *config.Config) *xray.ConfigGenerator {
-	return xray.NewConfigGenerator(cfg.Xray.ConfigPath)
+	generator := xray.NewConfigGenerator(cfg.Xray.ConfigPath, cfg.Xray.APIAddress)
+	if cfg.Xray.Embedded {
+		generator.DisableAPI()
+	}
+	if cfg.Xray.Handover {
+		generator.ReserveAPIAddress(cfg.Xray.StandbyAPIAddress)
+	}
+	if strings.EqualFold(cfg.Core.Type, config.CoreModeDual) {
+		generator.ReserveAPIAddress(cfg.Singbox.APIAddress)
+	}
+	return generator
+}
+
+// ProvideSingboxGenerator provides sing-box ConfigGenerator
+func ProvideSingboxGenerator(cfg *config.Config) *singbox.ConfigGenerator {
+	return singbox.NewConfigGenerator(cfg.Singbox.ConfigPath, cfg.Singbox.APIAddress)
+}
+
+// ProvideEmbeddedCore provides the in-process Xray core
+func ProvideEmbeddedCore(cfg *config.Config) *xray.EmbeddedCore {
+	return xray.NewEmbeddedCore(cfg.Xray.ConfigPath, cfg.Xray.AssetPath)
 }
 
-// ProvideProcessManager provides Xray ProcessManager
-func ProvideProcessManager(cfg *config.Config) *xray.ProcessManager {
+// ProvideProcessManager provides the Xray process, embedded or external binary
+func ProvideProcessManager(cfg *config.Config, embedded *xray.EmbeddedCore) xray.CoreProcess {
+	if cfg.Xray.Embedded {
+		return embedded
+	}
 	return xray.NewProcessManager(cfg.Xray.BinaryPath, cfg.Xray.ConfigPath, cfg.Xray.AssetPath)
 }
 
+// ProvideSingboxProcess provides sing-box ProcessManager
+func ProvideSingboxProcess(cfg *config.Config) *manager.SingboxProcess {
+	return &manager.SingboxProcess{
+		Process: xray.NewCoreProcessManager("sing-box", cfg.Singbox.BinaryPath, cfg.Singbox.ConfigPath, cfg.Xray.AssetPath),
+		GRPC:    xray.NewGRPCClient(cfg.Singbox.APIAddress),
+	}
+}
+
 // ProvideStatsCollector provides StatsCollector
 func ProvideStatsCollector(cfg *config.Config) *reporter.StatsCollector {
 	return reporter.NewStatsCollector(cfg.Xray.APIAddress)
 }
 
-// ProvideGRPCClient provides Xray GRPCClient
-func ProvideGRPCClient(cfg *config.Config) *xray.GRPCClient {
+// ProvideGRPCClient provides the Xray API, embedded or gRPC
+func ProvideGRPCClient(cfg *config.Config, embedded *xray.EmbeddedCore) xray.CoreAPI {
+	if cfg.Xray.Embedded {
+		return embedded
+	}
 	return xray.NewGRPCClient(cfg.Xray.APIAddress)
 }
 
 // ProvideManagerParams provides ManagerParams for Manager
 func ProvideManagerParams(
 	cfg *config.Config,
-	client *client.Client,
+	panel client.Panel,
 	generator *xray.ConfigGenerator,
-	process *xray.ProcessManager,
+	singboxGenerator *singbox.ConfigGenerator,
+	process xray.CoreProcess,
+	singboxProcess *manager.SingboxProcess,
 	stats *reporter.StatsCollector,
-	grpc *xray.GRPCClient,
+	api xray.CoreAPI,
 ) manager.ManagerParams {
 	return manager.ManagerParams{
-		Cfg:       cfg,
-		Client:    client,
-		Generator: generator,
-		Process:   process,
-		Stats:     stats,
-		GRPC:      grpc,
+		Cfg:            cfg,
+		Client:         panel,
+		Generator:      generator,
+		Singbox:        singboxGenerator,
+		Process:        process,
+		SingboxProcess: singboxProcess,
+		Stats:          stats,
+		API:            api,
 	}
 }
 
@@ -83,3 +170,22 @@ func ProvideManagerParams(
 func ProvideManager(params manager.ManagerParams) *manager.Manager {
 	return manager.New(params)
 }
+
+// ProvideAdminServer provides the local admin API server
+func ProvideAdminServer(cfg *config.Config, mgr *manager.Manager, src *standalone.Source) *admin.Server {
+	server := admin.NewServer(cfg, mgr)
+	if cfg.Standalone.Enabled {
+		server.Handle("/v1/traffic", src.TrafficHandler())
+	}
+	return server
+}
+
+// ProvideMetricsServer provides the Prometheus metrics server
+func ProvideMetricsServer(cfg *config.Config, mgr *manager.Manager, c *client.Client) *metrics.Server {
+	return metrics.NewServer(cfg, mgr, c)
+}
+
+// ProvideAgent provides Agent
+func ProvideAgent(cfg *config.Config, mgr *manager.Manager, adminServer *admin.Server, metricsServer *metrics.Server) *Agent {
+	return &Agent{Config: cfg, Manager: mgr, Admin: adminServer, Metrics: metricsServer}
+}
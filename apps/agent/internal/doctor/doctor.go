@@ -0,0 +1,378 @@
+// Package doctor diagnoses the installation of a node: config, core binaries,
+// assets, Panel reachability, core APIs, ports and clock
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/synexim/panel-agent/internal/admin"
+	"github.com/synexim/panel-agent/internal/client"
+	"github.com/synexim/panel-agent/internal/config"
+	"github.com/synexim/panel-agent/internal/preflight"
+	"github.com/synexim/panel-agent/internal/singbox"
+	"github.com/synexim/panel-agent/internal/standalone"
+	"github.com/synexim/panel-agent/internal/xray"
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// Clock offsets to Panel that are reported as warning or failure. VMess
+// rejects clients more than 90s off, TLS and Reality get flaky earlier.
+const (
+	clockWarnOffset = 10 * time.Second
+	clockFailOffset = 60 * time.Second
+)
+
+// xrayAssets are the geo files routing rules load from the asset path
+var xrayAssets = []string{"geoip.dat", "geosite.dat"}
+
+// Doctor runs the checks
+type Doctor struct {
+	cfg     *config.Config
+	client  *client.Client
+	version string
+
+	report     *types.DoctorReport
+	nodeConfig *types.NodeConfig // from Panel, nil when it could not be fetched
+	running    bool              // an agent answers on the admin API
+}
+
+// New creates a Doctor for the agent config
+func New(cfg *config.Config, c *client.Client, version string) *Doctor {
+	return &Doctor{cfg: cfg, client: c, version: version}
+}
+
+// Run runs every check and returns the report
+func (d *Doctor) Run(ctx context.Context) *types.DoctorReport {
+	d.report = &types.DoctorReport{
+		AgentVersion: d.version,
+		Healthy:      true,
+		CreatedAt:    time.Now().Unix(),
+	}
+
+	d.checkConfig()
+	d.checkCores()
+	if d.cfg.Standalone.Enabled {
+		d.checkStandalone(ctx)
+	} else {
+		d.checkPanel(ctx)
+	}
+	d.checkAssets()
+	d.checkAgent(ctx)
+	d.checkCoreAPIs(ctx)
+	d.checkPorts()
+	return d.report
+}
+
+func (d *Doctor) add(name, status, format string, args ...interface{}) {
+	d.report.Checks = append(d.report.Checks, types.DoctorCheck{
+		Name:   name,
+		Status: status,
+		Detail: fmt.Sprintf(format, args...),
+	})
+	if status == types.CheckFail {
+		d.report.Healthy = false
+	}
+}
+
+func (d *Doctor) usesXray() bool {
+	return strings.EqualFold(d.cfg.Core.Type, config.CoreModeDual) || types.ParseCoreType(d.cfg.Core.Type) != types.CoreTypeSingbox
+}
+
+func (d *Doctor) usesSingbox() bool {
+	return strings.EqualFold(d.cfg.Core.Type, config.CoreModeDual) || types.ParseCoreType(d.cfg.Core.Type) == types.CoreTypeSingbox
+}
+
+// checkConfig checks the settings every install needs and that the core
+// config files can be written
+func (d *Doctor) checkConfig() {
+	switch {
+	case d.cfg.Standalone.Enabled:
+		d.add("config", types.CheckPass, "standalone, core mode %s", d.cfg.Core.Type)
+	case d.cfg.Panel.URL == "":
+		d.add("config", types.CheckFail, "panel.url is not set")
+	case d.cfg.Panel.Token == "":
+		d.add("config", types.CheckFail, "panel.token (NODE_TOKEN) is not set")
+	default:
+		d.add("config", types.CheckPass, "panel %s, core mode %s", d.cfg.Panel.URL, d.cfg.Core.Type)
+	}
+
+	if d.usesXray() && !d.cfg.Xray.Embedded {
+		d.checkWritable("xray config", d.cfg.Xray.ConfigPath)
+	}
+	if d.usesSingbox() {
+		d.checkWritable("sing-box config", d.cfg.Singbox.ConfigPath)
+	}
+	if file := d.cfg.Log.File; file != "" {
+		if _, err := os.Stat(filepath.Dir(file)); os.IsNotExist(err) {
+			d.add("log file", types.CheckWarn, "%s does not exist yet, the agent creates it", filepath.Dir(file))
+		} else {
+			d.checkWritable("log file", file)
+		}
+	}
+}
+
+// checkWritable checks that a file can be created next to path
+func (d *Doctor) checkWritable(name, path string) {
+	f, err := os.CreateTemp(filepath.Dir(path), ".doctor-*")
+	if err != nil {
+		d.add(name, types.CheckFail, "cannot write %s: %v", path, err)
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+	d.add(name, types.CheckPass, "%s is writable", path)
+}
+
+// checkCores checks that the core binaries exist and report a version
+func (d *Doctor) checkCores() {
+	if d.usesXray() {
+		if d.cfg.Xray.Embedded {
+			caps := xray.DetectEmbeddedCapabilities()
+			d.add("xray core", types.CheckPass, "embedded xray-core %s", caps.Version)
+		} else {
+			d.checkBinary("xray core", d.cfg.Xray.BinaryPath, func() *types.CoreCapabilities {
+				return xray.DetectCapabilities(d.cfg.Xray.BinaryPath)
+			})
+		}
+	}
+	if d.usesSingbox() {
+		d.checkBinary("sing-box core", d.cfg.Singbox.BinaryPath, func() *types.CoreCapabilities {
+			return singbox.DetectCapabilities(d.cfg.Singbox.BinaryPath)
+		})
+	}
+}
+
+func (d *Doctor) checkBinary(name, path string, detect func() *types.CoreCapabilities) {
+	if _, err := exec.LookPath(path); err != nil {
+		d.add(name, types.CheckFail, "binary %s: %v", path, err)
+		return
+	}
+	caps := detect()
+	if caps.Version == "unknown" {
+		d.add(name, types.CheckFail, "%s does not report a version, is it the right binary?", path)
+		return
+	}
+	d.add(name, types.CheckPass, "%s %s, inbound protocols: %s", path, caps.Version, strings.Join(caps.Protocols.Inbound, ", "))
+}
+
+// checkPanel checks that Panel is reachable, accepts the token and agrees on
+// the time, then fetches the node config for the remaining checks
+func (d *Doctor) checkPanel(ctx context.Context) {
+	if d.cfg.Panel.URL == "" {
+		return
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	probe, err := d.client.Probe(probeCtx)
+	if err != nil {
+		d.add("panel", types.CheckFail, "unreachable: %v", err)
+		return
+	}
+	switch probe.StatusCode {
+	case http.StatusOK, http.StatusNotModified:
+		d.add("panel", types.CheckPass, "reachable in %s, token accepted", probe.Latency.Round(time.Millisecond))
+	case http.StatusUnauthorized, http.StatusForbidden:
+		d.add("panel", types.CheckFail, "token rejected (%d)", probe.StatusCode)
+	default:
+		d.add("panel", types.CheckFail, "unexpected response %d", probe.StatusCode)
+	}
+	d.checkClock(probe)
+
+	if probe.StatusCode == http.StatusOK {
+		if nodeConfig, _, err := d.client.GetConfig(probeCtx); err == nil {
+			d.nodeConfig = nodeConfig
+		}
+	}
+}
+
+// checkStandalone checks that the local config and users files parse, and
+// takes the node config for the remaining checks
+func (d *Doctor) checkStandalone(ctx context.Context) {
+	src := standalone.NewSource(d.cfg)
+	nodeConfig, _, err := src.GetConfig(ctx)
+	if err != nil {
+		d.add("standalone config", types.CheckFail, "%v", err)
+	} else {
+		d.nodeConfig = nodeConfig
+		d.add("standalone config", types.CheckPass, "%s, version %s, %d inbounds", d.cfg.Standalone.ConfigFile, nodeConfig.Version, len(nodeConfig.Inbounds))
+	}
+
+	users, _, err := src.GetUsers(ctx)
+	if err != nil {
+		d.add("standalone users", types.CheckFail, "%v", err)
+	} else {
+		d.add("standalone users", types.CheckPass, "%s, %d users", d.cfg.Standalone.UsersFile, len(users.Users))
+	}
+	// The agent creates the store directory on the first report
+	if _, err := os.Stat(filepath.Dir(d.cfg.Standalone.TrafficFile)); os.IsNotExist(err) {
+		d.add("traffic store", types.CheckWarn, "%s does not exist yet, the agent creates it", filepath.Dir(d.cfg.Standalone.TrafficFile))
+		return
+	}
+	d.checkWritable("traffic store", d.cfg.Standalone.TrafficFile)
+}
+
+// checkClock compares the local clock with the Date header of Panel
+func (d *Doctor) checkClock(probe *client.ProbeResult) {
+	if probe.ServerTime.IsZero() {
+		d.add("clock", types.CheckWarn, "Panel sent no Date header, offset unknown")
+		return
+	}
+	// The Date header has second precision and was set about halfway through the request
+	offset := time.Since(probe.ServerTime.Add(probe.Latency / 2)).Round(time.Second)
+	abs := offset
+	if abs < 0 {
+		abs = -abs
+	}
+	switch {
+	case abs >= clockFailOffset:
+		d.add("clock", types.CheckFail, "local clock is %s off Panel, sync it with NTP", offset)
+	case abs >= clockWarnOffset:
+		d.add("clock", types.CheckWarn, "local clock is %s off Panel", offset)
+	default:
+		d.add("clock", types.CheckPass, "offset to Panel %s", offset)
+	}
+}
+
+// checkAssets checks the geo files in the xray asset path. Missing files fail
+// only when the node's routing rules use them.
+func (d *Doctor) checkAssets() {
+	if !d.usesXray() {
+		return
+	}
+	dir := d.cfg.Xray.AssetPath
+	if dir == "" {
+		dir = filepath.Dir(d.cfg.Xray.BinaryPath)
+	}
+
+	var missing []string
+	for _, name := range xrayAssets {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			missing = append(missing, name)
+		}
+	}
+	switch {
+	case len(missing) == 0:
+		d.add("assets", types.CheckPass, "%s in %s", strings.Join(xrayAssets, ", "), dir)
+	case d.usesGeoRules():
+		d.add("assets", types.CheckFail, "%s missing in %s but routing rules use geoip/geosite", strings.Join(missing, ", "), dir)
+	default:
+		d.add("assets", types.CheckWarn, "%s missing in %s", strings.Join(missing, ", "), dir)
+	}
+}
+
+// usesGeoRules reports whether a routing rule references geoip: or geosite:
+func (d *Doctor) usesGeoRules() bool {
+	if d.nodeConfig == nil || d.nodeConfig.Routing == nil {
+		return false
+	}
+	for _, rule := range d.nodeConfig.Routing.Rules {
+		text := fmt.Sprint(rule)
+		if strings.Contains(text, "geoip:") || strings.Contains(text, "geosite:") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAgent checks whether an agent is running by calling its admin API
+func (d *Doctor) checkAgent(ctx context.Context) {
+	if !d.cfg.Admin.Enabled {
+		d.add("agent", types.CheckWarn, "admin API disabled, cannot tell whether the agent runs")
+		return
+	}
+	stateCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	state, err := admin.NewClient(d.cfg.Admin).State(stateCtx)
+	if err != nil {
+		d.add("agent", types.CheckWarn, "not running: %v", err)
+		return
+	}
+	d.running = true
+	d.add("agent", types.CheckPass, "running since %s", time.Unix(state.StartedAt, 0).Format(time.RFC3339))
+}
+
+// checkCoreAPIs pings the gRPC API of every external core. An unreachable
+// API fails only while the agent runs, otherwise its cores are down anyway.
+// With handover the live Xray core is on either of the two API addresses.
+func (d *Doctor) checkCoreAPIs(ctx context.Context) {
+	if d.usesXray() && !d.cfg.Xray.Embedded {
+		addresses := []string{d.cfg.Xray.APIAddress}
+		if d.cfg.Xray.Handover && d.cfg.Xray.StandbyAPIAddress != "" {
+			addresses = append(addresses, d.cfg.Xray.StandbyAPIAddress)
+		}
+		d.checkCoreAPI(ctx, "xray api", addresses...)
+	}
+	if d.usesSingbox() {
+		d.checkCoreAPI(ctx, "sing-box api", d.cfg.Singbox.APIAddress)
+	}
+}
+
+func (d *Doctor) checkCoreAPI(ctx context.Context, name string, addresses ...string) {
+	listed := strings.Join(addresses, " or ")
+	var err error
+	for _, address := range addresses {
+		pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err = xray.NewGRPCClient(address).Ping(pingCtx)
+		cancel()
+		if err == nil {
+			d.add(name, types.CheckPass, "%s answers", address)
+			break
+		}
+	}
+	switch {
+	case err == nil:
+	case d.running:
+		d.add(name, types.CheckFail, "%s unreachable while the agent runs: %v", listed, err)
+	default:
+		d.add(name, types.CheckWarn, "%s unreachable, the agent is not running", listed)
+	}
+
+	// A stopped node needs the API ports free for the core to start
+	for _, address := range addresses {
+		endpoint, err := xray.ParseAPIEndpoint(address)
+		if err != nil {
+			d.add(name, types.CheckFail, "%v", err)
+			continue
+		}
+		if !d.running && endpoint.Network == "tcp" {
+			listener, err := net.Listen("tcp", endpoint.Target())
+			if err != nil {
+				d.add(name, types.CheckFail, "port of %s is taken by another process: %v", address, err)
+				continue
+			}
+			listener.Close()
+		}
+	}
+}
+
+// checkPorts checks the node's inbound ports for duplicates and, while no
+// agent runs, for other processes holding them
+func (d *Doctor) checkPorts() {
+	if d.nodeConfig == nil {
+		d.add("ports", types.CheckWarn, "skipped, node config not fetched from Panel")
+		return
+	}
+	inbounds := xray.AllInbounds(d.nodeConfig)
+	var owned []types.InboundConfig
+	if d.running {
+		owned = inbounds // held by the running cores
+	}
+
+	conflicts := preflight.CheckPorts(inbounds, owned)
+	if len(conflicts) == 0 {
+		d.add("ports", types.CheckPass, "%d inbound ports free of conflicts", len(inbounds))
+		return
+	}
+	for _, c := range conflicts {
+		d.add("ports", types.CheckFail, "inbound %s %s/%d: %s", c.Tag, c.Network, c.Port, c.Reason)
+	}
+}
@@ -13,34 +13,36 @@ import (
 	"google.golang.org/grpc/keepalive"
 
 	pb "github.com/synexim/panel-agent/internal/grpc/proto"
+	"github.com/synexim/panel-agent/pkg/types"
 )
 
 // StreamClient manages the bidirectional gRPC stream connection
 type StreamClient struct {
-	conn           *grpc.ClientConn
-	client         pb.AgentServiceClient
-	stream         pb.AgentService_ConnectClient
-	nodeID         string
-	token          string
-	version        string
-	coreType       string
-	coreVersion    string
-	
+	conn        *grpc.ClientConn
+	client      pb.AgentServiceClient
+	stream      pb.AgentService_ConnectClient
+	nodeID      string
+	token       string
+	version     string
+	coreType    string
+	coreVersion string
+
 	// Callbacks
-	onConfig       func(config string, etag string)
-	onUsersUpdate  func(added []*pb.UserConfig, removed []string)
-	onKickUsers    func(emails []string, reason string)
-	onRateLimit    func(email string, uploadLimit, downloadLimit int64)
-	
+	onConfig      func(config string, etag string)
+	onUsersUpdate func(added []*pb.UserConfig, removed []string)
+	onKickUsers   func(emails []string, reason string)
+	onRateLimit   func(email string, uploadLimit, downloadLimit int64)
+	onIntervals   func(intervals types.Intervals)
+
 	// State
-	mu             sync.RWMutex
-	connected      bool
+	mu              sync.RWMutex
+	connected       bool
 	trafficInterval int32
 	statusInterval  int32
-	
+
 	// Channels
-	sendCh         chan *pb.AgentMessage
-	stopCh         chan struct{}
+	sendCh chan *pb.AgentMessage
+	stopCh chan struct{}
 }
 
 // NewStreamClient creates a new gRPC stream client
@@ -69,6 +71,15 @@ func (c *StreamClient) SetCallbacks(
 	c.onRateLimit = onRateLimit
 }
 
+// SetIntervalsCallback sets the function called with the traffic and status
+// intervals Panel sends on register, see Manager.AttachStream. It may be set
+// while the stream is connected.
+func (c *StreamClient) SetIntervalsCallback(onIntervals func(intervals types.Intervals)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onIntervals = onIntervals
+}
+
 // Connect establishes the gRPC connection and stream
 func (c *StreamClient) Connect(ctx context.Context, address string) error {
 	// Create connection with keepalive
@@ -218,8 +229,6 @@ func (c *StreamClient) handleMessage(msg *pb.PanelMessage) {
 
 func (c *StreamClient) handleRegisterResponse(resp *pb.RegisterResponse) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	
 	if resp.Success {
 		c.connected = true
 		c.trafficInterval = resp.TrafficInterval
@@ -227,6 +236,15 @@ func (c *StreamClient) handleRegisterResponse(resp *pb.RegisterResponse) {
 	} else {
 		c.connected = false
 	}
+	onIntervals := c.onIntervals
+	c.mu.Unlock()
+
+	if resp.Success && onIntervals != nil {
+		onIntervals(types.Intervals{
+			TrafficReportInterval: int(resp.TrafficInterval),
+			StatusReportInterval:  int(resp.StatusInterval),
+		})
+	}
 }
 
 // SendStatus sends a status report
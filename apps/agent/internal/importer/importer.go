@@ -0,0 +1,242 @@
+// Package importer converts existing Xray and x-ui installations into the
+// Panel format: a NodeConfig without users and the users of its inbounds
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/synexim/panel-agent/internal/xray"
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// Result is an imported node
+type Result struct {
+	Config   *types.NodeConfig
+	Users    []types.UserConfig
+	Warnings []string // entries that were skipped or changed on the way
+}
+
+// Request returns the result as sent to Panel
+func (r *Result) Request(source string) *types.ImportRequest {
+	return &types.ImportRequest{
+		Source: source,
+		Config: r.Config,
+		Users:  r.Users,
+	}
+}
+
+func (r *Result) warn(format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// client is a user entry of an inbound's settings, with the extra fields
+// x-ui stores next to the ones Xray reads
+type client struct {
+	// Xray
+	ID       string `json:"id"`
+	Password string `json:"password"`
+	Email    string `json:"email"`
+	Flow     string `json:"flow"`
+	AlterID  int    `json:"alterId"`
+	Security string `json:"security"`
+	Method   string `json:"method"`
+	Level    int    `json:"level"`
+	User     string `json:"user"` // socks and http accounts
+	Pass     string `json:"pass"`
+
+	// x-ui
+	Enable     *bool `json:"enable"`
+	LimitIP    int   `json:"limitIp"`
+	TotalGB    int64 `json:"totalGB"` // bytes, despite the name
+	ExpiryTime int64 `json:"expiryTime"`
+}
+
+// userConfig converts a client of an inbound into a Panel user
+func (c *client) userConfig(protocol, tag string) types.UserConfig {
+	user := types.UserConfig{
+		Email:       c.Email,
+		Level:       c.Level,
+		InboundTags: []string{tag},
+		TotalBytes:  c.TotalGB,
+		ExpiryTime:  c.ExpiryTime,
+		DeviceLimit: c.LimitIP,
+	}
+	switch protocol {
+	case "vless":
+		user.UUID = c.ID
+		user.Flow = c.Flow
+	case "vmess":
+		user.UUID = c.ID
+		user.AlterID = c.AlterID
+		user.Security = c.Security
+	case "trojan":
+		user.Password = c.Password
+	case "shadowsocks":
+		user.Password = c.Password
+		user.Method = c.Method
+	case "socks", "http":
+		// Accounts are keyed by email on the agent side
+		user.Email = c.User
+		user.Password = c.Pass
+	}
+	return user
+}
+
+// inboundSettings splits the users off an inbound's settings, returning the
+// settings the agent fills users into and the clients that were removed
+func (r *Result) inboundSettings(tag, protocol string, settings map[string]interface{}) (map[string]interface{}, []client, error) {
+	proto, ok := xray.LookupProtocol(protocol)
+	if !ok || settings == nil {
+		return settings, nil, nil
+	}
+	key := proto.SettingsKey()
+	raw, ok := settings[key]
+	if !ok {
+		if protocol == "shadowsocks" {
+			return r.singleUserShadowsocks(tag, settings)
+		}
+		return settings, nil, nil
+	}
+	delete(settings, key)
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	var clients []client
+	if err := json.Unmarshal(data, &clients); err != nil {
+		return nil, nil, fmt.Errorf("parse %s: %w", key, err)
+	}
+	return settings, clients, nil
+}
+
+// singleUserShadowsocks converts a classic Shadowsocks inbound, with method
+// and password in its settings and no clients, into one user of a legacy
+// multi-user inbound
+func (r *Result) singleUserShadowsocks(tag string, settings map[string]interface{}) (map[string]interface{}, []client, error) {
+	method, _ := settings["method"].(string)
+	password, _ := settings["password"].(string)
+	if password == "" {
+		return settings, nil, nil
+	}
+	if xray.IsShadowsocks2022(method) {
+		// The password stays the server key; clients of a single-user 2022
+		// inbound have no user key and must be reconfigured
+		r.warn("inbound %s: single-user %s inbound imported without users, add users in Panel", tag, method)
+		return settings, nil, nil
+	}
+	email, _ := settings["email"].(string)
+	level, _ := settings["level"].(float64)
+	delete(settings, "method")
+	delete(settings, "password")
+	delete(settings, "email")
+	delete(settings, "level")
+	r.warn("inbound %s: single-user shadowsocks converted to a user", tag)
+	return settings, []client{{Email: email, Method: method, Password: password, Level: int(level)}}, nil
+}
+
+// parsePort reads an inbound port, which Xray also accepts as a string.
+// Port ranges have no equivalent in an InboundConfig.
+func parsePort(raw json.RawMessage) (int, error) {
+	var port int
+	if err := json.Unmarshal(raw, &port); err == nil {
+		return port, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return 0, fmt.Errorf("invalid port %s", raw)
+	}
+	port, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("port %q is not a single port", s)
+	}
+	return port, nil
+}
+
+// userSet merges the clients of all inbounds into users, one per email
+type userSet struct {
+	result *Result
+	users  []types.UserConfig
+	index  map[string]int
+}
+
+func newUserSet(result *Result) *userSet {
+	return &userSet{result: result, index: make(map[string]int)}
+}
+
+// add adds a client of an inbound. A user already seen with the same
+// credentials gains the inbound; different credentials under the same email
+// are imported as a separate user since Panel emails are unique.
+func (s *userSet) add(user types.UserConfig) {
+	tag := user.InboundTags[0]
+	if user.Email == "" {
+		user.Email = fmt.Sprintf("%s-%d", tag, len(s.users)+1)
+		s.result.warn("inbound %s: client without email imported as %s", tag, user.Email)
+	}
+
+	i, ok := s.index[user.Email]
+	if !ok {
+		s.index[user.Email] = len(s.users)
+		s.users = append(s.users, user)
+		return
+	}
+
+	existing := &s.users[i]
+	if merged, ok := mergeCredentials(existing, &user); ok {
+		*existing = merged
+		existing.InboundTags = append(existing.InboundTags, tag)
+		return
+	}
+	renamed := user.Email + "-" + tag
+	s.result.warn("inbound %s: %s has other credentials than in %s, imported as %s",
+		tag, user.Email, strings.Join(existing.InboundTags, ", "), renamed)
+	user.Email = renamed
+	s.add(user)
+}
+
+// mergeCredentials combines two entries of one user, failing when both set
+// the same credential to different values
+func mergeCredentials(a, b *types.UserConfig) (types.UserConfig, bool) {
+	merged := *a
+	for _, field := range []struct {
+		dst *string
+		src string
+	}{
+		{&merged.UUID, b.UUID},
+		{&merged.Password, b.Password},
+		{&merged.Flow, b.Flow},
+		{&merged.Method, b.Method},
+		{&merged.Security, b.Security},
+	} {
+		switch {
+		case field.src == "":
+		case *field.dst == "":
+			*field.dst = field.src
+		case *field.dst != field.src:
+			return *a, false
+		}
+	}
+	// Limits and traffic of the same user across x-ui inbounds: keep the largest
+	merged.TotalBytes = max(merged.TotalBytes, b.TotalBytes)
+	merged.UsedBytes = max(merged.UsedBytes, b.UsedBytes)
+	merged.ExpiryTime = max(merged.ExpiryTime, b.ExpiryTime)
+	merged.DeviceLimit = max(merged.DeviceLimit, b.DeviceLimit)
+	return merged, true
+}
+
+// Combine takes the inbounds and users of an x-ui database and the
+// outbounds, routing, DNS and policy of the Xray config x-ui runs with,
+// which x-ui keeps in its config template rather than in the database
+func Combine(xui, xrayConfig *Result) *Result {
+	combined := *xui
+	nodeConfig := *xui.Config
+	nodeConfig.Outbounds = xrayConfig.Config.Outbounds
+	nodeConfig.Routing = xrayConfig.Config.Routing
+	nodeConfig.DNS = xrayConfig.Config.DNS
+	nodeConfig.Policy = xrayConfig.Config.Policy
+	combined.Config = &nodeConfig
+	return &combined
+}
@@ -0,0 +1,138 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	xjson "github.com/xtls/xray-core/infra/conf/json"
+
+	"github.com/synexim/panel-agent/internal/xray"
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// xrayConfig is the part of an Xray config.json the import keeps. Log, api
+// and stats are generated by the agent.
+type xrayConfig struct {
+	API *struct {
+		Tag string `json:"tag"`
+	} `json:"api"`
+	Inbounds  []xrayInbound          `json:"inbounds"`
+	Outbounds []types.OutboundConfig `json:"outbounds"`
+	Routing   *types.RoutingConfig   `json:"routing"`
+	DNS       interface{}            `json:"dns"`
+	Policy    interface{}            `json:"policy"`
+}
+
+type xrayInbound struct {
+	Tag            string                 `json:"tag"`
+	Protocol       string                 `json:"protocol"`
+	Port           json.RawMessage        `json:"port"`
+	Listen         string                 `json:"listen"`
+	Settings       map[string]interface{} `json:"settings"`
+	StreamSettings interface{}            `json:"streamSettings"`
+	Sniffing       interface{}            `json:"sniffing"`
+	Allocate       interface{}            `json:"allocate"`
+}
+
+// FromXray imports an Xray config.json. Comments are allowed as in Xray.
+func FromXray(data []byte) (*Result, error) {
+	var cfg xrayConfig
+	decoder := json.NewDecoder(&xjson.Reader{Reader: bytes.NewReader(data)})
+	if err := decoder.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("parse xray config: %w", err)
+	}
+
+	result := &Result{Config: &types.NodeConfig{
+		DNS:    cfg.DNS,
+		Policy: cfg.Policy,
+	}}
+	apiTag := ""
+	if cfg.API != nil {
+		apiTag = cfg.API.Tag
+	}
+	isAPI := func(tag string) bool {
+		return tag != "" && (tag == apiTag || tag == xray.APIInboundTag)
+	}
+
+	users := newUserSet(result)
+	apiInbounds := make(map[string]bool)
+	for i, in := range cfg.Inbounds {
+		if isAPI(in.Tag) {
+			apiInbounds[in.Tag] = true
+			continue
+		}
+		if in.Tag == "" {
+			in.Tag = fmt.Sprintf("inbound-%d", i+1)
+			result.warn("inbound #%d has no tag, imported as %s", i+1, in.Tag)
+		}
+		port, err := parsePort(in.Port)
+		if err != nil {
+			result.warn("inbound %s skipped: %v", in.Tag, err)
+			continue
+		}
+		settings, clients, err := result.inboundSettings(in.Tag, in.Protocol, in.Settings)
+		if err != nil {
+			result.warn("inbound %s skipped: %v", in.Tag, err)
+			continue
+		}
+
+		result.Config.Inbounds = append(result.Config.Inbounds, types.InboundConfig{
+			Tag:            in.Tag,
+			Protocol:       in.Protocol,
+			Port:           port,
+			Listen:         in.Listen,
+			Settings:       settings,
+			StreamSettings: in.StreamSettings,
+			Sniffing:       in.Sniffing,
+			Allocate:       in.Allocate,
+		})
+		for _, c := range clients {
+			if c.Enable != nil && !*c.Enable {
+				result.warn("inbound %s: disabled client %s skipped", in.Tag, c.Email)
+				continue
+			}
+			users.add(c.userConfig(in.Protocol, in.Tag))
+		}
+	}
+
+	for _, out := range cfg.Outbounds {
+		if isAPI(out.Tag) {
+			continue
+		}
+		result.Config.Outbounds = append(result.Config.Outbounds, out)
+	}
+	if cfg.Routing != nil {
+		result.Config.Routing = &types.RoutingConfig{
+			DomainStrategy: cfg.Routing.DomainStrategy,
+			Balancers:      cfg.Routing.Balancers,
+		}
+		for _, rule := range cfg.Routing.Rules {
+			if !isAPIRule(rule, apiTag, apiInbounds) {
+				result.Config.Routing.Rules = append(result.Config.Routing.Rules, rule)
+			}
+		}
+	}
+
+	result.Users = users.users
+	return result, nil
+}
+
+// isAPIRule reports whether a routing rule routes the stats API, which the
+// agent adds itself
+func isAPIRule(rule interface{}, apiTag string, apiInbounds map[string]bool) bool {
+	fields, ok := rule.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	if outbound, _ := fields["outboundTag"].(string); apiTag != "" && outbound == apiTag {
+		return true
+	}
+	tags, _ := fields["inboundTag"].([]interface{})
+	for _, tag := range tags {
+		if s, _ := tag.(string); apiInbounds[s] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,297 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// sqliteHeader starts every SQLite database file
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+// XUIExport is the content of an x-ui (3x-ui) database
+type XUIExport struct {
+	Inbounds       []xuiInbound       `json:"inbounds"`
+	ClientTraffics []xuiClientTraffic `json:"client_traffics"`
+}
+
+// xuiInbound is a row of the inbounds table. Settings, stream settings,
+// sniffing and allocate are JSON stored as text.
+type xuiInbound struct {
+	ID             int64
+	Up, Down       int64
+	Total          int64
+	ExpiryTime     int64
+	Remark         string
+	Enable         bool
+	Listen         string
+	Port           int
+	Protocol       string
+	Tag            string
+	Settings       map[string]interface{}
+	StreamSettings interface{}
+	Sniffing       interface{}
+	Allocate       interface{}
+	ClientStats    []xuiClientTraffic
+}
+
+// xuiClientTraffic is a row of the client_traffics table, 3x-ui only
+type xuiClientTraffic struct {
+	InboundID  int64
+	Email      string
+	Up, Down   int64
+	Total      int64
+	ExpiryTime int64
+}
+
+// LoadXUI reads an x-ui database: the SQLite file itself, read with the
+// sqlite3 command, or a JSON export of it. JSON exports are either
+// {"inbounds": [...], "client_traffics": [...]} with the rows of both tables
+// (sqlite3 -json), a bare list of inbounds, or the inbound list of the 3x-ui
+// API ({"obj": [...]}).
+func LoadXUI(path string) (*XUIExport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, sqliteHeader) {
+		return querySQLite(path)
+	}
+
+	var export XUIExport
+	var wrapper struct {
+		Obj []xuiInbound `json:"obj"`
+	}
+	switch trimmed := bytes.TrimSpace(data); {
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		err = json.Unmarshal(data, &export.Inbounds)
+	case bytes.Contains(trimmed, []byte(`"obj"`)):
+		err = json.Unmarshal(data, &wrapper)
+		export.Inbounds = wrapper.Obj
+	default:
+		err = json.Unmarshal(data, &export)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse x-ui export: %w", err)
+	}
+	return &export, nil
+}
+
+// querySQLite dumps the tables with the sqlite3 command, there is no SQLite
+// driver in the agent
+func querySQLite(path string) (*XUIExport, error) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		return nil, fmt.Errorf("%s is a SQLite database and sqlite3 is not installed, export it with: sqlite3 -json %s 'SELECT * FROM inbounds'", path, path)
+	}
+	query := func(table string, v interface{}) error {
+		out, err := exec.Command("sqlite3", "-readonly", "-json", path, "SELECT * FROM "+table).Output()
+		if err != nil {
+			return fmt.Errorf("query %s: %w", table, err)
+		}
+		if len(bytes.TrimSpace(out)) == 0 {
+			return nil // sqlite3 prints nothing for an empty table
+		}
+		return json.Unmarshal(out, v)
+	}
+
+	var export XUIExport
+	if err := query("inbounds", &export.Inbounds); err != nil {
+		return nil, err
+	}
+	// x-ui before 3x-ui has no per-client traffic
+	query("client_traffics", &export.ClientTraffics)
+	return &export, nil
+}
+
+// FromXUI imports the inbounds and clients of an x-ui database. Disabled
+// inbounds and clients are skipped, used traffic comes from client_traffics
+// or, for inbounds with a single client, from the inbound.
+func FromXUI(export *XUIExport) (*Result, error) {
+	result := &Result{Config: &types.NodeConfig{}}
+	traffic := make(map[string]xuiClientTraffic)
+	for _, t := range export.ClientTraffics {
+		traffic[t.Email] = t
+	}
+	for _, in := range export.Inbounds {
+		for _, t := range in.ClientStats {
+			traffic[t.Email] = t
+		}
+	}
+
+	users := newUserSet(result)
+	for _, in := range export.Inbounds {
+		if in.Tag == "" {
+			in.Tag = fmt.Sprintf("inbound-%d", in.Port)
+		}
+		if !in.Enable {
+			result.warn("inbound %s (%s) is disabled, skipped", in.Tag, in.Remark)
+			continue
+		}
+		settings, clients, err := result.inboundSettings(in.Tag, in.Protocol, in.Settings)
+		if err != nil {
+			result.warn("inbound %s skipped: %v", in.Tag, err)
+			continue
+		}
+
+		result.Config.Inbounds = append(result.Config.Inbounds, types.InboundConfig{
+			Tag:            in.Tag,
+			Protocol:       in.Protocol,
+			Port:           in.Port,
+			Listen:         in.Listen,
+			Settings:       settings,
+			StreamSettings: in.StreamSettings,
+			Sniffing:       in.Sniffing,
+			Allocate:       in.Allocate,
+		})
+
+		for _, c := range clients {
+			if c.Enable != nil && !*c.Enable {
+				result.warn("inbound %s: disabled client %s skipped", in.Tag, c.Email)
+				continue
+			}
+			user := c.userConfig(in.Protocol, in.Tag)
+			if t, ok := traffic[user.Email]; ok && user.Email != "" {
+				user.UsedBytes = t.Up + t.Down
+				user.TotalBytes = orDefault(user.TotalBytes, t.Total)
+				user.ExpiryTime = orDefault(user.ExpiryTime, t.ExpiryTime)
+			} else if len(clients) == 1 {
+				// Old x-ui: one client per inbound, limits on the inbound
+				user.UsedBytes = in.Up + in.Down
+				user.TotalBytes = orDefault(user.TotalBytes, in.Total)
+				user.ExpiryTime = orDefault(user.ExpiryTime, in.ExpiryTime)
+			}
+			if user.ExpiryTime < 0 {
+				// 3x-ui "start on first use": a negative duration, not a date
+				result.warn("inbound %s: %s expires %d days after first use, imported without expiry",
+					in.Tag, user.Email, -user.ExpiryTime/86400000)
+				user.ExpiryTime = 0
+			}
+			users.add(user)
+		}
+	}
+
+	result.Users = users.users
+	return result, nil
+}
+
+func orDefault(v, fallback int64) int64 {
+	if v != 0 {
+		return v
+	}
+	return fallback
+}
+
+// UnmarshalJSON reads an inbound row from sqlite3 -json (snake_case columns,
+// 0/1 booleans) or from the 3x-ui API (camelCase, true/false)
+func (in *xuiInbound) UnmarshalJSON(data []byte) error {
+	var row xuiRow
+	if err := json.Unmarshal(data, &row); err != nil {
+		return err
+	}
+	in.ID = row.int("id")
+	in.Up = row.int("up")
+	in.Down = row.int("down")
+	in.Total = row.int("total")
+	in.ExpiryTime = row.int("expiry_time", "expiryTime")
+	in.Remark = row.string("remark")
+	in.Enable = row.bool("enable")
+	in.Listen = row.string("listen")
+	in.Port = int(row.int("port"))
+	in.Protocol = row.string("protocol")
+	in.Tag = row.string("tag")
+	if err := row.embedded(&in.Settings, "settings"); err != nil {
+		return fmt.Errorf("inbound %d settings: %w", in.ID, err)
+	}
+	if err := row.embedded(&in.StreamSettings, "stream_settings", "streamSettings"); err != nil {
+		return fmt.Errorf("inbound %d stream settings: %w", in.ID, err)
+	}
+	if err := row.embedded(&in.Sniffing, "sniffing"); err != nil {
+		return fmt.Errorf("inbound %d sniffing: %w", in.ID, err)
+	}
+	if err := row.embedded(&in.Allocate, "allocate"); err != nil {
+		return fmt.Errorf("inbound %d allocate: %w", in.ID, err)
+	}
+	if raw, ok := row.field("clientStats"); ok {
+		json.Unmarshal(raw, &in.ClientStats)
+	}
+	return nil
+}
+
+// UnmarshalJSON reads a client_traffics row in either naming
+func (t *xuiClientTraffic) UnmarshalJSON(data []byte) error {
+	var row xuiRow
+	if err := json.Unmarshal(data, &row); err != nil {
+		return err
+	}
+	t.InboundID = row.int("inbound_id", "inboundId")
+	t.Email = row.string("email")
+	t.Up = row.int("up")
+	t.Down = row.int("down")
+	t.Total = row.int("total")
+	t.ExpiryTime = row.int("expiry_time", "expiryTime")
+	return nil
+}
+
+// xuiRow is a database row with loosely typed columns
+type xuiRow map[string]json.RawMessage
+
+func (r xuiRow) field(names ...string) (json.RawMessage, bool) {
+	for _, name := range names {
+		if raw, ok := r[name]; ok && string(raw) != "null" {
+			return raw, true
+		}
+	}
+	return nil, false
+}
+
+func (r xuiRow) string(names ...string) string {
+	raw, _ := r.field(names...)
+	var s string
+	json.Unmarshal(raw, &s)
+	return s
+}
+
+func (r xuiRow) int(names ...string) int64 {
+	raw, ok := r.field(names...)
+	if !ok {
+		return 0
+	}
+	var n json.Number
+	if err := json.Unmarshal(raw, &n); err != nil {
+		// Numbers stored as text
+		n = json.Number(strings.Trim(string(raw), `"`))
+	}
+	v, _ := strconv.ParseInt(n.String(), 10, 64)
+	return v
+}
+
+func (r xuiRow) bool(names ...string) bool {
+	raw, _ := r.field(names...)
+	switch string(raw) {
+	case "true", "1", `"1"`, `"true"`:
+		return true
+	}
+	return false
+}
+
+// embedded decodes a column holding JSON, stored as text or as an object
+func (r xuiRow) embedded(v interface{}, names ...string) error {
+	raw, ok := r.field(names...)
+	if !ok {
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		if strings.TrimSpace(text) == "" {
+			return nil
+		}
+		raw = json.RawMessage(text)
+	}
+	return json.Unmarshal(raw, v)
+}
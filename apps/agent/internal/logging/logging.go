@@ -0,0 +1,220 @@
+// Package logging sets up the agent's logs from the log section of the
+// config: level, console or JSON format, an optional rotated file,
+// per-component levels and redaction of credentials.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/synexim/panel-agent/internal/config"
+)
+
+// Formats of log.format
+const (
+	FormatConsole = "console"
+	FormatJSON    = "json"
+)
+
+var (
+	mu         sync.Mutex
+	out        = &output{}
+	components = make(map[string]*Logger)
+	configured bool
+	baseLevel  atomic.Int32             // log.level, filters the global logger
+	levels     map[string]zerolog.Level // log.components
+)
+
+// Logger is the logger of one component. Its level is
+// log.components.<name>, or log.level when that is not set.
+type Logger struct {
+	name   string
+	logger atomic.Pointer[zerolog.Logger]
+}
+
+// Component returns the logger of a component, usually kept in a package
+// variable. Until Setup runs it writes to the global logger.
+func Component(name string) *Logger {
+	mu.Lock()
+	defer mu.Unlock()
+	if l, ok := components[name]; ok {
+		return l
+	}
+	l := &Logger{name: name}
+	if configured {
+		l.configure()
+	}
+	components[name] = l
+	return l
+}
+
+// configure derives the component logger from the global one; mu must be held
+func (l *Logger) configure() {
+	level, ok := levels[l.name]
+	if !ok {
+		level = zerolog.Level(baseLevel.Load())
+	}
+	logger := zerolog.New(out).With().Timestamp().Str("component", l.name).Logger().Level(level)
+	l.logger.Store(&logger)
+}
+
+// levelFilter drops the global logger's events below log.level. The global
+// logger is set once, reloads only change the level it is filtered at.
+type levelFilter struct{}
+
+func (levelFilter) Run(e *zerolog.Event, level zerolog.Level, _ string) {
+	if level < zerolog.Level(baseLevel.Load()) {
+		e.Discard()
+	}
+}
+
+func (l *Logger) get() *zerolog.Logger {
+	if logger := l.logger.Load(); logger != nil {
+		return logger
+	}
+	return &log.Logger
+}
+
+// Trace starts a trace level event
+func (l *Logger) Trace() *zerolog.Event { return l.get().Trace() }
+
+// Debug starts a debug level event
+func (l *Logger) Debug() *zerolog.Event { return l.get().Debug() }
+
+// Info starts an info level event
+func (l *Logger) Info() *zerolog.Event { return l.get().Info() }
+
+// Warn starts a warn level event
+func (l *Logger) Warn() *zerolog.Event { return l.get().Warn() }
+
+// Error starts an error level event
+func (l *Logger) Error() *zerolog.Event { return l.get().Error() }
+
+// Setup replaces the global logger and the component loggers according to
+// cfg. It can run again on a config reload; a log file is then reopened,
+// which also picks up a file moved away by an external logrotate.
+func Setup(cfg config.LogConfig) error {
+	level, err := parseLevel(cfg.Level)
+	if err != nil {
+		return fmt.Errorf("log.level: %w", err)
+	}
+	componentLevels := make(map[string]zerolog.Level, len(cfg.Components))
+	for name, value := range cfg.Components {
+		if componentLevels[name], err = parseLevel(value); err != nil {
+			return fmt.Errorf("log.components.%s: %w", name, err)
+		}
+	}
+
+	var dst io.Writer = os.Stderr
+	var closer io.Closer
+	if cfg.File != "" {
+		file, err := openRotating(cfg.File, cfg.MaxSize, cfg.MaxAge, cfg.MaxBackups)
+		if err != nil {
+			return err
+		}
+		dst, closer = file, file
+	}
+
+	var w io.Writer
+	switch cfg.Format {
+	case "", FormatConsole:
+		console := zerolog.ConsoleWriter{Out: dst}
+		if cfg.File != "" {
+			console.NoColor = true
+			console.TimeFormat = time.RFC3339
+		}
+		w = console
+	case FormatJSON:
+		w = dst
+	default:
+		if closer != nil {
+			closer.Close()
+		}
+		return fmt.Errorf("log.format must be %s or %s, got %q", FormatConsole, FormatJSON, cfg.Format)
+	}
+	if cfg.Redact {
+		w = &redactor{next: w}
+	}
+	out.swap(w, closer)
+
+	// Events are filtered per logger, the global level only has to let the
+	// most verbose one through
+	lowest := level
+	for _, l := range componentLevels {
+		lowest = min(lowest, l)
+	}
+	zerolog.SetGlobalLevel(lowest)
+	baseLevel.Store(int32(level))
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !configured {
+		log.Logger = zerolog.New(out).With().Timestamp().Logger().Hook(levelFilter{})
+		configured = true
+	}
+	levels = componentLevels
+	for _, l := range components {
+		l.configure()
+	}
+	for _, name := range sortedNames(levels) {
+		if _, ok := components[name]; !ok {
+			log.Warn().Str("component", name).Strs("known", sortedNames(components)).Msg("Unknown log component")
+		}
+	}
+	return nil
+}
+
+// Close closes the log file, logs go to stderr afterwards
+func Close() {
+	out.swap(zerolog.ConsoleWriter{Out: os.Stderr}, nil)
+}
+
+func parseLevel(value string) (zerolog.Level, error) {
+	if value == "" {
+		return zerolog.InfoLevel, nil
+	}
+	return zerolog.ParseLevel(value)
+}
+
+func sortedNames[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// output is the writer all loggers share, so a reload can switch the
+// destination without racing loggers still holding the previous one
+type output struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.w == nil {
+		return os.Stderr.Write(p)
+	}
+	return o.w.Write(p)
+}
+
+func (o *output) swap(w io.Writer, closer io.Closer) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closer != nil {
+		o.closer.Close()
+	}
+	o.w, o.closer = w, closer
+}
@@ -0,0 +1,36 @@
+package logging
+
+import (
+	"io"
+	"regexp"
+)
+
+// redacted replaces secrets in log events
+const redacted = "[REDACTED]"
+
+var (
+	// Fields named like a credential: "token":"...", "nodeKey":"..."
+	secretField = regexp.MustCompile(`(?i)("[a-z_-]*(?:token|password|passwd|secret|private_?key|node_?key|api_?key|authorization|psk)":\s*")(?:[^"\\]|\\.)*"`)
+	// Credentials inside messages and errors: ?token=..., password: ...
+	secretAssignment = regexp.MustCompile(`(?i)((?:token|password|passwd|secret|key)\s*[=:]\s*)[^\s"\\&,;]+`)
+	bearer           = regexp.MustCompile(`(?i)(bearer\s+)[a-z0-9._~+/=-]+`)
+	// User IDs keep their first group so log lines can still be told apart
+	uuid = regexp.MustCompile(`\b([0-9a-fA-F]{8})-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`)
+)
+
+// redactor removes tokens, passwords and UUIDs from the JSON events zerolog
+// writes, before they are formatted for the console
+type redactor struct {
+	next io.Writer
+}
+
+func (r *redactor) Write(p []byte) (int, error) {
+	event := secretField.ReplaceAll(p, []byte(`${1}`+redacted+`"`))
+	event = secretAssignment.ReplaceAll(event, []byte(`${1}`+redacted))
+	event = bearer.ReplaceAll(event, []byte(`${1}`+redacted))
+	event = uuid.ReplaceAll(event, []byte(`${1}-`+redacted))
+	if _, err := r.next.Write(event); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,147 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat names rotated files, agent.log becomes
+// agent-2006-01-02T15-04-05.000.log; it sorts by time
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// rotateRetryDelay spaces out rotations after one failed, so a full disk or
+// a missing directory does not cost every log line a rename and an error
+const rotateRetryDelay = time.Minute
+
+// rotatingFile is a log file that is rotated once it exceeds maxSize bytes
+// or has been written for maxAge, keeping maxBackups rotated files. Zero
+// disables the limit.
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	file    *os.File
+	size    int64
+	opened  time.Time
+	retryAt time.Time // no rotation before, set when one failed
+}
+
+func openRotating(path string, maxSizeMB int, maxAge time.Duration, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{
+		path:       path,
+		maxSize:    int64(maxSizeMB) << 20,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("create log directory: %w", err)
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("stat log file: %w", err)
+	}
+	// The age of a file appended to is counted from when the agent opened it
+	r.file, r.size, r.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+	due := r.maxSize > 0 && r.size+int64(len(p)) > r.maxSize ||
+		r.maxAge > 0 && time.Since(r.opened) >= r.maxAge
+	if r.size > 0 && due && !time.Now().Before(r.retryAt) {
+		if err := r.rotate(); err != nil {
+			// Keep logging to the current file rather than losing events
+			fmt.Fprintf(os.Stderr, "log rotation failed, retrying in %s: %v\n", rotateRetryDelay, err)
+			r.retryAt = time.Now().Add(rotateRetryDelay)
+		} else {
+			r.retryAt = time.Time{}
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current file with a timestamp and starts a new one;
+// r.mu must be held. When the new file cannot be opened the current handle,
+// now the renamed file, is kept and a write after rotateRetryDelay tries again.
+func (r *rotatingFile) rotate() error {
+	ext := filepath.Ext(r.path)
+	backup := strings.TrimSuffix(r.path, ext) + "-" + time.Now().Format(backupTimeFormat) + ext
+	// A previous attempt may have renamed the file already
+	if err := os.Rename(r.path, backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	current := r.file
+	if err := r.open(); err != nil {
+		return err
+	}
+	current.Close()
+	return r.prune()
+}
+
+// prune removes the oldest rotated files beyond maxBackups
+func (r *rotatingFile) prune() error {
+	if r.maxBackups <= 0 {
+		return nil
+	}
+	ext := filepath.Ext(r.path)
+	prefix := strings.TrimSuffix(filepath.Base(r.path), ext) + "-"
+	entries, err := os.ReadDir(filepath.Dir(r.path))
+	if err != nil {
+		return err
+	}
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		stamp, ok := strings.CutPrefix(name, prefix)
+		if !ok || !strings.HasSuffix(stamp, ext) {
+			continue
+		}
+		if _, err := time.Parse(backupTimeFormat, strings.TrimSuffix(stamp, ext)); err == nil {
+			backups = append(backups, name)
+		}
+	}
+	sort.Strings(backups)
+	for len(backups) > r.maxBackups {
+		os.Remove(filepath.Join(filepath.Dir(r.path), backups[0]))
+		backups = backups[1:]
+	}
+	return nil
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
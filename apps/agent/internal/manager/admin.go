@@ -0,0 +1,195 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/synexim/panel-agent/internal/xray"
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// Periodic tasks whose last result is kept for the admin API
+const (
+	TaskConfigSync    = "configSync"
+	TaskUserSync      = "userSync"
+	TaskTrafficReport = "trafficReport"
+	TaskStatusReport  = "statusReport"
+	TaskAliveReport   = "aliveReport"
+)
+
+// scheduledTasks run periodically on the scheduler
+var scheduledTasks = []string{TaskConfigSync, TaskUserSync, TaskTrafficReport, TaskStatusReport, TaskAliveReport}
+
+// recordTask stores the outcome of a task run
+func (m *Manager) recordTask(name string, started time.Time, err error) {
+	m.counters.observeTask(name, started, err)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := types.TaskResult{
+		LastRun:    started.Unix(),
+		DurationMs: time.Since(started).Milliseconds(),
+		OK:         err == nil,
+	}
+	if err != nil {
+		result.Error = err.Error()
+		result.Failures = m.tasks[name].Failures + 1
+	}
+	m.tasks[name] = result
+
+	if result.Failures == 1 {
+		m.recordEvent("task", "%s failed: %v", name, err)
+	}
+}
+
+// State returns a snapshot of the agent: cores, active versions, user counts
+// and the last result of every task and apply
+func (m *Manager) State(ctx context.Context) *types.AgentState {
+	state := &types.AgentState{
+		StartedAt: m.startedAt.Unix(),
+		CoreMode:  m.cfg.Core.Type,
+		Cores:     m.coreStatuses(),
+		Users:     m.UserCounts(ctx),
+	}
+	for i, c := range m.cores {
+		state.Cores[i].Version = c.process.GetVersion()
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	state.Active = m.active
+	if m.appliedConfig != nil {
+		state.ExcludedInbounds = m.appliedConfig.ExcludedInbounds
+	}
+	state.Tasks = make(map[string]types.TaskResult, len(m.tasks))
+	for name, result := range m.tasks {
+		state.Tasks[name] = result
+	}
+	for _, name := range scheduledTasks {
+		result := state.Tasks[name]
+		result.IntervalMs = m.scheduler.Interval(name).Milliseconds()
+		if next := m.scheduler.Next(name); !next.IsZero() {
+			result.NextRun = next.Unix()
+		}
+		state.Tasks[name] = result
+	}
+	state.LastApply = make(map[string]types.ApplyResult, len(m.lastApply))
+	for kind, result := range m.lastApply {
+		state.LastApply[kind] = result
+	}
+	return state
+}
+
+// NodeConfig returns the config last synced from Panel
+func (m *Manager) NodeConfig() *types.NodeConfig {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.nodeConfig
+}
+
+// UserCounts counts the synced users, those online and the users per inbound
+func (m *Manager) UserCounts(ctx context.Context) types.UserCounts {
+	m.mu.RLock()
+	users := m.users
+	templateTags := xray.TemplateInboundTags(m.appliedConfig)
+	m.mu.RUnlock()
+
+	counts := types.UserCounts{
+		Total:     len(users),
+		Online:    m.onlineUserCount(ctx),
+		ByInbound: make(map[string]int),
+	}
+	for _, user := range xray.ExpandUserInboundTags(users, templateTags) {
+		for _, tag := range user.InboundTags {
+			counts.ByInbound[tag]++
+		}
+	}
+	return counts
+}
+
+// LiveUsers returns the users that are online or have traffic since the agent
+// started, or every synced user when all is set
+func (m *Manager) LiveUsers(ctx context.Context, all bool) []types.LiveUser {
+	m.mu.RLock()
+	emails := m.userEmails
+	m.mu.RUnlock()
+
+	m.counters.mu.Lock()
+	traffic := make(map[string]types.TrafficReport, len(m.counters.userTraffic))
+	for email, t := range m.counters.userTraffic {
+		traffic[email] = *t
+	}
+	m.counters.mu.Unlock()
+
+	users := make([]types.LiveUser, 0)
+	for _, email := range emails {
+		user := types.LiveUser{
+			Email:    email,
+			Upload:   traffic[email].Upload,
+			Download: traffic[email].Download,
+		}
+		for _, core := range m.cores {
+			if count, err := core.api.GetUserOnlineCount(ctx, email); err == nil {
+				user.Sessions += count
+			}
+		}
+		if all || user.Sessions > 0 || user.Upload > 0 || user.Download > 0 {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
+// SyncConfig fetches the config from Panel now and applies it if it changed.
+// Like RestartCore it runs on the agent's context rather than a request's:
+// a core restarted on a request context is killed once the response is sent.
+func (m *Manager) SyncConfig() error {
+	return m.runConfigSync(m.ctx)
+}
+
+// SyncUsers fetches the users from Panel now and applies the changes
+func (m *Manager) SyncUsers() error {
+	return m.runUserSync(m.ctx)
+}
+
+// FlushTraffic reports the traffic collected so far, returning the number of users reported
+func (m *Manager) FlushTraffic(ctx context.Context) (int, error) {
+	started := time.Now()
+	count, err := m.reportTraffic(ctx)
+	m.recordTask(TaskTrafficReport, started, err)
+	return count, err
+}
+
+// KickUser drops a user's connections on every core with a Handler API
+func (m *Manager) KickUser(ctx context.Context, email string) error {
+	if email == "" {
+		return fmt.Errorf("email is required")
+	}
+	log.Info().Str("email", email).Msg("Kicking user")
+	m.recordEvent("kick", "%s kicked on request", email)
+	return m.kickUser(ctx, email)
+}
+
+// RestartCore restarts a core by type (xray or singbox), or every core when
+// coreType is empty, flushing traffic first
+func (m *Manager) RestartCore(coreType string) error {
+	ctx := m.ctx
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+
+	var cores []*coreRuntime
+	for _, c := range m.cores {
+		if coreType == "" || c.coreType.String() == coreType {
+			cores = append(cores, c)
+		}
+	}
+	if len(cores) == 0 {
+		return fmt.Errorf("no %s core configured", coreType)
+	}
+
+	log.Info().Str("core", coreType).Msg("Restarting core on request")
+	m.flushTrafficBeforeRestart(ctx)
+	return m.restartCores(ctx, cores...)
+}
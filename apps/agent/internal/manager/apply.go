@@ -0,0 +1,119 @@
+package manager
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"time"
+
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// Kinds of synced state an apply result acknowledges
+const (
+	applyKindConfig = "config"
+	applyKindUsers  = "users"
+)
+
+// applyStatus maps the outcome of restarting cores to an apply status
+func applyStatus(cores []*coreRuntime, err error) string {
+	if err == nil {
+		return types.ApplyStatusApplied
+	}
+	for _, c := range cores {
+		c.statusMu.Lock()
+		rolledBack := c.status.RolledBack
+		c.statusMu.Unlock()
+		if rolledBack {
+			return types.ApplyStatusRolledBack
+		}
+	}
+	return types.ApplyStatusFailed
+}
+
+// acknowledgeApply records the active versions after a successful apply and
+// reports the outcome of the sync to Panel
+func (m *Manager) acknowledgeApply(ctx context.Context, kind, status string, started time.Time, errs ...error) {
+	hash := m.renderedConfigHash()
+
+	m.mu.Lock()
+	var version, etag string
+	if kind == applyKindConfig && m.nodeConfig != nil {
+		version, etag = m.nodeConfig.Version, m.nodeConfig.ETag
+	} else {
+		version, etag = m.usersVersion, m.usersETag
+	}
+	if status == types.ApplyStatusApplied || status == types.ApplyStatusHotApplied {
+		if kind == applyKindConfig {
+			m.active.ConfigVersion, m.active.ConfigETag = version, etag
+		} else {
+			m.active.UsersVersion, m.active.UsersETag = version, etag
+		}
+		m.active.ConfigHash = hash
+	}
+	m.mu.Unlock()
+
+	result := &types.ApplyResult{
+		Kind:       kind,
+		Version:    version,
+		ETag:       etag,
+		ConfigHash: hash,
+		Status:     status,
+		DurationMs: time.Since(started).Milliseconds(),
+		AppliedAt:  time.Now().Unix(),
+	}
+	for _, err := range errs {
+		if err != nil {
+			result.Errors = append(result.Errors, err.Error())
+		}
+	}
+
+	m.reportApplyResult(ctx, result)
+}
+
+// reportApplyResult logs an apply result and sends it to Panel
+func (m *Manager) reportApplyResult(ctx context.Context, result *types.ApplyResult) {
+	m.mu.Lock()
+	m.lastApply[result.Kind] = *result
+	m.mu.Unlock()
+	m.counters.inc(&m.counters.applies, result.Kind, result.Status)
+	if result.Reason != "" {
+		m.recordEvent("apply", "%s %s %s: %s", result.Kind, result.Version, result.Status, result.Reason)
+	} else {
+		m.recordEvent("apply", "%s %s %s", result.Kind, result.Version, result.Status)
+	}
+
+	log.Info().
+		Str("kind", result.Kind).
+		Str("version", result.Version).
+		Str("status", result.Status).
+		Int64("durationMs", result.DurationMs).
+		Strs("errors", result.Errors).
+		Str("reason", result.Reason).
+		Msg("Apply result")
+	if err := m.client.ReportApplyResult(ctx, result); err != nil {
+		log.Warn().Err(err).Msg("Failed to report apply result")
+	}
+}
+
+// activeVersions returns the config and user versions currently running
+func (m *Manager) activeVersions() *types.ActiveVersions {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	active := m.active
+	return &active
+}
+
+// renderedConfigHash hashes the config files of all cores, in core order
+func (m *Manager) renderedConfigHash() string {
+	h := sha256.New()
+	for _, c := range m.cores {
+		data, err := os.ReadFile(c.configPath)
+		if err != nil {
+			return ""
+		}
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
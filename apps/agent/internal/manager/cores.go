@@ -0,0 +1,407 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/synexim/panel-agent/internal/config"
+	"github.com/synexim/panel-agent/internal/singbox"
+	"github.com/synexim/panel-agent/internal/xray"
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// SingboxProcess groups the sing-box process and its v2ray_api client (Wire provider type)
+type SingboxProcess struct {
+	Process *xray.ProcessManager
+	GRPC    *xray.GRPCClient
+}
+
+// coreRuntime is one supervised proxy core and the inbound protocols it serves
+type coreRuntime struct {
+	coreType     types.CoreType
+	process      xray.CoreProcess
+	api          xray.CoreAPI
+	handover     *xray.Handover // blue/green restarts, nil when disabled
+	hotUsers     bool           // Handler API available for users and rate limits
+	serves       func(protocol string) bool
+	generate     func(nodeConfig *types.NodeConfig, users []types.UserConfig) (interface{}, error)
+	render       func(nodeConfig *types.NodeConfig, users []types.UserConfig) error
+	capabilities func() *types.CoreCapabilities
+
+	configPath string
+	lastGood   []byte // last rendered config that passed readiness
+
+	statusMu sync.Mutex
+	status   types.CoreStatus
+}
+
+// buildCores builds the cores for the configured mode: xray, singbox or dual
+func buildCores(params ManagerParams) []*coreRuntime {
+	xrayCore := &coreRuntime{
+		coreType: types.CoreTypeXray,
+		process:  params.Process,
+		api:      params.API,
+		hotUsers: true,
+		serves: func(protocol string) bool {
+			return !singbox.IsSingboxProtocol(protocol)
+		},
+		generate: func(nodeConfig *types.NodeConfig, users []types.UserConfig) (interface{}, error) {
+			return params.Generator.Generate(nodeConfig, users)
+		},
+		render: func(nodeConfig *types.NodeConfig, users []types.UserConfig) error {
+			cfg, err := params.Generator.Generate(nodeConfig, users)
+			if err != nil {
+				return err
+			}
+			return params.Generator.WriteConfig(cfg)
+		},
+		configPath: params.Cfg.Xray.ConfigPath,
+		capabilities: func() *types.CoreCapabilities {
+			if params.Cfg.Xray.Embedded {
+				return xray.DetectEmbeddedCapabilities()
+			}
+			return xray.DetectCapabilities(params.Cfg.Xray.BinaryPath)
+		},
+	}
+
+	if params.Cfg.Xray.Handover && !params.Cfg.Xray.Embedded {
+		process, isProcess := params.Process.(*xray.ProcessManager)
+		api, isGRPC := params.API.(*xray.GRPCClient)
+		if isProcess && isGRPC {
+			xrayCore.handover = xray.NewHandover(process, api, params.Cfg.Xray.StandbyAPIAddress, params.Cfg.Xray.HandoverGrace)
+		}
+	}
+
+	singboxCore := &coreRuntime{
+		coreType: types.CoreTypeSingbox,
+		process:  params.SingboxProcess.Process,
+		api:      params.SingboxProcess.GRPC,
+		serves:   singbox.IsSingboxProtocol,
+		generate: func(nodeConfig *types.NodeConfig, users []types.UserConfig) (interface{}, error) {
+			return params.Singbox.Generate(nodeConfig, users)
+		},
+		render: func(nodeConfig *types.NodeConfig, users []types.UserConfig) error {
+			cfg, err := params.Singbox.Generate(nodeConfig, users)
+			if err != nil {
+				return err
+			}
+			return params.Singbox.WriteConfig(cfg)
+		},
+		capabilities: func() *types.CoreCapabilities {
+			return singbox.DetectCapabilities(params.Cfg.Singbox.BinaryPath)
+		},
+		configPath: params.Cfg.Singbox.ConfigPath,
+	}
+
+	if strings.EqualFold(params.Cfg.Core.Type, config.CoreModeDual) {
+		return []*coreRuntime{xrayCore, singboxCore}
+	}
+	if types.ParseCoreType(params.Cfg.Core.Type) == types.CoreTypeSingbox {
+		return []*coreRuntime{singboxCore}
+	}
+	return []*coreRuntime{xrayCore}
+}
+
+// primaryCore returns the first configured core, used for version reporting
+func (m *Manager) primaryCore() *coreRuntime {
+	return m.cores[0]
+}
+
+// coreFor returns the core serving an inbound protocol, or nil if no configured core does
+func (m *Manager) coreFor(protocol string) *coreRuntime {
+	for _, c := range m.cores {
+		if c.serves(protocol) {
+			return c
+		}
+	}
+	return nil
+}
+
+// splitNodeConfig returns the part of a node config served by one core
+func splitNodeConfig(nodeConfig *types.NodeConfig, core *coreRuntime) *types.NodeConfig {
+	part := *nodeConfig
+	part.Inbounds = nil
+	part.InboundTemplates = nil
+	for _, inbound := range nodeConfig.Inbounds {
+		if core.serves(inbound.Protocol) {
+			part.Inbounds = append(part.Inbounds, inbound)
+		}
+	}
+	for _, t := range nodeConfig.InboundTemplates {
+		if core.serves(t.Protocol) {
+			part.InboundTemplates = append(part.InboundTemplates, t)
+		}
+	}
+	return &part
+}
+
+// renderCores renders and writes the config of every core
+func (m *Manager) renderCores(nodeConfig *types.NodeConfig, users []types.UserConfig) error {
+	excluded := make(map[string]bool, len(nodeConfig.ExcludedInbounds))
+	for _, tag := range nodeConfig.ExcludedInbounds {
+		excluded[tag] = true
+	}
+	for _, inbound := range nodeConfig.Inbounds {
+		if !excluded[inbound.Tag] && m.coreFor(inbound.Protocol) == nil {
+			log.Warn().Str("tag", inbound.Tag).Str("protocol", inbound.Protocol).Msg("No configured core serves inbound protocol, skipping")
+		}
+	}
+	for _, c := range m.cores {
+		if err := c.render(splitNodeConfig(nodeConfig, c), users); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startCores starts every core and waits until it is ready
+func (m *Manager) startCores(ctx context.Context) error {
+	for _, c := range m.cores {
+		if err := c.process.Start(ctx); err != nil {
+			c.setStatus(err, false)
+			return err
+		}
+		err := m.readyFunc(c)(ctx, c.api, c.process.IsRunning)
+		c.setStatus(err, false)
+		if err != nil {
+			return fmt.Errorf("%s not ready: %w", c.coreType, err)
+		}
+		c.snapshotConfig()
+	}
+	return nil
+}
+
+// stopCores stops every core
+func (m *Manager) stopCores() {
+	for _, c := range m.cores {
+		if c.handover != nil {
+			c.handover.StopDraining()
+		}
+		if err := c.process.Stop(); err != nil {
+			log.Warn().Err(err).Str("core", c.coreType.String()).Msg("Failed to stop core")
+		}
+	}
+}
+
+// restartCores restarts the given cores, or all cores when none are given
+func (m *Manager) restartCores(ctx context.Context, cores ...*coreRuntime) error {
+	if len(cores) == 0 {
+		cores = m.cores
+	}
+	var firstErr error
+	for _, c := range cores {
+		if err := m.restartCore(ctx, c); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// restartCore restarts a core and waits until it is ready. A core that does
+// not become ready with the new config is rolled back to the last good one.
+func (m *Manager) restartCore(ctx context.Context, c *coreRuntime) error {
+	ready := m.readyFunc(c)
+
+	var err error
+	if c.handover != nil {
+		err = c.handover.Restart(ctx, ready)
+	} else if err = c.process.Restart(ctx); err == nil {
+		err = ready(ctx, c.api, c.process.IsRunning)
+	}
+	if err == nil {
+		c.setStatus(nil, false)
+		c.snapshotConfig()
+		m.counters.inc(&m.counters.restarts, c.coreType.String(), "ok")
+		m.recordEvent("core", "%s restarted", c.coreType)
+		return nil
+	}
+
+	log.Error().Err(err).Str("core", c.coreType.String()).Msg("Core not ready after restart, rolling back config")
+	if rbErr := m.rollbackCore(ctx, c); rbErr != nil {
+		log.Error().Err(rbErr).Str("core", c.coreType.String()).Msg("Failed to roll back core config")
+		c.setStatus(err, false)
+		m.counters.inc(&m.counters.restarts, c.coreType.String(), "failed")
+		m.recordEvent("core", "%s restart failed: %v", c.coreType, err)
+		return err
+	}
+	c.setStatus(err, true)
+	m.counters.inc(&m.counters.restarts, c.coreType.String(), "rolled_back")
+	m.recordEvent("core", "%s rolled back to the previous config: %v", c.coreType, err)
+	log.Warn().Str("core", c.coreType.String()).Msg("Core rolled back to previous config")
+	return err
+}
+
+// rollbackCore restores the last good config and restarts the core with it
+func (m *Manager) rollbackCore(ctx context.Context, c *coreRuntime) error {
+	if c.lastGood == nil {
+		return fmt.Errorf("no previous config")
+	}
+	if err := os.WriteFile(c.configPath, c.lastGood, 0644); err != nil {
+		return fmt.Errorf("restore config: %w", err)
+	}
+	restart := c.process.Restart
+	if c.handover != nil {
+		// A failed handover leaves the previous process serving
+		if c.process.IsRunning() {
+			return nil
+		}
+		restart = c.handover.ColdRestart
+	}
+	if err := restart(ctx); err != nil {
+		return err
+	}
+	return xray.WaitReady(ctx, c.api, nil, m.cfg.Core.ReadyTimeout, c.process.IsRunning)
+}
+
+// readyFunc returns the readiness check of a core for its expected inbounds
+func (m *Manager) readyFunc(c *coreRuntime) xray.ReadyFunc {
+	inbounds := m.expectedInbounds(c)
+	return func(ctx context.Context, api xray.CoreAPI, alive func() bool) error {
+		return xray.WaitReady(ctx, api, inbounds, m.cfg.Core.ReadyTimeout, alive)
+	}
+}
+
+// expectedInbounds returns the inbounds a core must be listening on
+func (m *Manager) expectedInbounds(c *coreRuntime) []types.InboundConfig {
+	m.mu.RLock()
+	nodeConfig := m.appliedConfig
+	m.mu.RUnlock()
+	if nodeConfig == nil {
+		return nil
+	}
+
+	var inbounds []types.InboundConfig
+	for _, inbound := range xray.InboundsByTag(nodeConfig) {
+		if c.serves(inbound.Protocol) {
+			inbounds = append(inbounds, inbound)
+		}
+	}
+	return inbounds
+}
+
+// snapshotConfig remembers the running config as the rollback target
+func (c *coreRuntime) snapshotConfig() {
+	data, err := os.ReadFile(c.configPath)
+	if err != nil {
+		log.Debug().Err(err).Str("core", c.coreType.String()).Msg("Failed to snapshot config")
+		return
+	}
+	c.lastGood = data
+}
+
+// setStatus records the readiness outcome of the last (re)start
+func (c *coreRuntime) setStatus(err error, rolledBack bool) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	c.status = types.CoreStatus{
+		CoreType:   c.coreType,
+		Ready:      err == nil || rolledBack,
+		RolledBack: rolledBack,
+	}
+	if err != nil {
+		c.status.Error = err.Error()
+		var notReady *xray.NotReadyError
+		if errors.As(err, &notReady) {
+			c.status.MissingInbounds = notReady.MissingInbounds
+		}
+	}
+}
+
+// coreStatuses returns the readiness of every core for the status report
+func (m *Manager) coreStatuses() []types.CoreStatus {
+	statuses := make([]types.CoreStatus, 0, len(m.cores))
+	for _, c := range m.cores {
+		c.statusMu.Lock()
+		status := c.status
+		c.statusMu.Unlock()
+
+		status.CoreType = c.coreType
+		status.Running = c.process.IsRunning()
+		if !status.Running {
+			status.Ready = false
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// queryTraffic collects user traffic from every core and merges it by email
+func (m *Manager) queryTraffic(ctx context.Context) ([]types.TrafficReport, error) {
+	merged := make(map[string]*types.TrafficReport)
+	var order []string
+	var lastErr error
+	ok := false
+
+	for _, c := range m.cores {
+		traffics, err := c.api.QueryTrafficStats(ctx, true)
+		if err != nil {
+			log.Debug().Err(err).Str("core", c.coreType.String()).Msg("Failed to collect traffic")
+			lastErr = err
+		} else {
+			ok = true
+		}
+		// Processes draining after a handover still carry traffic
+		if c.handover != nil {
+			if drained := c.handover.DrainedTraffic(ctx); len(drained) > 0 {
+				traffics = append(traffics, drained...)
+				ok = true
+			}
+		}
+		for _, t := range traffics {
+			if existing, found := merged[t.Email]; found {
+				existing.Upload += t.Upload
+				existing.Download += t.Download
+				continue
+			}
+			report := t
+			merged[t.Email] = &report
+			order = append(order, t.Email)
+		}
+	}
+	if !ok {
+		return nil, lastErr
+	}
+
+	reports := make([]types.TrafficReport, 0, len(order))
+	var total int64
+	for _, email := range order {
+		reports = append(reports, *merged[email])
+		total += merged[email].Upload + merged[email].Download
+	}
+	m.throughput.add(total)
+	m.counters.addUserTraffic(reports)
+	return reports, nil
+}
+
+// mergedCapabilities combines the capabilities of every core
+func (m *Manager) mergedCapabilities() *types.CoreCapabilities {
+	result := m.primaryCore().capabilities()
+	for _, c := range m.cores[1:] {
+		caps := c.capabilities()
+		result.Protocols.Inbound = appendUnique(result.Protocols.Inbound, caps.Protocols.Inbound...)
+		result.Protocols.Outbound = appendUnique(result.Protocols.Outbound, caps.Protocols.Outbound...)
+		result.Transports = appendUnique(result.Transports, caps.Transports...)
+		result.Features = appendUnique(result.Features, caps.Features...)
+	}
+	return result
+}
+
+// appendUnique appends values not already present
+func appendUnique(list []string, values ...string) []string {
+	seen := make(map[string]bool, len(list))
+	for _, v := range list {
+		seen[v] = true
+	}
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			list = append(list, v)
+		}
+	}
+	return list
+}
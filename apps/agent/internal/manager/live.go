@@ -0,0 +1,253 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/synexim/panel-agent/internal/xray"
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+const (
+	// liveMinWindow is the shortest window rates are computed over, so several
+	// viewers polling at once do not shrink it for each other
+	liveMinWindow = time.Second
+	// maxEvents bounds the recent events kept for `agent top`
+	maxEvents = 100
+)
+
+// liveTraffic holds traffic collected from the cores but not yet reported,
+// and the rates of the last sample. Every read of the reset-on-read user
+// counters goes through it, so reporting and live views share the deltas.
+type liveTraffic struct {
+	mu         sync.Mutex
+	pending    map[string]*types.TrafficReport
+	order      []string
+	lastSample time.Time
+	window     time.Duration
+	rates      map[string]types.TrafficReport // bytes/s over window
+
+	tagLast    map[tagKey]xray.InboundStats // cumulative bytes per core tag
+	tagRates   []types.TagRate
+	tagOut     []types.TagRate
+	tagSampled time.Time
+
+	alive   []types.AliveUser // from the last alive poll
+	aliveAt time.Time
+
+	events []types.AgentEvent
+}
+
+type tagKey struct {
+	core     string
+	outbound bool
+	tag      string
+}
+
+// sampleTraffic collects user traffic into the pending buffer and updates the
+// live rates
+func (m *Manager) sampleTraffic(ctx context.Context) error {
+	reports, err := m.queryTraffic(ctx)
+	if err != nil {
+		return err
+	}
+
+	l := &m.live
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.pending == nil {
+		l.pending = make(map[string]*types.TrafficReport)
+	}
+	l.rates = make(map[string]types.TrafficReport, len(reports))
+	l.window = now.Sub(l.lastSample)
+	for _, r := range reports {
+		if !l.lastSample.IsZero() {
+			seconds := l.window.Seconds()
+			l.rates[r.Email] = types.TrafficReport{
+				Email:    r.Email,
+				Upload:   int64(float64(r.Upload) / seconds),
+				Download: int64(float64(r.Download) / seconds),
+			}
+		}
+		if p, ok := l.pending[r.Email]; ok {
+			p.Upload += r.Upload
+			p.Download += r.Download
+			continue
+		}
+		report := r
+		l.pending[r.Email] = &report
+		l.order = append(l.order, r.Email)
+	}
+	l.lastSample = now
+	return nil
+}
+
+// takePending returns the traffic collected since the last report and clears it
+func (m *Manager) takePending() []types.TrafficReport {
+	l := &m.live
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	reports := make([]types.TrafficReport, 0, len(l.order))
+	for _, email := range l.order {
+		reports = append(reports, *l.pending[email])
+	}
+	l.pending = nil
+	l.order = nil
+	return reports
+}
+
+// sampleTagRates computes inbound and outbound throughput from the cumulative
+// tag counters of the cores
+func (m *Manager) sampleTagRates(ctx context.Context) {
+	current := make(map[tagKey]xray.InboundStats)
+	for _, c := range m.cores {
+		querier, ok := c.api.(xray.TagTrafficQuerier)
+		if !ok || !c.process.IsRunning() {
+			continue
+		}
+		result, err := querier.QueryTagTraffic(ctx)
+		if err != nil {
+			continue
+		}
+		for _, s := range result.Inbounds {
+			current[tagKey{core: c.coreType.String(), tag: s.Tag}] = s
+		}
+		for _, s := range result.Outbounds {
+			current[tagKey{core: c.coreType.String(), outbound: true, tag: s.Tag}] = xray.InboundStats(s)
+		}
+	}
+
+	l := &m.live
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	seconds := now.Sub(l.tagSampled).Seconds()
+	l.tagRates, l.tagOut = nil, nil
+	for key, s := range current {
+		prev, ok := l.tagLast[key]
+		// Counters restart with the core
+		if !ok || s.Upload < prev.Upload || s.Download < prev.Download {
+			continue
+		}
+		rate := types.TagRate{
+			Core:         key.core,
+			Tag:          key.tag,
+			UploadRate:   int64(float64(s.Upload-prev.Upload) / seconds),
+			DownloadRate: int64(float64(s.Download-prev.Download) / seconds),
+		}
+		if key.outbound {
+			l.tagOut = append(l.tagOut, rate)
+		} else {
+			l.tagRates = append(l.tagRates, rate)
+		}
+	}
+	sortTagRates(l.tagRates)
+	sortTagRates(l.tagOut)
+	l.tagLast = current
+	l.tagSampled = now
+}
+
+func sortTagRates(rates []types.TagRate) {
+	sort.Slice(rates, func(i, j int) bool {
+		ri, rj := rates[i].UploadRate+rates[i].DownloadRate, rates[j].UploadRate+rates[j].DownloadRate
+		if ri != rj {
+			return ri > rj
+		}
+		return rates[i].Tag < rates[j].Tag
+	})
+}
+
+// setAlive keeps the online users of the last alive poll
+func (m *Manager) setAlive(users []types.AliveUser) {
+	m.live.mu.Lock()
+	m.live.alive = users
+	m.live.aliveAt = time.Now()
+	m.live.mu.Unlock()
+}
+
+// recordEvent appends to the recent events shown by `agent top`
+func (m *Manager) recordEvent(kind, format string, args ...interface{}) {
+	m.live.mu.Lock()
+	defer m.live.mu.Unlock()
+
+	m.live.events = append(m.live.events, types.AgentEvent{
+		At:      time.Now().Unix(),
+		Kind:    kind,
+		Message: fmt.Sprintf(format, args...),
+	})
+	if len(m.live.events) > maxEvents {
+		m.live.events = m.live.events[len(m.live.events)-maxEvents:]
+	}
+}
+
+// Top samples the cores and returns per-user and per-tag rates, core state and
+// recent events. Online IPs come from the last alive poll.
+func (m *Manager) Top(ctx context.Context) (*types.TopSnapshot, error) {
+	m.live.mu.Lock()
+	due := time.Since(m.live.lastSample) >= liveMinWindow
+	m.live.mu.Unlock()
+	if due {
+		if err := m.sampleTraffic(ctx); err != nil {
+			return nil, fmt.Errorf("collect traffic: %w", err)
+		}
+		m.sampleTagRates(ctx)
+	}
+
+	m.mu.RLock()
+	users := xray.ExpandUserInboundTags(m.users, xray.TemplateInboundTags(m.appliedConfig))
+	m.mu.RUnlock()
+	m.counters.mu.Lock()
+	totals := make(map[string]types.TrafficReport, len(m.counters.userTraffic))
+	for email, t := range m.counters.userTraffic {
+		totals[email] = *t
+	}
+	m.counters.mu.Unlock()
+
+	snapshot := &types.TopSnapshot{
+		At:    time.Now().UnixMilli(),
+		Cores: m.coreStatuses(),
+		Users: make([]types.TopUser, 0),
+	}
+
+	l := &m.live
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	snapshot.Window = l.window.Seconds()
+	if !l.aliveAt.IsZero() {
+		snapshot.AliveAt = l.aliveAt.Unix()
+	}
+	ips := make(map[string]map[string]bool)
+	for _, a := range l.alive {
+		if ips[a.Email] == nil {
+			ips[a.Email] = make(map[string]bool)
+		}
+		ips[a.Email][a.IP] = true
+	}
+	for _, u := range users {
+		rate := l.rates[u.Email]
+		if rate.Upload == 0 && rate.Download == 0 && len(ips[u.Email]) == 0 {
+			continue
+		}
+		snapshot.Users = append(snapshot.Users, types.TopUser{
+			Email:        u.Email,
+			Inbounds:     u.InboundTags,
+			UploadRate:   rate.Upload,
+			DownloadRate: rate.Download,
+			IPs:          len(ips[u.Email]),
+			Upload:       totals[u.Email].Upload,
+			Download:     totals[u.Email].Download,
+		})
+	}
+	snapshot.Inbounds = append([]types.TagRate{}, l.tagRates...)
+	snapshot.Outbounds = append([]types.TagRate{}, l.tagOut...)
+	snapshot.Events = append([]types.AgentEvent{}, l.events...)
+	return snapshot, nil
+}
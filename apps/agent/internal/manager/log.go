@@ -0,0 +1,6 @@
+package manager
+
+import "github.com/synexim/panel-agent/internal/logging"
+
+// log is the manager's logger, its level is log.components.manager
+var log = logging.Component("manager")
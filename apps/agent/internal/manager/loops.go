@@ -2,64 +2,131 @@ package manager
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
-	"github.com/rs/zerolog/log"
+	"github.com/synexim/panel-agent/internal/client"
+	"github.com/synexim/panel-agent/internal/xray"
 	"github.com/synexim/panel-agent/pkg/types"
 )
 
-// configSyncLoop periodically syncs configuration
-func (m *Manager) configSyncLoop(ctx context.Context) {
-	ticker := time.NewTicker(m.cfg.Interval.ConfigPoll)
-	defer ticker.Stop()
+// runConfigSync syncs the config from Panel and applies it when it changed
+func (m *Manager) runConfigSync(ctx context.Context) error {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-m.stopCh:
-			return
-		case <-ticker.C:
-			if err := m.syncConfig(ctx); err != nil {
-				log.Error().Err(err).Msg("Failed to sync config")
-				continue
-			}
-			// Config changes require Xray restart (inbound/outbound/routing structure changes)
-			if err := m.generateAndWriteConfig(); err != nil {
-				log.Error().Err(err).Msg("Failed to generate config")
-				continue
-			}
-			// IMPORTANT: Collect and report traffic BEFORE restarting Xray
-			// Otherwise traffic stats will be lost
-			m.flushTrafficBeforeRestart(ctx)
-			
-			if err := m.process.Restart(ctx); err != nil {
-				log.Error().Err(err).Msg("Failed to restart Xray")
-			}
+	started := time.Now()
+	m.mu.RLock()
+	previous := m.nodeConfig
+	m.mu.RUnlock()
+	changed, err := m.syncConfig(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to sync config")
+		m.recordTask(TaskConfigSync, started, err)
+		return err
+	}
+	if !changed {
+		m.recordTask(TaskConfigSync, started, nil)
+		return nil
+	}
+	// Config changes require core restart (inbound/outbound/routing structure changes)
+	m.reportEgressBindings(ctx)
+	trial := m.beginProbation(ctx)
+	status, err := m.restartWithNewConfig(ctx)
+	m.acknowledgeApply(ctx, applyKindConfig, status, started, err)
+	if status == types.ApplyStatusRejected {
+		// The cores still run the previous config; later restarts, e.g. for
+		// user changes, must render that one rather than the rejected config
+		m.mu.Lock()
+		m.nodeConfig = previous
+		m.mu.Unlock()
+		if previous != nil {
+			log.Warn().Str("version", previous.Version).Msg("Keeping the previous config")
 		}
 	}
+	if trial != nil && status == types.ApplyStatusApplied {
+		m.startProbation(ctx, trial)
+	}
+	m.recordTask(TaskConfigSync, started, err)
+	return err
 }
 
-// flushTrafficBeforeRestart collects and reports traffic before Xray restart
+// flushTrafficBeforeRestart collects and reports traffic before core restart
 func (m *Manager) flushTrafficBeforeRestart(ctx context.Context) {
-	traffics, err := m.grpc.QueryTrafficStats(ctx, true)
+	count, err := m.reportTraffic(ctx)
 	if err != nil {
-		log.Debug().Err(err).Msg("Failed to collect traffic before restart")
+		log.Error().Err(err).Msg("Failed to flush traffic before restart")
 		return
 	}
-	if len(traffics) > 0 {
-		if err := m.client.ReportTraffic(ctx, traffics); err != nil {
-			log.Error().Err(err).Msg("Failed to report traffic before restart")
-		} else {
-			log.Debug().Int("count", len(traffics)).Msg("Traffic flushed before restart")
+	if count > 0 {
+		log.Debug().Int("count", count).Msg("Traffic flushed before restart")
+	}
+}
+
+// runUserSync syncs users from Panel and applies changes, hot where the cores allow it
+func (m *Manager) runUserSync(ctx context.Context) error {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+
+	started := time.Now()
+	oldUsers := m.users
+	oldRateLimits := m.rateLimits
+	changed, err := m.syncUsers(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to sync users")
+		m.recordTask(TaskUserSync, started, err)
+		return err
+	}
+	if !changed {
+		m.recordTask(TaskUserSync, started, nil)
+		return nil
+	}
+	status := types.ApplyStatusHotApplied
+	var applyErrs []error
+
+	// Egress bindings live in routing rules, which the Handler API cannot alter
+	if xray.EgressBindingsChanged(oldUsers, m.users) {
+		m.reportEgressBindings(ctx)
+		status, err = m.restartWithNewConfig(ctx)
+		applyErrs = append(applyErrs, err)
+	} else {
+		coldCores, failures := m.hotSyncUsers(ctx, oldUsers, m.users)
+		if len(failures) > 0 {
+			log.Warn().Int("failed", len(failures)).Msg("Some users could not be changed through the core API")
+			status = types.ApplyStatusPartial
+			applyErrs = append(applyErrs, failures...)
 		}
+		if len(coldCores) > 0 {
+			// Cores without a Handler API (sing-box) and SOCKS/HTTP inbounds pick
+			// up user changes on restart
+			restartStatus, restartErr := m.restartWithNewConfig(ctx, coldCores...)
+			if status != types.ApplyStatusPartial || restartStatus != types.ApplyStatusApplied {
+				status = restartStatus
+			}
+			applyErrs = append(applyErrs, restartErr)
+		}
+	}
+
+	// Sync rate limits via gRPC (hot reload, no restart needed)
+	if err := m.hotSyncRateLimits(ctx, oldRateLimits, m.rateLimits); err != nil {
+		log.Warn().Err(err).Msg("Failed to sync rate limits")
+		applyErrs = append(applyErrs, err)
 	}
+	m.acknowledgeApply(ctx, applyKindUsers, status, started, applyErrs...)
+	err = errors.Join(applyErrs...)
+	m.recordTask(TaskUserSync, started, err)
+	return err
 }
 
-// userSyncLoop periodically syncs users using hot reload (no restart)
-func (m *Manager) userSyncLoop(ctx context.Context) {
-	ticker := time.NewTicker(m.cfg.Interval.UserPoll)
-	defer ticker.Stop()
+// watchLoop applies config and user changes as soon as the source signals
+// them, on top of the polling loops
+func (m *Manager) watchLoop(ctx context.Context, watcher client.Watcher) {
+	changes, err := watcher.Watch(ctx)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to watch for changes, relying on polling")
+		return
+	}
 
 	for {
 		select {
@@ -67,37 +134,51 @@ func (m *Manager) userSyncLoop(ctx context.Context) {
 			return
 		case <-m.stopCh:
 			return
-		case <-ticker.C:
-			oldUsers := m.users
-			oldRateLimits := m.rateLimits
-			if err := m.syncUsers(ctx); err != nil {
-				log.Error().Err(err).Msg("Failed to sync users")
-				continue
+		case change, ok := <-changes:
+			if !ok {
+				return
 			}
-			
-			// Use hot reload via gRPC Handler API instead of restart
-			if err := m.hotSyncUsers(ctx, oldUsers, m.users); err != nil {
-				log.Warn().Err(err).Msg("Hot sync failed, falling back to restart")
-				// Fallback: regenerate config and restart
-				if err := m.generateAndWriteConfig(); err != nil {
-					log.Error().Err(err).Msg("Failed to generate config")
-					continue
-				}
-				if err := m.process.Restart(ctx); err != nil {
-					log.Error().Err(err).Msg("Failed to restart Xray")
-				}
-			}
-			
-			// Sync rate limits via gRPC (hot reload, no restart needed)
-			if err := m.hotSyncRateLimits(ctx, oldRateLimits, m.rateLimits); err != nil {
-				log.Warn().Err(err).Msg("Failed to sync rate limits")
+			log.Info().Str("change", change).Msg("Source changed, syncing")
+			switch change {
+			case "config":
+				m.runConfigSync(ctx)
+			case "users":
+				m.runUserSync(ctx)
 			}
 		}
 	}
 }
 
-// hotSyncUsers synchronizes users via Xray gRPC API without restart
-func (m *Manager) hotSyncUsers(ctx context.Context, oldUsers, newUsers []types.UserConfig) error {
+// restartWithNewConfig regenerates the core configs and restarts the given cores
+// (all when none are given), flushing traffic first. It returns the apply status.
+func (m *Manager) restartWithNewConfig(ctx context.Context, cores ...*coreRuntime) (string, error) {
+	if err := m.generateAndWriteConfig(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to generate config")
+		return types.ApplyStatusRejected, err
+	}
+	// IMPORTANT: Collect and report traffic BEFORE restarting the cores
+	// Otherwise traffic stats will be lost
+	m.flushTrafficBeforeRestart(ctx)
+	if len(cores) == 0 {
+		cores = m.cores
+	}
+	err := m.restartCores(ctx, cores...)
+	m.updateUserEmails(m.users)
+	return applyStatus(cores, err), err
+}
+
+// hotSyncUsers synchronizes users via the Xray gRPC API without restart.
+// It returns the cores whose users changed but can only pick them up with a
+// restart, and the users the API failed to add or remove.
+func (m *Manager) hotSyncUsers(ctx context.Context, oldUsers, newUsers []types.UserConfig) ([]*coreRuntime, []error) {
+	// Users assigned to an inbound template join every expanded instance
+	m.mu.RLock()
+	templateTags := xray.TemplateInboundTags(m.appliedConfig)
+	inbounds := xray.InboundsByTag(m.appliedConfig)
+	m.mu.RUnlock()
+	oldUsers = xray.ExpandUserInboundTags(oldUsers, templateTags)
+	newUsers = xray.ExpandUserInboundTags(newUsers, templateTags)
+
 	// Build maps for comparison
 	oldMap := make(map[string]map[string]bool) // inboundTag -> email -> exists
 	newMap := make(map[string]map[string]*types.UserConfig) // inboundTag -> email -> user
@@ -131,6 +212,8 @@ func (m *Manager) hotSyncUsers(ctx context.Context, oldUsers, newUsers []types.U
 		allTags[tag] = true
 	}
 
+	coldCores := make(map[*coreRuntime]bool)
+	var failures []error
 	for tag := range allTags {
 		oldEmails := oldMap[tag]
 		newUserMap := newMap[tag]
@@ -141,11 +224,27 @@ func (m *Manager) hotSyncUsers(ctx context.Context, oldUsers, newUsers []types.U
 			newUserMap = make(map[string]*types.UserConfig)
 		}
 
-		// Remove users not in new list
+		inbound, known := inbounds[tag]
+		core := m.coreFor(inbound.Protocol)
+		if known && core != nil && (!core.hotUsers || !xray.HotUsers(inbound.Protocol)) {
+			if usersDiffer(oldEmails, newUserMap) {
+				coldCores[core] = true
+			}
+			continue
+		}
+		if core == nil || !core.hotUsers {
+			core = m.primaryCore()
+		}
+
+		// Remove users not in new list; an inbound no longer rendered took its
+		// users with it
 		for email := range oldEmails {
-			if _, exists := newUserMap[email]; !exists {
-				if err := m.grpc.RemoveUser(ctx, tag, email); err != nil {
-					log.Debug().Err(err).Str("email", email).Str("inbound", tag).Msg("Failed to remove user")
+			if _, exists := newUserMap[email]; !exists && known {
+				err := core.api.RemoveUser(ctx, tag, email)
+				m.countHotSync("remove_user", err)
+				if err != nil {
+					log.Warn().Err(err).Str("email", email).Str("inbound", tag).Msg("Failed to remove user")
+					failures = append(failures, fmt.Errorf("remove %s from %s: %w", email, tag, err))
 				}
 			}
 		}
@@ -153,22 +252,50 @@ func (m *Manager) hotSyncUsers(ctx context.Context, oldUsers, newUsers []types.U
 		// Add users not in old list
 		for email, user := range newUserMap {
 			if !oldEmails[email] {
-				if err := m.grpc.AddUser(ctx, tag, user); err != nil {
+				if !known {
+					log.Warn().Str("email", email).Str("inbound", tag).Msg("User assigned to unknown inbound")
+					continue
+				}
+				err := core.api.AddUser(ctx, &inbound, user)
+				m.countHotSync("add_user", err)
+				if err != nil {
 					log.Warn().Err(err).Str("email", email).Str("inbound", tag).Msg("Failed to add user")
+					failures = append(failures, fmt.Errorf("add %s to %s: %w", email, tag, err))
 				}
 			}
 		}
 	}
 
-	// Update tracked emails
+	m.updateUserEmails(newUsers)
+
+	cores := make([]*coreRuntime, 0, len(coldCores))
+	for core := range coldCores {
+		cores = append(cores, core)
+	}
+	return cores, failures
+}
+
+// usersDiffer reports whether an inbound's user set changed
+func usersDiffer(oldEmails map[string]bool, newUsers map[string]*types.UserConfig) bool {
+	if len(oldEmails) != len(newUsers) {
+		return true
+	}
+	for email := range newUsers {
+		if !oldEmails[email] {
+			return true
+		}
+	}
+	return false
+}
+
+// updateUserEmails updates the tracked user emails
+func (m *Manager) updateUserEmails(users []types.UserConfig) {
 	m.mu.Lock()
-	m.userEmails = make([]string, 0, len(newUsers))
-	for _, u := range newUsers {
+	m.userEmails = make([]string, 0, len(users))
+	for _, u := range users {
 		m.userEmails = append(m.userEmails, u.Email)
 	}
 	m.mu.Unlock()
-
-	return nil
 }
 
 // hotSyncRateLimits synchronizes rate limits via Xray gRPC API without restart
@@ -183,22 +310,39 @@ func (m *Manager) hotSyncRateLimits(ctx context.Context, oldLimits, newLimits []
 		newMap[rl.Email] = rl
 	}
 
-	// Remove rate limits for users no longer in the list
-	for email := range oldMap {
-		if _, exists := newMap[email]; !exists {
-			if err := m.grpc.RemoveUserRateLimit(ctx, email); err != nil {
-				log.Debug().Err(err).Str("email", email).Msg("Failed to remove rate limit")
+	for _, core := range m.cores {
+		if !core.hotUsers {
+			continue
+		}
+
+		// Remove rate limits for users no longer in the list
+		for email := range oldMap {
+			if _, exists := newMap[email]; !exists {
+				err := core.api.RemoveUserRateLimit(ctx, email)
+				if errors.Is(err, xray.ErrRateLimitUnsupported) {
+					break
+				}
+				m.countHotSync("remove_rate_limit", err)
+				if err != nil {
+					log.Debug().Err(err).Str("email", email).Msg("Failed to remove rate limit")
+				}
 			}
 		}
-	}
 
-	// Set/update rate limits for users in the new list
-	for email, newRL := range newMap {
-		oldRL, exists := oldMap[email]
-		// Set if new or changed
-		if !exists || oldRL.UploadBytesPerSec != newRL.UploadBytesPerSec || oldRL.DownloadBytesPerSec != newRL.DownloadBytesPerSec {
-			if err := m.grpc.SetUserRateLimit(ctx, email, newRL.UploadBytesPerSec, newRL.DownloadBytesPerSec); err != nil {
-				log.Warn().Err(err).Str("email", email).Msg("Failed to set rate limit")
+		// Set/update rate limits for users in the new list
+		for email, newRL := range newMap {
+			oldRL, exists := oldMap[email]
+			// Set if new or changed
+			if !exists || oldRL.UploadBytesPerSec != newRL.UploadBytesPerSec || oldRL.DownloadBytesPerSec != newRL.DownloadBytesPerSec {
+				err := core.api.SetUserRateLimit(ctx, email, newRL.UploadBytesPerSec, newRL.DownloadBytesPerSec)
+				if errors.Is(err, xray.ErrRateLimitUnsupported) {
+					log.Debug().Str("core", core.coreType.String()).Msg("Core does not support rate limits, skipping")
+					break
+				}
+				m.countHotSync("set_rate_limit", err)
+				if err != nil {
+					log.Warn().Err(err).Str("email", email).Msg("Failed to set rate limit")
+				}
 			}
 		}
 	}
@@ -207,124 +351,127 @@ func (m *Manager) hotSyncRateLimits(ctx context.Context, oldLimits, newLimits []
 	return nil
 }
 
-// trafficReportLoop periodically reports traffic
-func (m *Manager) trafficReportLoop(ctx context.Context) {
-	ticker := time.NewTicker(m.cfg.Interval.TrafficReport)
-	defer ticker.Stop()
+// runTrafficReport reports the traffic collected since the previous run
+func (m *Manager) runTrafficReport(ctx context.Context) {
+	started := time.Now()
+	count, err := m.reportTraffic(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to report traffic")
+	} else if count > 0 {
+		log.Debug().Int("count", count).Msg("Traffic reported")
+	}
+	m.recordTask(TaskTrafficReport, started, err)
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-m.stopCh:
-			return
-		case <-ticker.C:
-			// Collect traffic from Xray Stats API (with reset to avoid double counting)
-			traffics, err := m.grpc.QueryTrafficStats(ctx, true)
-			if err != nil {
-				log.Debug().Err(err).Msg("Failed to collect traffic from Xray")
-				continue
-			}
-			if len(traffics) > 0 {
-				if err := m.client.ReportTraffic(ctx, traffics); err != nil {
-					log.Error().Err(err).Msg("Failed to report traffic")
-				} else {
-					log.Debug().Int("count", len(traffics)).Msg("Traffic reported")
-				}
-			}
-		}
+// reportTraffic collects traffic from every core's Stats API (with reset to
+// avoid double counting) and reports it, returning the number of users reported
+func (m *Manager) reportTraffic(ctx context.Context) (int, error) {
+	if err := m.sampleTraffic(ctx); err != nil {
+		return 0, fmt.Errorf("collect traffic: %w", err)
+	}
+	traffics := m.takePending()
+	if len(traffics) == 0 {
+		return 0, nil
+	}
+	if err := m.client.ReportTraffic(ctx, traffics); err != nil {
+		return 0, err
 	}
+	return len(traffics), nil
 }
 
-// statusReportLoop periodically reports status
-func (m *Manager) statusReportLoop(ctx context.Context) {
-	ticker := time.NewTicker(m.cfg.Interval.StatusReport)
-	defer ticker.Stop()
+// runStatusReport reports host and core status
+func (m *Manager) runStatusReport(ctx context.Context) {
+	started := time.Now()
+	status := m.stats.CollectStatus(m.primaryCore().process.GetVersion())
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-m.stopCh:
-			return
-		case <-ticker.C:
-			status := m.stats.CollectStatus(m.process.GetVersion())
-			
-			// Get online user count from tracked emails
-			m.mu.RLock()
-			onlineCount := 0
-			for _, email := range m.userEmails {
-				count, err := m.grpc.GetUserOnlineCount(ctx, email)
-				if err == nil && count > 0 {
-					onlineCount++
-				}
-			}
-			m.mu.RUnlock()
-			status.OnlineUsers = onlineCount
-			
-			if err := m.client.ReportStatus(ctx, status); err != nil {
-				log.Error().Err(err).Msg("Failed to report status")
-			}
-		}
+	// Get online user count from tracked emails
+	status.OnlineUsers = m.onlineUserCount(ctx)
+	status.Cores = m.coreStatuses()
+	status.Active = m.activeVersions()
+
+	err := m.client.ReportStatus(ctx, status)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to report status")
 	}
+	m.recordTask(TaskStatusReport, started, err)
 }
 
-// aliveReportLoop periodically reports online users for device limit enforcement
-func (m *Manager) aliveReportLoop(ctx context.Context) {
-	ticker := time.NewTicker(m.cfg.Interval.AlivePoll)
-	defer ticker.Stop()
+// runAliveReport reports online users for device limit enforcement
+func (m *Manager) runAliveReport(ctx context.Context) {
+	started := time.Now()
+	err := m.reportAlive(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to report alive")
+	}
+	m.recordTask(TaskAliveReport, started, err)
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-m.stopCh:
-			return
-		case <-ticker.C:
-			// Collect online users from Xray Stats API
-			m.mu.RLock()
-			emails := make([]string, len(m.userEmails))
-			copy(emails, m.userEmails)
-			m.mu.RUnlock()
-
-			aliveUsers, err := m.grpc.GetAllOnlineUsers(ctx, emails)
-			if err != nil {
-				log.Debug().Err(err).Msg("Failed to collect online users")
-				continue
-			}
+// reportAlive reports online users and kicks those Panel marks as over their device limit
+func (m *Manager) reportAlive(ctx context.Context) error {
+	// Collect online users from Xray Stats API
+	m.mu.RLock()
+	emails := make([]string, len(m.userEmails))
+	copy(emails, m.userEmails)
+	m.mu.RUnlock()
 
-			// Report to Panel
-			resp, err := m.client.ReportAlive(ctx, aliveUsers)
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to report alive")
-				continue
-			}
+	aliveUsers, err := m.primaryCore().api.GetAllOnlineUsers(ctx, emails)
+	if err != nil {
+		return fmt.Errorf("collect online users: %w", err)
+	}
+	m.setAlive(aliveUsers)
 
-			// Kick users that exceed device limit
-			if len(resp.KickUsers) > 0 {
-				log.Info().Strs("users", resp.KickUsers).Msg("Kicking users exceeding device limit")
-				m.mu.RLock()
-				inboundTags := m.getInboundTags()
-				m.mu.RUnlock()
-				
-				for _, email := range resp.KickUsers {
-					if err := m.grpc.KickUser(ctx, email, inboundTags); err != nil {
-						log.Warn().Err(err).Str("email", email).Msg("Failed to kick user")
-					}
-				}
-			}
+	// Report to Panel
+	resp, err := m.client.ReportAlive(ctx, aliveUsers)
+	if err != nil {
+		return err
+	}
+	if resp.Intervals != nil {
+		m.ApplyPanelIntervals(*resp.Intervals)
+	}
+
+	// Kick users that exceed device limit
+	if len(resp.KickUsers) > 0 {
+		log.Info().Strs("users", resp.KickUsers).Msg("Kicking users exceeding device limit")
+		for _, email := range resp.KickUsers {
+			m.recordEvent("kick", "%s kicked, over device limit", email)
+			m.kickUser(ctx, email)
+		}
+	}
+	return nil
+}
+
+// kickUser removes a user from every inbound of the cores with a Handler API
+func (m *Manager) kickUser(ctx context.Context, email string) error {
+	var lastErr error
+	for _, core := range m.cores {
+		if !core.hotUsers {
+			continue
+		}
+		m.mu.RLock()
+		inboundTags := m.getInboundTags(core)
+		m.mu.RUnlock()
+
+		err := core.api.KickUser(ctx, email, inboundTags)
+		m.countHotSync("kick_user", err)
+		if err != nil {
+			log.Warn().Err(err).Str("email", email).Msg("Failed to kick user")
+			lastErr = err
 		}
 	}
+	return lastErr
 }
 
-// getInboundTags returns all inbound tags from current config
-func (m *Manager) getInboundTags() []string {
-	if m.nodeConfig == nil {
+// getInboundTags returns the inbound tags served by a core from current config
+func (m *Manager) getInboundTags(core *coreRuntime) []string {
+	if m.appliedConfig == nil {
 		return nil
 	}
-	tags := make([]string, 0, len(m.nodeConfig.Inbounds))
-	for _, inb := range m.nodeConfig.Inbounds {
-		tags = append(tags, inb.Tag)
+	inbounds := xray.InboundsByTag(m.appliedConfig)
+	tags := make([]string, 0, len(inbounds))
+	for tag, inb := range inbounds {
+		if core.serves(inb.Protocol) {
+			tags = append(tags, tag)
+		}
 	}
 	return tags
 }
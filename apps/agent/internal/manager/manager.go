@@ -3,59 +3,88 @@ package manager
 import (
 	"context"
 	"sync"
+	"time"
 
-	"github.com/rs/zerolog/log"
 	"github.com/synexim/panel-agent/internal/client"
 	"github.com/synexim/panel-agent/internal/config"
 	"github.com/synexim/panel-agent/internal/reporter"
+	"github.com/synexim/panel-agent/internal/scheduler"
+	"github.com/synexim/panel-agent/internal/singbox"
 	"github.com/synexim/panel-agent/internal/xray"
 	"github.com/synexim/panel-agent/pkg/types"
 )
 
 // Manager orchestrates all agent components
 type Manager struct {
-	cfg       *config.Config
-	client    *client.Client
-	generator *xray.ConfigGenerator
-	process   *xray.ProcessManager
-	stats     *reporter.StatsCollector
-	grpc      *xray.GRPCClient
-
-	nodeConfig *types.NodeConfig
-	users      []types.UserConfig
-	rateLimits []types.RateLimitConfig
-	userEmails []string // Track current user emails for hot sync
-	mu         sync.RWMutex
+	cfg    *config.Config
+	client client.Panel // Panel API, or the local files in standalone mode
+	stats  *reporter.StatsCollector
+	cores  []*coreRuntime
 
+	nodeConfig    *types.NodeConfig
+	appliedConfig *types.NodeConfig // last rendered config, without inbounds excluded by preflight
+	users         []types.UserConfig
+	rateLimits    []types.RateLimitConfig
+	userEmails    []string // Track current user emails for hot sync
+	usersVersion  string
+	usersETag     string
+	active        types.ActiveVersions // versions last applied successfully
+	mu            sync.RWMutex
+
+	throughput      rateMeter          // traffic collected from the cores, for probation baselines
+	probationCancel context.CancelFunc // stops watching the config on probation
+	applyMu         sync.Mutex         // serializes syncs, restarts and rollbacks
+
+	scheduler *scheduler.Scheduler // runs the periodic syncs and reports
+	intervals *intervals           // of the periodic tasks, from the agent config and Panel
+	panel     config.PanelConfig   // Panel connection in use, to notice changes on reload
+
+	startedAt time.Time
+	tasks     map[string]types.TaskResult
+	counters  counters    // Prometheus metrics
+	live      liveTraffic // pending traffic, live rates and events for `agent top`
+	lastApply map[string]types.ApplyResult
+
+	ctx    context.Context // of Start, outlives the admin requests that trigger syncs and restarts
 	stopCh chan struct{}
 }
 
 // ManagerParams holds dependencies for Manager (Wire provider params)
 type ManagerParams struct {
 	Cfg       *config.Config
-	Client    *client.Client
+	Client    client.Panel
 	Generator *xray.ConfigGenerator
-	Process   *xray.ProcessManager
+	Singbox   *singbox.ConfigGenerator
+	Process   xray.CoreProcess // external binary or embedded core
 	Stats     *reporter.StatsCollector
-	GRPC      *xray.GRPCClient
+	API       xray.CoreAPI // gRPC client or embedded core
+
+	SingboxProcess *SingboxProcess
 }
 
 // New creates a new manager with injected dependencies (Wire provider)
 func New(params ManagerParams) *Manager {
 	return &Manager{
-		cfg:       params.Cfg,
-		client:    params.Client,
-		generator: params.Generator,
-		process:   params.Process,
-		stats:     params.Stats,
-		grpc:      params.GRPC,
-		stopCh:    make(chan struct{}),
+		cfg:    params.Cfg,
+		client: params.Client,
+		stats:  params.Stats,
+		cores:  buildCores(params),
+		stopCh: make(chan struct{}),
+
+		scheduler: scheduler.New(params.Cfg.Interval.Jitter),
+		intervals: &intervals{local: params.Cfg.Interval},
+		panel:     params.Cfg.Panel,
+
+		tasks:     make(map[string]types.TaskResult),
+		lastApply: make(map[string]types.ApplyResult),
 	}
 }
 
 // Start starts the agent
 func (m *Manager) Start(ctx context.Context) error {
 	log.Info().Msg("Starting Panel Agent")
+	m.startedAt = time.Now()
+	m.ctx = ctx
 
 	// Register with Panel
 	if err := m.register(ctx); err != nil {
@@ -63,32 +92,38 @@ func (m *Manager) Start(ctx context.Context) error {
 	}
 
 	// Initial config and user sync
-	if err := m.syncConfig(ctx); err != nil {
+	if _, err := m.syncConfig(ctx); err != nil {
 		return err
 	}
-	if err := m.syncUsers(ctx); err != nil {
+	if _, err := m.syncUsers(ctx); err != nil {
 		return err
 	}
 
 	// Generate and write Xray config
-	if err := m.generateAndWriteConfig(); err != nil {
+	started := time.Now()
+	if err := m.generateAndWriteConfig(ctx); err != nil {
+		m.acknowledgeApply(ctx, applyKindConfig, types.ApplyStatusRejected, started, err)
 		return err
 	}
 
-	// Start Xray
-	if err := m.process.Start(ctx); err != nil {
+	// Start the cores
+	if err := m.startCores(ctx); err != nil {
+		m.acknowledgeApply(ctx, applyKindConfig, types.ApplyStatusFailed, started, err)
 		return err
 	}
+	m.acknowledgeApply(ctx, applyKindConfig, types.ApplyStatusApplied, started)
+	m.acknowledgeApply(ctx, applyKindUsers, types.ApplyStatusApplied, started)
 
 	// Report egress IPs
 	m.reportEgressIPs(ctx)
+	m.reportEgressBindings(ctx)
 
 	// Start background tasks
-	go m.configSyncLoop(ctx)
-	go m.userSyncLoop(ctx)
-	go m.trafficReportLoop(ctx)
-	go m.statusReportLoop(ctx)
-	go m.aliveReportLoop(ctx)
+	m.schedule()
+	m.scheduler.Start(ctx)
+	if watcher, ok := m.client.(client.Watcher); ok {
+		go m.watchLoop(ctx, watcher)
+	}
 
 	log.Info().Msg("Panel Agent started successfully")
 	return nil
@@ -0,0 +1,247 @@
+package manager
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/synexim/panel-agent/internal/metrics"
+	"github.com/synexim/panel-agent/internal/xray"
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// otherUsersLabel collects the traffic of users beyond metrics.max_users
+const otherUsersLabel = "_other"
+
+// counters accumulates the agent's metrics between scrapes
+type counters struct {
+	mu          sync.Mutex
+	userTraffic map[string]*types.TrafficReport // cumulative since the agent started
+	userOrder   []string                        // emails in order of first traffic, picks the labeled users
+	tasks       map[string]*taskCounter
+	hotSync     map[[2]string]int64 // op, result
+	restarts    map[[2]string]int64 // core, result
+	applies     map[[2]string]int64 // kind, status
+	online      int
+}
+
+type taskCounter struct {
+	runs        int64
+	failures    int64
+	seconds     float64
+	lastSuccess time.Time
+}
+
+func (c *counters) addUserTraffic(reports []types.TrafficReport) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.userTraffic == nil {
+		c.userTraffic = make(map[string]*types.TrafficReport)
+	}
+	for _, r := range reports {
+		total, ok := c.userTraffic[r.Email]
+		if !ok {
+			total = &types.TrafficReport{Email: r.Email}
+			c.userTraffic[r.Email] = total
+			c.userOrder = append(c.userOrder, r.Email)
+		}
+		total.Upload += r.Upload
+		total.Download += r.Download
+	}
+}
+
+func (c *counters) observeTask(name string, started time.Time, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tasks == nil {
+		c.tasks = make(map[string]*taskCounter)
+	}
+	t := c.tasks[name]
+	if t == nil {
+		t = &taskCounter{}
+		c.tasks[name] = t
+	}
+	t.runs++
+	t.seconds += time.Since(started).Seconds()
+	if err != nil {
+		t.failures++
+	} else {
+		t.lastSuccess = time.Now()
+	}
+}
+
+// inc increments a two-label counter, creating the map on first use
+func (c *counters) inc(m *map[[2]string]int64, a, b string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if *m == nil {
+		*m = make(map[[2]string]int64)
+	}
+	(*m)[[2]string{a, b}]++
+}
+
+// countHotSync counts a Handler API operation by its outcome
+func (m *Manager) countHotSync(op string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.counters.inc(&m.counters.hotSync, op, result)
+}
+
+func (c *counters) setOnline(n int) {
+	c.mu.Lock()
+	c.online = n
+	c.mu.Unlock()
+}
+
+// CollectMetrics writes the agent, core and traffic metrics
+func (m *Manager) CollectMetrics(ctx context.Context, w *metrics.Writer) {
+	w.Gauge("panel_agent_start_time_seconds", "Unix time the agent started", float64(m.startedAt.Unix()))
+
+	host := m.stats.CollectStatus("")
+	w.Gauge("panel_agent_host_cpu_usage_percent", "Host CPU usage", host.CPUUsage)
+	w.Gauge("panel_agent_host_memory_usage_percent", "Host memory usage", host.MemoryUsage)
+	w.Gauge("panel_agent_host_disk_usage_percent", "Host disk usage", host.DiskUsage)
+
+	m.collectCores(ctx, w)
+
+	m.mu.RLock()
+	totalUsers := len(m.users)
+	m.mu.RUnlock()
+	w.Gauge("panel_agent_users", "Users synced from Panel", float64(totalUsers))
+
+	m.counters.mu.Lock()
+	defer m.counters.mu.Unlock()
+	w.Gauge("panel_agent_online_users", "Users with at least one online session at the last check", float64(m.counters.online))
+	m.collectUserTraffic(w)
+	m.collectTasks(w)
+	writePairs(w, "panel_agent_hot_sync_operations_total", "Handler API user and rate limit operations", "op", "result", m.counters.hotSync)
+	writePairs(w, "panel_agent_core_restarts_total", "Core restarts by outcome", "core", "result", m.counters.restarts)
+	writePairs(w, "panel_agent_applies_total", "Config and user applies by outcome", "kind", "status", m.counters.applies)
+}
+
+// collectCores writes core state and the traffic per inbound and outbound tag
+func (m *Manager) collectCores(ctx context.Context, w *metrics.Writer) {
+	statuses := m.coreStatuses()
+	for _, s := range statuses {
+		w.Gauge("panel_agent_core_up", "Whether the core process is running", boolValue(s.Running), "core", s.CoreType.String())
+	}
+	for _, s := range statuses {
+		w.Gauge("panel_agent_core_ready", "Whether the core passed its last readiness check", boolValue(s.Ready), "core", s.CoreType.String())
+	}
+
+	results := make(map[string]*xray.StatsResult)
+	for _, c := range m.cores {
+		querier, ok := c.api.(xray.TagTrafficQuerier)
+		if !ok || !c.process.IsRunning() {
+			continue
+		}
+		queryCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		result, err := querier.QueryTagTraffic(queryCtx)
+		cancel()
+		if err == nil {
+			results[c.coreType.String()] = result
+		}
+	}
+
+	for _, core := range sortedKeys(results) {
+		for _, s := range results[core].Inbounds {
+			w.Counter("panel_agent_inbound_bytes_total", "Traffic per inbound since the core started", float64(s.Upload), "core", core, "tag", s.Tag, "direction", "uplink")
+			w.Counter("panel_agent_inbound_bytes_total", "", float64(s.Download), "core", core, "tag", s.Tag, "direction", "downlink")
+		}
+	}
+	for _, core := range sortedKeys(results) {
+		for _, s := range results[core].Outbounds {
+			w.Counter("panel_agent_outbound_bytes_total", "Traffic per outbound since the core started", float64(s.Upload), "core", core, "tag", s.Tag, "direction", "uplink")
+			w.Counter("panel_agent_outbound_bytes_total", "", float64(s.Download), "core", core, "tag", s.Tag, "direction", "downlink")
+		}
+	}
+}
+
+// collectUserTraffic writes cumulative user traffic, labeling users by email
+// and summing the rest so the series count stays bounded. The labeled users
+// are the first max_users to have traffic: picking them by rank would move
+// bytes between series on every scrape and make the counters go down.
+func (m *Manager) collectUserTraffic(w *metrics.Writer) {
+	const name, help = "panel_agent_user_bytes_total", "Traffic per user since the agent started"
+
+	var upload, download int64
+	for _, t := range m.counters.userTraffic {
+		upload += t.Upload
+		download += t.Download
+	}
+	w.Counter("panel_agent_traffic_bytes_total", "Traffic of all users since the agent started", float64(upload), "direction", "uplink")
+	w.Counter("panel_agent_traffic_bytes_total", "", float64(download), "direction", "downlink")
+
+	if !m.cfg.Metrics.PerUser {
+		return
+	}
+	limit := m.cfg.Metrics.MaxUsers
+	other := types.TrafficReport{Email: otherUsersLabel}
+	for i, email := range m.counters.userOrder {
+		t := m.counters.userTraffic[email]
+		if limit > 0 && i >= limit {
+			other.Upload += t.Upload
+			other.Download += t.Download
+			continue
+		}
+		w.Counter(name, help, float64(t.Upload), "email", t.Email, "direction", "uplink")
+		w.Counter(name, help, float64(t.Download), "email", t.Email, "direction", "downlink")
+	}
+	if limit > 0 && len(m.counters.userOrder) > limit {
+		w.Counter(name, help, float64(other.Upload), "email", other.Email, "direction", "uplink")
+		w.Counter(name, help, float64(other.Download), "email", other.Email, "direction", "downlink")
+	}
+}
+
+// collectTasks writes the run count, duration and failures of every periodic task
+func (m *Manager) collectTasks(w *metrics.Writer) {
+	names := sortedKeys(m.counters.tasks)
+	for _, name := range names {
+		t := m.counters.tasks[name]
+		w.Summary("panel_agent_task_duration_seconds", "Duration of periodic task runs", t.seconds, t.runs, "task", name)
+	}
+	for _, name := range names {
+		w.Counter("panel_agent_task_failures_total", "Failed periodic task runs", float64(m.counters.tasks[name].failures), "task", name)
+	}
+	for _, name := range names {
+		if last := m.counters.tasks[name].lastSuccess; !last.IsZero() {
+			w.Gauge("panel_agent_task_last_success_timestamp_seconds", "Unix time of the last successful run", float64(last.Unix()), "task", name)
+		}
+	}
+}
+
+// writePairs writes a counter family with two labels in a stable order
+func writePairs(w *metrics.Writer, name, help, labelA, labelB string, values map[[2]string]int64) {
+	keys := make([][2]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	for _, k := range keys {
+		w.Counter(name, help, float64(values[k]), labelA, k[0], labelB, k[1])
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
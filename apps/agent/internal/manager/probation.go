@@ -0,0 +1,266 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/synexim/panel-agent/internal/xray"
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// probationFailureLimit is how many consecutive failed health checks trigger a rollback
+const probationFailureLimit = 2
+
+// probation tracks a config change that is watched before it is trusted
+type probation struct {
+	version string
+	etag    string
+
+	applyStarted   time.Time
+	started        time.Time // cores ready with the new config
+	baselineRate   float64   // bytes/s before the change
+	baselineOnline int
+
+	prevConfig *types.NodeConfig
+	prevActive types.ActiveVersions
+}
+
+// beginProbation cancels a running probation and, when probation is enabled,
+// captures the baseline before a config change is applied
+func (m *Manager) beginProbation(ctx context.Context) *probation {
+	m.mu.Lock()
+	if m.probationCancel != nil {
+		m.probationCancel()
+		m.probationCancel = nil
+	}
+	prev := m.appliedConfig
+	active := m.active
+	m.mu.Unlock()
+
+	if !m.cfg.Core.Probation.Enabled || prev == nil {
+		return nil
+	}
+	return &probation{
+		applyStarted:   time.Now(),
+		baselineOnline: m.onlineUserCount(ctx),
+		prevConfig:     prev,
+		prevActive:     active,
+	}
+}
+
+// startProbation watches the newly applied config for the probation window
+func (m *Manager) startProbation(ctx context.Context, p *probation) {
+	cfg := m.cfg.Core.Probation
+	p.started = time.Now()
+	p.baselineRate = m.throughput.rate(p.applyStarted.Add(-cfg.Window), p.started)
+
+	m.mu.Lock()
+	p.version, p.etag = m.nodeConfig.Version, m.nodeConfig.ETag
+	watchCtx, cancel := context.WithCancel(ctx)
+	m.probationCancel = cancel
+	m.mu.Unlock()
+
+	log.Info().
+		Str("version", p.version).
+		Dur("window", cfg.Window).
+		Float64("baselineRate", p.baselineRate).
+		Int("baselineOnline", p.baselineOnline).
+		Msg("Config on probation")
+	go m.watchProbation(ctx, watchCtx, p)
+}
+
+// watchProbation checks the node's health until the window ends and rolls the
+// config back when a check fails. watchCtx is cancelled when a newer config
+// replaces this one; the rollback itself runs on ctx.
+func (m *Manager) watchProbation(ctx, watchCtx context.Context, p *probation) {
+	cfg := m.cfg.Core.Probation
+	interval := cfg.CheckInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-watchCtx.Done():
+			return
+		case <-m.stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		if reason := m.probationHealth(watchCtx); reason != "" {
+			failures++
+			log.Warn().Str("version", p.version).Int("failures", failures).Msg("Probation check failed: " + reason)
+			if failures >= probationFailureLimit {
+				m.rollbackProbation(ctx, watchCtx, p, reason)
+				return
+			}
+			continue
+		}
+		failures = 0
+
+		if time.Since(p.started) < cfg.Window {
+			continue
+		}
+		if reason := m.probationTraffic(watchCtx, p); reason != "" {
+			m.rollbackProbation(ctx, watchCtx, p, reason)
+			return
+		}
+		log.Info().Str("version", p.version).Msg("Config passed probation")
+		return
+	}
+}
+
+// probationHealth checks that every core runs and its inbounds listen
+func (m *Manager) probationHealth(ctx context.Context) string {
+	for _, c := range m.cores {
+		if !c.process.IsRunning() {
+			return fmt.Sprintf("%s core exited", c.coreType)
+		}
+		checkCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err := xray.CheckReady(checkCtx, c.api, m.expectedInbounds(c))
+		cancel()
+		if err != nil {
+			return fmt.Sprintf("%s core unhealthy: %v", c.coreType, err)
+		}
+	}
+	return ""
+}
+
+// probationTraffic compares traffic and online users with the baseline
+func (m *Manager) probationTraffic(ctx context.Context, p *probation) string {
+	cfg := m.cfg.Core.Probation
+
+	// Sample now: traffic reports alone may have left the end of the window
+	// unsampled. The traffic stays pending for the next report.
+	if err := m.sampleTraffic(ctx); err != nil {
+		log.Debug().Err(err).Msg("Failed to sample traffic for probation")
+	}
+	rate := m.throughput.rate(p.started, time.Now())
+	if p.baselineRate >= float64(cfg.MinBaselineRate) && rate < p.baselineRate*cfg.MinTrafficRatio {
+		return fmt.Sprintf("traffic fell to %.0f B/s from %.0f B/s before the change", rate, p.baselineRate)
+	}
+
+	online := m.onlineUserCount(ctx)
+	if p.baselineOnline >= cfg.MinBaselineOnline && float64(online) < float64(p.baselineOnline)*cfg.MinOnlineRatio {
+		return fmt.Sprintf("online users fell to %d from %d before the change", online, p.baselineOnline)
+	}
+	return ""
+}
+
+// rollbackProbation restores the config that ran before the change and
+// reports the rollback with its reason. It gives up when a newer config
+// replaced the one on probation while waiting for the apply lock.
+func (m *Manager) rollbackProbation(ctx, watchCtx context.Context, p *probation, reason string) {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+	if watchCtx.Err() != nil {
+		return
+	}
+
+	log.Error().Str("version", p.version).Str("reason", reason).Msg("Config failed probation, rolling back")
+
+	m.mu.Lock()
+	m.nodeConfig = p.prevConfig
+	m.mu.Unlock()
+
+	status, err := m.restartWithNewConfig(ctx)
+	hash := m.renderedConfigHash()
+	if status == types.ApplyStatusApplied {
+		status = types.ApplyStatusRolledBack
+		m.mu.Lock()
+		m.active.ConfigVersion, m.active.ConfigETag = p.prevActive.ConfigVersion, p.prevActive.ConfigETag
+		m.active.ConfigHash = hash
+		m.mu.Unlock()
+	} else {
+		status = types.ApplyStatusFailed
+	}
+
+	result := &types.ApplyResult{
+		Kind:       applyKindConfig,
+		Version:    p.version,
+		ETag:       p.etag,
+		ConfigHash: hash,
+		Status:     status,
+		DurationMs: time.Since(p.started).Milliseconds(),
+		Errors:     []string{reason},
+		Reason:     reason,
+		AppliedAt:  time.Now().Unix(),
+	}
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+	}
+	m.reportApplyResult(ctx, result)
+}
+
+// onlineUserCount counts users with at least one online session on any core
+func (m *Manager) onlineUserCount(ctx context.Context) int {
+	// One API call per user and core: query without holding the lock.
+	// updateUserEmails replaces the slice, so the copy stays intact.
+	m.mu.RLock()
+	emails := m.userEmails
+	m.mu.RUnlock()
+
+	onlineCount := 0
+	for _, email := range emails {
+		for _, core := range m.cores {
+			count, err := core.api.GetUserOnlineCount(ctx, email)
+			if err == nil && count > 0 {
+				onlineCount++
+				break
+			}
+		}
+	}
+	m.counters.setOnline(onlineCount)
+	return onlineCount
+}
+
+// rateMeterRetention bounds how long traffic samples are kept
+const rateMeterRetention = 30 * time.Minute
+
+// rateMeter keeps recent traffic totals to compare throughput over time
+type rateMeter struct {
+	mu      sync.Mutex
+	samples []rateSample
+}
+
+type rateSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// add records the bytes collected since the previous sample
+func (r *rateMeter) add(bytes int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.samples = append(r.samples, rateSample{at: now, bytes: bytes})
+	cutoff := now.Add(-rateMeterRetention)
+	for len(r.samples) > 0 && r.samples[0].at.Before(cutoff) {
+		r.samples = r.samples[1:]
+	}
+}
+
+// rate returns the average bytes/s of the samples collected in (from, to]
+func (r *rateMeter) rate(from, to time.Time) float64 {
+	seconds := to.Sub(from).Seconds()
+	if seconds <= 0 {
+		return 0
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var total int64
+	for _, s := range r.samples {
+		if s.at.After(from) && !s.at.After(to) {
+			total += s.bytes
+		}
+	}
+	return float64(total) / seconds
+}
@@ -0,0 +1,40 @@
+package manager
+
+import (
+	"context"
+
+	"github.com/synexim/panel-agent/internal/client"
+	"github.com/synexim/panel-agent/internal/config"
+)
+
+// Reload applies a reloaded agent config to the running agent: the task
+// intervals and the Panel connection. When the Panel URL or token changed
+// the node registers again and syncs config and users from the new Panel.
+// Settings read only at startup are left to the caller to report.
+func (m *Manager) Reload(ctx context.Context, cfg *config.Config) {
+	m.scheduler.SetJitter(cfg.Interval.Jitter)
+	m.setLocalIntervals(cfg.Interval)
+
+	reconfigurer, ok := m.client.(client.Reconfigurer)
+	if !ok {
+		return
+	}
+	m.mu.Lock()
+	panelChanged := cfg.Panel.URL != m.panel.URL || cfg.Panel.Token != m.panel.Token
+	m.panel = cfg.Panel
+	m.mu.Unlock()
+
+	reconfigurer.Reconfigure(cfg)
+	if !panelChanged {
+		return
+	}
+	log.Info().Str("url", cfg.Panel.URL).Msg("Panel connection changed, registering again")
+	go func() {
+		if err := m.register(ctx); err != nil {
+			log.Error().Err(err).Msg("Failed to register with the new Panel")
+			return
+		}
+		m.runConfigSync(ctx)
+		m.runUserSync(ctx)
+	}()
+}
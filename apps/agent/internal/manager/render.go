@@ -0,0 +1,41 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/synexim/panel-agent/internal/xray"
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// FetchConfig fetches the node config and users from Panel without applying them
+func (m *Manager) FetchConfig(ctx context.Context) (*types.NodeConfig, []types.UserConfig, error) {
+	nodeConfig, _, err := m.client.GetConfig(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get config: %w", err)
+	}
+	resp, _, err := m.client.GetUsers(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get users: %w", err)
+	}
+	return nodeConfig, resp.Users, nil
+}
+
+// RenderConfigs generates the config of every core without writing or
+// applying it, keyed by core type
+func (m *Manager) RenderConfigs(nodeConfig *types.NodeConfig, users []types.UserConfig) (map[string]interface{}, error) {
+	configs := make(map[string]interface{}, len(m.cores))
+	for _, c := range m.cores {
+		cfg, err := c.generate(splitNodeConfig(nodeConfig, c), users)
+		if err != nil {
+			return nil, fmt.Errorf("render %s config: %w", c.coreType, err)
+		}
+		configs[c.coreType.String()] = cfg
+	}
+	return configs, nil
+}
+
+// ValidateConfig checks a node config against the protocols of the configured cores
+func (m *Manager) ValidateConfig(nodeConfig *types.NodeConfig) []types.ConfigDiagnostic {
+	return xray.ValidateNodeConfig(nodeConfig, m.mergedCapabilities())
+}
@@ -0,0 +1,108 @@
+package manager
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/synexim/panel-agent/internal/config"
+	"github.com/synexim/panel-agent/internal/grpc"
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// intervals are the periods of the periodic tasks: those of the agent
+// config, overridden by the ones Panel sends
+type intervals struct {
+	mu    sync.RWMutex
+	local config.IntervalConfig
+	panel config.IntervalConfig // zero where Panel sent nothing
+}
+
+// get returns the current interval of a task
+func (iv *intervals) get(task string) time.Duration {
+	iv.mu.RLock()
+	defer iv.mu.RUnlock()
+
+	pick := func(local, panel time.Duration) time.Duration {
+		if panel > 0 {
+			return panel
+		}
+		return local
+	}
+	switch task {
+	case TaskConfigSync:
+		return pick(iv.local.ConfigPoll, iv.panel.ConfigPoll)
+	case TaskUserSync:
+		return pick(iv.local.UserPoll, iv.panel.UserPoll)
+	case TaskTrafficReport:
+		return pick(iv.local.TrafficReport, iv.panel.TrafficReport)
+	case TaskStatusReport:
+		return pick(iv.local.StatusReport, iv.panel.StatusReport)
+	case TaskAliveReport:
+		return pick(iv.local.AlivePoll, iv.panel.AlivePoll)
+	}
+	return 0
+}
+
+// schedule registers the periodic tasks with the scheduler
+func (m *Manager) schedule() {
+	for name, run := range map[string]func(ctx context.Context){
+		TaskConfigSync:    func(ctx context.Context) { m.runConfigSync(ctx) },
+		TaskUserSync:      func(ctx context.Context) { m.runUserSync(ctx) },
+		TaskTrafficReport: m.runTrafficReport,
+		TaskStatusReport:  m.runStatusReport,
+		TaskAliveReport:   m.runAliveReport,
+	} {
+		m.scheduler.Add(name, m.intervals.get(name), run)
+	}
+}
+
+// setLocalIntervals applies the intervals of a reloaded agent config
+func (m *Manager) setLocalIntervals(local config.IntervalConfig) {
+	m.intervals.mu.Lock()
+	m.intervals.local = local
+	m.intervals.mu.Unlock()
+	m.retune("config")
+}
+
+// ApplyPanelIntervals applies the intervals Panel sent, on register, in an
+// alive response or over the stream connection. Zero leaves an interval as
+// it is, Panel sources that only know some of them do not reset the others.
+func (m *Manager) ApplyPanelIntervals(iv types.Intervals) {
+	set := func(d *time.Duration, seconds int) {
+		if seconds > 0 {
+			*d = time.Duration(seconds) * time.Second
+		}
+	}
+	m.intervals.mu.Lock()
+	set(&m.intervals.panel.ConfigPoll, iv.ConfigPollInterval)
+	set(&m.intervals.panel.UserPoll, iv.UserPollInterval)
+	set(&m.intervals.panel.TrafficReport, iv.TrafficReportInterval)
+	set(&m.intervals.panel.StatusReport, iv.StatusReportInterval)
+	set(&m.intervals.panel.AlivePoll, iv.AlivePollInterval)
+	m.intervals.mu.Unlock()
+	m.retune("panel")
+}
+
+// AttachStream applies the traffic and status intervals of a Panel stream
+// connection, those it registered with already and those of later
+// registrations
+func (m *Manager) AttachStream(stream *grpc.StreamClient) {
+	stream.SetIntervalsCallback(m.ApplyPanelIntervals)
+	if traffic, status := stream.GetIntervals(); traffic > 0 || status > 0 {
+		m.ApplyPanelIntervals(types.Intervals{
+			TrafficReportInterval: int(traffic),
+			StatusReportInterval:  int(status),
+		})
+	}
+}
+
+// retune moves the scheduled tasks to their current intervals
+func (m *Manager) retune(source string) {
+	for _, name := range scheduledTasks {
+		interval := m.intervals.get(name)
+		if m.scheduler.Retune(name, interval) {
+			log.Info().Str("task", name).Dur("interval", interval).Str("source", source).Msg("Task interval changed")
+		}
+	}
+}
@@ -2,13 +2,14 @@ package manager
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"os"
 	"runtime"
 	"strings"
-	"time"
 
-	"github.com/rs/zerolog/log"
+	"github.com/synexim/panel-agent/internal/preflight"
+	"github.com/synexim/panel-agent/internal/xray"
 	"github.com/synexim/panel-agent/pkg/types"
 )
 
@@ -16,7 +17,8 @@ import (
 func (m *Manager) Stop() {
 	log.Info().Msg("Stopping Panel Agent")
 	close(m.stopCh)
-	m.process.Stop()
+	m.scheduler.Stop()
+	m.stopCores()
 }
 
 // register registers the node with Panel
@@ -24,11 +26,12 @@ func (m *Manager) register(ctx context.Context) error {
 	hostname, _ := os.Hostname()
 	
 	req := &types.RegisterRequest{
-		Hostname:    hostname,
-		OS:          runtime.GOOS,
-		Arch:        runtime.GOARCH,
-		PublicIP:    m.getPublicIP(),
-		XrayVersion: m.process.GetVersion(),
+		Hostname:     hostname,
+		OS:           runtime.GOOS,
+		Arch:         runtime.GOARCH,
+		PublicIP:     m.getPublicIP(),
+		XrayVersion:  m.primaryCore().process.GetVersion(),
+		Capabilities: m.mergedCapabilities(),
 	}
 
 	resp, err := m.client.Register(ctx, req)
@@ -42,34 +45,20 @@ func (m *Manager) register(ctx context.Context) error {
 		Str("xrayVersion", req.XrayVersion).
 		Msg("Registered with Panel")
 
-	// Update intervals from server response (convert to duration)
-	if resp.ConfigPollInterval > 0 {
-		m.cfg.Interval.ConfigPoll = time.Duration(resp.ConfigPollInterval) * time.Second
-	}
-	if resp.UserPollInterval > 0 {
-		m.cfg.Interval.UserPoll = time.Duration(resp.UserPollInterval) * time.Second
-	}
-	if resp.TrafficReportInterval > 0 {
-		m.cfg.Interval.TrafficReport = time.Duration(resp.TrafficReportInterval) * time.Second
-	}
-	if resp.StatusReportInterval > 0 {
-		m.cfg.Interval.StatusReport = time.Duration(resp.StatusReportInterval) * time.Second
-	}
-	if resp.AlivePollInterval > 0 {
-		m.cfg.Interval.AlivePoll = time.Duration(resp.AlivePollInterval) * time.Second
-	}
+	// Intervals from Panel override the ones of the agent config
+	m.ApplyPanelIntervals(resp.Intervals)
 
 	return nil
 }
 
-// syncConfig syncs configuration from Panel
-func (m *Manager) syncConfig(ctx context.Context) error {
+// syncConfig syncs configuration from Panel and reports whether it changed
+func (m *Manager) syncConfig(ctx context.Context) (bool, error) {
 	config, changed, err := m.client.GetConfig(ctx)
 	if err != nil {
-		return err
+		return false, err
 	}
 	if !changed {
-		return nil
+		return false, nil
 	}
 
 	m.mu.Lock()
@@ -77,30 +66,59 @@ func (m *Manager) syncConfig(ctx context.Context) error {
 	m.mu.Unlock()
 
 	log.Info().Str("version", config.Version).Msg("Config synced from Panel")
-	return nil
+	m.validateConfig(ctx, config)
+	return true, nil
 }
 
-// syncUsers syncs users from Panel
-func (m *Manager) syncUsers(ctx context.Context) error {
+// validateConfig validates a synced config against the cores' capabilities
+// and reports the diagnostics to Panel
+func (m *Manager) validateConfig(ctx context.Context, nodeConfig *types.NodeConfig) {
+	diagnostics := m.ValidateConfig(nodeConfig)
+	for _, d := range diagnostics {
+		event := log.Warn()
+		if d.Severity == types.DiagnosticError {
+			event = log.Error()
+		}
+		event.Str("code", d.Code).Str("path", d.Path).Str("version", nodeConfig.Version).Msg(d.Message)
+	}
+
+	report := &types.ConfigValidationReport{
+		ConfigVersion: nodeConfig.Version,
+		Valid:         !xray.HasErrors(diagnostics),
+		Diagnostics:   diagnostics,
+	}
+	if report.Diagnostics == nil {
+		report.Diagnostics = []types.ConfigDiagnostic{}
+	}
+	if err := m.client.ReportConfigValidation(ctx, report); err != nil {
+		log.Warn().Err(err).Msg("Failed to report config validation")
+	}
+}
+
+// syncUsers syncs users from Panel and reports whether they changed
+func (m *Manager) syncUsers(ctx context.Context) (bool, error) {
 	resp, changed, err := m.client.GetUsers(ctx)
 	if err != nil {
-		return err
+		return false, err
 	}
 	if !changed {
-		return nil
+		return false, nil
 	}
 
 	m.mu.Lock()
 	m.users = resp.Users
 	m.rateLimits = resp.RateLimits
+	m.usersVersion = resp.Version
+	m.usersETag = resp.ETag
 	m.mu.Unlock()
 
 	log.Info().Int("count", len(resp.Users)).Int("rateLimits", len(resp.RateLimits)).Msg("Users synced from Panel")
-	return nil
+	return true, nil
 }
 
-// generateAndWriteConfig generates Xray config and writes to file
-func (m *Manager) generateAndWriteConfig() error {
+// generateAndWriteConfig checks inbound ports, then generates the config of
+// every core and writes it to file
+func (m *Manager) generateAndWriteConfig(ctx context.Context) error {
 	m.mu.RLock()
 	nodeConfig := m.nodeConfig
 	users := m.users
@@ -110,12 +128,65 @@ func (m *Manager) generateAndWriteConfig() error {
 		return nil
 	}
 
-	config, err := m.generator.Generate(nodeConfig, users)
+	nodeConfig, err := m.preflightPorts(ctx, nodeConfig)
 	if err != nil {
 		return err
 	}
+	if err := m.renderCores(nodeConfig, users); err != nil {
+		return err
+	}
 
-	return m.generator.WriteConfig(config)
+	m.mu.Lock()
+	m.appliedConfig = nodeConfig
+	m.mu.Unlock()
+	return nil
+}
+
+// preflightPorts checks the inbound ports of a config before it is applied and
+// reports conflicts to Panel. Depending on the policy the conflicting inbounds
+// are excluded from the returned config, or the config is rejected.
+func (m *Manager) preflightPorts(ctx context.Context, nodeConfig *types.NodeConfig) (*types.NodeConfig, error) {
+	m.mu.RLock()
+	applied := m.appliedConfig
+	m.mu.RUnlock()
+
+	candidate := *nodeConfig
+	candidate.ExcludedInbounds = nil
+	conflicts := preflight.CheckPorts(xray.AllInbounds(&candidate), xray.AllInbounds(applied))
+	if len(conflicts) == 0 {
+		return &candidate, nil
+	}
+
+	policy := m.cfg.Core.PortConflictPolicy
+	if policy != preflight.PolicyReject {
+		policy = preflight.PolicyExclude
+	}
+	for _, c := range conflicts {
+		log.Warn().
+			Str("inbound", c.Tag).
+			Str("network", c.Network).
+			Str("listen", c.Listen).
+			Int("port", c.Port).
+			Str("conflictsWith", c.ConflictsWith).
+			Str("reason", c.Reason).
+			Msg("Inbound port conflict")
+	}
+
+	report := &types.PortConflictReport{
+		ConfigVersion: nodeConfig.Version,
+		Policy:        policy,
+		Conflicts:     conflicts,
+	}
+	if err := m.client.ReportPortConflicts(ctx, report); err != nil {
+		log.Warn().Err(err).Msg("Failed to report port conflicts")
+	}
+
+	if policy == preflight.PolicyReject {
+		return nil, fmt.Errorf("config %s rejected: %d inbound port conflicts", nodeConfig.Version, len(conflicts))
+	}
+	candidate.ExcludedInbounds = preflight.ExcludedTags(conflicts)
+	log.Warn().Strs("inbounds", candidate.ExcludedInbounds).Msg("Excluding inbounds with port conflicts")
+	return &candidate, nil
 }
 
 // getPublicIP gets the public IP address
@@ -178,3 +249,34 @@ func (m *Manager) reportEgressIPs(ctx context.Context) {
 		}
 	}
 }
+
+// reportEgressBindings reports user and inbound template egress IP bindings
+// that cannot be honored on this node
+func (m *Manager) reportEgressBindings(ctx context.Context) {
+	m.mu.RLock()
+	users := m.users
+	nodeConfig := m.nodeConfig
+	m.mu.RUnlock()
+
+	localIPs := xray.LocalIPs()
+	_, issues := xray.ResolveEgressBindings(users, localIPs)
+	if nodeConfig != nil {
+		issues = append(issues, xray.TemplateEgressIssues(nodeConfig.InboundTemplates, localIPs)...)
+	}
+	if len(issues) == 0 {
+		return
+	}
+
+	for _, issue := range issues {
+		event := log.Warn()
+		if issue.Template != "" {
+			event = event.Str("template", issue.Template)
+		} else {
+			event = event.Str("email", issue.Email)
+		}
+		event.Str("egressIp", issue.EgressIP).Str("reason", issue.Reason).Msg("Egress IP binding not honored")
+	}
+	if err := m.client.ReportEgressBindings(ctx, issues); err != nil {
+		log.Error().Err(err).Msg("Failed to report egress bindings")
+	}
+}
@@ -0,0 +1,168 @@
+// Package metrics exposes agent and core metrics in the Prometheus text format
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/synexim/panel-agent/internal/config"
+)
+
+// Collector writes its current metrics on every scrape
+type Collector interface {
+	CollectMetrics(ctx context.Context, w *Writer)
+}
+
+// Writer writes metric families in the Prometheus text exposition format.
+// Samples of one family must be written consecutively.
+type Writer struct {
+	w        *bufio.Writer
+	declared map[string]bool
+}
+
+// NewWriter creates a Writer on top of w; call Flush when done
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w), declared: make(map[string]bool)}
+}
+
+// Flush writes buffered output
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// Gauge writes a gauge sample; labels are name/value pairs
+func (w *Writer) Gauge(name, help string, value float64, labels ...string) {
+	w.declare(name, help, "gauge")
+	w.sample(name, value, labels)
+}
+
+// Counter writes a counter sample; labels are name/value pairs
+func (w *Writer) Counter(name, help string, value float64, labels ...string) {
+	w.declare(name, help, "counter")
+	w.sample(name, value, labels)
+}
+
+// Summary writes the sum and count of a summary without quantiles
+func (w *Writer) Summary(name, help string, sum float64, count int64, labels ...string) {
+	w.declare(name, help, "summary")
+	w.sample(name+"_sum", sum, labels)
+	w.sample(name+"_count", float64(count), labels)
+}
+
+func (w *Writer) declare(name, help, kind string) {
+	if w.declared[name] {
+		return
+	}
+	w.declared[name] = true
+	fmt.Fprintf(w.w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, kind)
+}
+
+func (w *Writer) sample(name string, value float64, labels []string) {
+	w.w.WriteString(name)
+	if len(labels) > 0 {
+		w.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.w.WriteByte(',')
+			}
+			w.w.WriteString(labels[i])
+			w.w.WriteString(`="`)
+			w.w.WriteString(escapeLabel(labels[i+1]))
+			w.w.WriteByte('"')
+		}
+		w.w.WriteByte('}')
+	}
+	w.w.WriteByte(' ')
+	w.w.WriteString(formatValue(value))
+	w.w.WriteByte('\n')
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+// Handler serves the metrics of all collectors
+func Handler(collectors ...Collector) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w := NewWriter(rw)
+		for _, c := range collectors {
+			c.CollectMetrics(r.Context(), w)
+		}
+		w.Flush()
+	})
+}
+
+// Server serves /metrics on its own listener
+type Server struct {
+	cfg        config.MetricsConfig
+	collectors []Collector
+	server     *http.Server
+}
+
+// NewServer creates the metrics server
+func NewServer(cfg *config.Config, collectors ...Collector) *Server {
+	return &Server{cfg: cfg.Metrics, collectors: collectors}
+}
+
+// Start listens on the configured address and serves in the background
+func (s *Server) Start() error {
+	if !s.cfg.Enabled {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", s.cfg.Listen)
+	if err != nil {
+		return fmt.Errorf("listen metrics: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", Handler(s.collectors...))
+	s.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("Metrics server stopped")
+		}
+	}()
+
+	log.Info().Str("address", s.cfg.Listen).Msg("Metrics listening")
+	return nil
+}
+
+// Stop shuts the server down
+func (s *Server) Stop() {
+	if s.server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.server.Shutdown(ctx)
+}
@@ -0,0 +1,180 @@
+// Package preflight checks a node config against the host before it is applied
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// Port conflict policies
+const (
+	PolicyExclude = "exclude" // drop conflicting inbounds and apply the rest
+	PolicyReject  = "reject"  // refuse to apply the config
+)
+
+// portBinding is one listener an inbound needs
+type portBinding struct {
+	tag     string
+	network string
+	listen  string
+	port    int
+}
+
+func (b portBinding) key() string {
+	return fmt.Sprintf("%s/%s/%d", b.network, b.listen, b.port)
+}
+
+// CheckPorts finds inbounds whose ports clash with another inbound of the same
+// config or cannot be bound on the host. Listeners of owned inbounds (the
+// config currently applied) are held by the running core and not probed.
+// The first inbound claiming a port wins, later ones are reported.
+func CheckPorts(inbounds []types.InboundConfig, owned []types.InboundConfig) []types.PortConflict {
+	ownedKeys := make(map[string]bool)
+	for i := range owned {
+		for _, b := range bindings(&owned[i]) {
+			ownedKeys[b.key()] = true
+		}
+	}
+
+	var conflicts []types.PortConflict
+	claimed := make(map[string][]portBinding) // network/port -> bindings
+	for i := range inbounds {
+		for _, b := range bindings(&inbounds[i]) {
+			slot := fmt.Sprintf("%s/%d", b.network, b.port)
+			if other, ok := overlapping(claimed[slot], b); ok {
+				conflicts = append(conflicts, conflict(b, "duplicate port in config", other.tag))
+				continue
+			}
+			claimed[slot] = append(claimed[slot], b)
+
+			if ownedKeys[b.key()] {
+				continue
+			}
+			if err := probeBind(b); err != nil {
+				conflicts = append(conflicts, conflict(b, err.Error(), ""))
+			}
+		}
+	}
+	return conflicts
+}
+
+// ExcludedTags returns the distinct inbound tags of a set of conflicts
+func ExcludedTags(conflicts []types.PortConflict) []string {
+	seen := make(map[string]bool)
+	var tags []string
+	for _, c := range conflicts {
+		if !seen[c.Tag] {
+			seen[c.Tag] = true
+			tags = append(tags, c.Tag)
+		}
+	}
+	return tags
+}
+
+func conflict(b portBinding, reason, with string) types.PortConflict {
+	return types.PortConflict{
+		Tag:           b.tag,
+		Listen:        b.listen,
+		Port:          b.port,
+		Network:       b.network,
+		Reason:        reason,
+		ConflictsWith: with,
+	}
+}
+
+// overlapping returns a claimed binding sharing an address with b
+func overlapping(claimed []portBinding, b portBinding) (portBinding, bool) {
+	for _, c := range claimed {
+		if isWildcard(c.listen) || isWildcard(b.listen) || c.listen == b.listen {
+			return c, true
+		}
+	}
+	return portBinding{}, false
+}
+
+// bindings lists the listeners an inbound opens. Unix socket inbounds and
+// inbounds without a port are not checked.
+func bindings(inbound *types.InboundConfig) []portBinding {
+	listen := inbound.Listen
+	if strings.HasPrefix(listen, "/") || strings.HasPrefix(listen, "@") || inbound.Port <= 0 || inbound.Port > 65535 {
+		return nil
+	}
+	if listen == "" {
+		listen = "0.0.0.0"
+	}
+
+	result := make([]portBinding, 0, 2)
+	for _, network := range inboundNetworks(inbound) {
+		result = append(result, portBinding{tag: inbound.Tag, network: network, listen: listen, port: inbound.Port})
+	}
+	return result
+}
+
+// inboundNetworks returns the transport networks an inbound listens on
+func inboundNetworks(inbound *types.InboundConfig) []string {
+	switch inbound.Protocol {
+	case "hysteria2", "tuic":
+		return []string{"udp"}
+	}
+	if stream, ok := inbound.StreamSettings.(map[string]interface{}); ok {
+		switch stream["network"] {
+		case "kcp", "mkcp", "quic":
+			return []string{"udp"}
+		}
+	}
+
+	settings, _ := inbound.Settings.(map[string]interface{})
+	var networks []string
+	if network, ok := settings["network"].(string); ok && network != "" {
+		// shadowsocks and dokodemo-door: "tcp", "udp" or "tcp,udp"
+		for _, n := range strings.Split(network, ",") {
+			if n = strings.TrimSpace(n); n == "tcp" || n == "udp" {
+				networks = appendNetwork(networks, n)
+			}
+		}
+	}
+	if len(networks) == 0 {
+		networks = []string{"tcp"}
+	}
+	if udp, _ := settings["udp"].(bool); udp && inbound.Protocol == "socks" {
+		networks = appendNetwork(networks, "udp")
+	}
+	return networks
+}
+
+func appendNetwork(networks []string, network string) []string {
+	for _, n := range networks {
+		if n == network {
+			return networks
+		}
+	}
+	return append(networks, network)
+}
+
+// probeBind briefly binds the listener. SO_REUSEPORT lets the probe share
+// ports with cores that also set it, so only foreign listeners conflict.
+func probeBind(b portBinding) error {
+	lc := net.ListenConfig{Control: reusePortControl}
+	address := net.JoinHostPort(b.listen, strconv.Itoa(b.port))
+	if b.network == "udp" {
+		conn, err := lc.ListenPacket(context.Background(), "udp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	listener, err := lc.Listen(context.Background(), "tcp", address)
+	if err != nil {
+		return err
+	}
+	return listener.Close()
+}
+
+func isWildcard(listen string) bool {
+	return listen == "" || listen == "0.0.0.0" || listen == "::"
+}
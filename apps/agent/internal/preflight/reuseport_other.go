@@ -0,0 +1,10 @@
+//go:build !linux
+
+package preflight
+
+import "syscall"
+
+// reusePortControl is a no-op where SO_REUSEPORT semantics differ
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,197 @@
+// Package scheduler runs the agent's periodic tasks. Intervals can be
+// retuned while the tasks run, and every period is jittered so a fleet of
+// nodes started together does not hit Panel on the same second.
+package scheduler
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// minInterval keeps a bad interval from turning a task into a busy loop
+const minInterval = time.Second
+
+// Task is one run of a periodic task
+type Task func(ctx context.Context)
+
+// Scheduler runs named tasks at their own interval
+type Scheduler struct {
+	mu     sync.Mutex
+	jitter float64 // fraction of the interval each period varies by, 0..0.5
+	tasks  map[string]*task
+	order  []string
+	ctx    context.Context // of Start, nil until then
+
+	stopCh  chan struct{}
+	stopped sync.Once
+}
+
+type task struct {
+	name     string
+	run      Task
+	interval time.Duration
+	last     time.Time // end of the previous run, or the start of the scheduler
+	next     time.Time
+	retune   chan struct{} // wakes the task up to pick up a new interval
+	stop     chan struct{} // closed when the task is replaced
+	started  bool
+}
+
+// New creates a scheduler with the given jitter, see SetJitter
+func New(jitter float64) *Scheduler {
+	s := &Scheduler{
+		tasks:  make(map[string]*task),
+		stopCh: make(chan struct{}),
+	}
+	s.SetJitter(jitter)
+	return s
+}
+
+// SetJitter sets the fraction, capped at 0.5, by which every period is
+// randomly shortened or lengthened. It applies from the next period.
+func (s *Scheduler) SetJitter(jitter float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jitter = min(max(jitter, 0), 0.5)
+}
+
+// Add registers a task, replacing a task of the same name; a run of the
+// replaced task in progress finishes. Tasks added after Start begin right
+// away, with their first run one jittered interval later.
+func (s *Scheduler) Add(name string, interval time.Duration, run Task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.tasks[name]; ok {
+		close(old.stop)
+	} else {
+		s.order = append(s.order, name)
+	}
+	t := &task{
+		name:     name,
+		run:      run,
+		interval: interval,
+		retune:   make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+	}
+	s.tasks[name] = t
+	if s.ctx != nil {
+		s.start(t)
+	}
+}
+
+// Start runs every task in its own goroutine until ctx is done or Stop is
+// called. The first run of each task comes one jittered interval after start.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ctx = ctx
+	for _, name := range s.order {
+		if t := s.tasks[name]; !t.started {
+			s.start(t)
+		}
+	}
+}
+
+// start schedules the first run of a task and starts its loop; s.mu must be held
+func (s *Scheduler) start(t *task) {
+	t.started = true
+	t.last = time.Now()
+	t.next = t.last.Add(s.period(t.interval))
+	go s.loop(s.ctx, t)
+}
+
+// Stop stops all tasks; a run in progress finishes
+func (s *Scheduler) Stop() {
+	s.stopped.Do(func() { close(s.stopCh) })
+}
+
+// Retune changes the interval of a task. The pending run is rescheduled
+// one jittered new interval after the previous run, or runs now when that
+// time has passed. It reports whether the interval changed.
+func (s *Scheduler) Retune(name string, interval time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tasks[name]
+	if !ok || interval <= 0 || interval == t.interval {
+		return false
+	}
+	if t.started {
+		t.next = t.last.Add(s.period(interval))
+	}
+	t.interval = interval
+	select {
+	case t.retune <- struct{}{}:
+	default: // the task has not picked up the previous change yet
+	}
+	return true
+}
+
+// Interval returns the current interval of a task
+func (s *Scheduler) Interval(name string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.tasks[name]; ok {
+		return t.interval
+	}
+	return 0
+}
+
+// Next returns when a task runs next, zero when it is not running
+func (s *Scheduler) Next(name string) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.tasks[name]; ok && t.started {
+		return t.next
+	}
+	return time.Time{}
+}
+
+// period returns a jittered interval; s.mu must be held
+func (s *Scheduler) period(interval time.Duration) time.Duration {
+	interval = max(interval, minInterval)
+	if s.jitter == 0 {
+		return interval
+	}
+	offset := (rand.Float64()*2 - 1) * s.jitter
+	return max(time.Duration(float64(interval)*(1+offset)), minInterval)
+}
+
+func (s *Scheduler) loop(ctx context.Context, t *task) {
+	timer := time.NewTimer(s.untilNext(t))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stopCh:
+			return
+		case <-t.stop:
+			return
+		case <-t.retune:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(s.untilNext(t))
+		case <-timer.C:
+			t.run(ctx)
+
+			s.mu.Lock()
+			t.last = time.Now()
+			t.next = t.last.Add(s.period(t.interval))
+			s.mu.Unlock()
+			timer.Reset(s.untilNext(t))
+		}
+	}
+}
+
+func (s *Scheduler) untilNext(t *task) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return max(time.Until(t.next), 0)
+}
@@ -0,0 +1,38 @@
+package singbox
+
+import (
+	"os/exec"
+	"regexp"
+
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// DetectCapabilities detects sing-box core capabilities
+func DetectCapabilities(binaryPath string) *types.CoreCapabilities {
+	return &types.CoreCapabilities{
+		CoreType: types.CoreTypeSingbox,
+		Version:  detectVersion(binaryPath),
+		Protocols: types.Protocols{
+			Inbound:  []string{"hysteria2", "tuic"},
+			Outbound: []string{"direct", "block"},
+		},
+		Transports: []string{"quic"},
+		Features:   []string{"stats", "bandwidth-hint"},
+	}
+}
+
+// detectVersion runs sing-box version command and parses output
+func detectVersion(binaryPath string) string {
+	output, err := exec.Command(binaryPath, "version").Output()
+	if err != nil {
+		return "unknown"
+	}
+
+	// Parse version from output like "sing-box version 1.10.1"
+	re := regexp.MustCompile(`sing-box version\s+(\d+\.\d+\.\d+)`)
+	matches := re.FindStringSubmatch(string(output))
+	if len(matches) > 1 {
+		return matches[1]
+	}
+	return "unknown"
+}
@@ -0,0 +1,314 @@
+package singbox
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+	"github.com/synexim/panel-agent/internal/xray"
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// ConfigGenerator generates sing-box configuration
+type ConfigGenerator struct {
+	configPath string
+	apiAddress string
+}
+
+// NewConfigGenerator creates a new sing-box config generator
+func NewConfigGenerator(configPath, apiAddress string) *ConfigGenerator {
+	return &ConfigGenerator{
+		configPath: configPath,
+		apiAddress: apiAddress,
+	}
+}
+
+// Config represents the full sing-box configuration
+type Config struct {
+	Log          *LogConfig               `json:"log,omitempty"`
+	Inbounds     []map[string]interface{} `json:"inbounds"`
+	Outbounds    []map[string]interface{} `json:"outbounds"`
+	Route        *RouteConfig             `json:"route,omitempty"`
+	Experimental *ExperimentalConfig      `json:"experimental,omitempty"`
+}
+
+// LogConfig represents sing-box log configuration
+type LogConfig struct {
+	Level     string `json:"level"`
+	Timestamp bool   `json:"timestamp"`
+}
+
+// RouteConfig represents sing-box route configuration
+type RouteConfig struct {
+	Rules []map[string]interface{} `json:"rules,omitempty"`
+	Final string                   `json:"final,omitempty"`
+}
+
+// ExperimentalConfig represents sing-box experimental configuration
+type ExperimentalConfig struct {
+	V2RayAPI *V2RayAPIConfig `json:"v2ray_api,omitempty"`
+}
+
+// V2RayAPIConfig exposes the Xray compatible StatsService
+type V2RayAPIConfig struct {
+	Listen string         `json:"listen"`
+	Stats  *V2RayAPIStats `json:"stats"`
+}
+
+// V2RayAPIStats selects which counters the API tracks
+type V2RayAPIStats struct {
+	Enabled   bool     `json:"enabled"`
+	Inbounds  []string `json:"inbounds,omitempty"`
+	Outbounds []string `json:"outbounds,omitempty"`
+	Users     []string `json:"users,omitempty"`
+}
+
+// Generate generates sing-box configuration from Panel config and users.
+// Only inbounds with a registered sing-box protocol are rendered; inbound
+// templates are expanded and Panel routing rules translated as in Xray.
+func (g *ConfigGenerator) Generate(nodeConfig *types.NodeConfig, users []types.UserConfig) (*Config, error) {
+	excluded := make(map[string]bool, len(nodeConfig.ExcludedInbounds))
+	for _, tag := range nodeConfig.ExcludedInbounds {
+		excluded[tag] = true
+	}
+	templateInbounds, templateOutbounds, templateRules, err := xray.ExpandInboundTemplates(nodeConfig.InboundTemplates, excluded, xray.LocalIPs())
+	if err != nil {
+		return nil, err
+	}
+	users = xray.ExpandUserInboundTags(users, xray.TemplateInboundTags(nodeConfig))
+
+	usersByInbound := make(map[string][]types.UserConfig)
+	for _, user := range users {
+		for _, tag := range user.InboundTags {
+			usersByInbound[tag] = append(usersByInbound[tag], user)
+		}
+	}
+
+	config := &Config{
+		Log: &LogConfig{Level: "warn", Timestamp: true},
+	}
+
+	var inboundTags []string
+	statsUsers := make(map[string]bool)
+	inbounds := append(append([]types.InboundConfig(nil), nodeConfig.Inbounds...), templateInbounds...)
+	for i := range inbounds {
+		inbound := &inbounds[i]
+		if excluded[inbound.Tag] {
+			continue
+		}
+		proto, ok := LookupProtocol(inbound.Protocol)
+		if !ok {
+			log.Debug().Str("tag", inbound.Tag).Str("protocol", inbound.Protocol).Msg("Skipping inbound not served by sing-box")
+			continue
+		}
+
+		rendered, err := g.buildInbound(proto, inbound, usersByInbound[inbound.Tag])
+		if err != nil {
+			return nil, err
+		}
+		config.Inbounds = append(config.Inbounds, rendered)
+		inboundTags = append(inboundTags, inbound.Tag)
+		for _, u := range usersByInbound[inbound.Tag] {
+			statsUsers[u.Email] = true
+		}
+	}
+
+	outbounds := append(append([]types.OutboundConfig(nil), nodeConfig.Outbounds...), templateOutbounds...)
+	config.Outbounds, config.Route = g.buildOutbounds(outbounds, users)
+
+	outboundTags := make([]string, 0, len(config.Outbounds))
+	rendered := make(map[string]bool, len(config.Outbounds))
+	for _, ob := range config.Outbounds {
+		tag := ob["tag"].(string)
+		outboundTags = append(outboundTags, tag)
+		rendered[tag] = true
+	}
+
+	// Egress and template rules go after the leading block rules of Panel
+	// and before the others, as in the Xray config
+	served := make(map[string]bool, len(inboundTags))
+	for _, tag := range inboundTags {
+		served[tag] = true
+	}
+	var panelRules []interface{}
+	if nodeConfig.Routing != nil {
+		panelRules = nodeConfig.Routing.Rules
+	}
+	blockRules, otherRules := xray.SplitBlockRules(panelRules, nodeConfig.Outbounds)
+	rules := translateRules(blockRules, served, rendered)
+	rules = append(rules, config.Route.Rules...)
+	rules = append(rules, translateRules(templateRules, served, rendered)...)
+	config.Route.Rules = append(rules, translateRules(otherRules, served, rendered)...)
+	emails := make([]string, 0, len(statsUsers))
+	for email := range statsUsers {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+
+	config.Experimental = &ExperimentalConfig{
+		V2RayAPI: &V2RayAPIConfig{
+			Listen: g.apiAddress,
+			Stats: &V2RayAPIStats{
+				Enabled:   true,
+				Inbounds:  inboundTags,
+				Outbounds: outboundTags,
+				Users:     emails,
+			},
+		},
+	}
+
+	return config, nil
+}
+
+// buildInbound renders one inbound with its users. Panel settings use
+// sing-box field names and are passed through.
+func (g *ConfigGenerator) buildInbound(proto Protocol, inbound *types.InboundConfig, users []types.UserConfig) (map[string]interface{}, error) {
+	rendered := cloneMap(inbound.Settings)
+	rendered["type"] = inbound.Protocol
+	rendered["tag"] = inbound.Tag
+	rendered["listen"] = inbound.Listen
+	if inbound.Listen == "" {
+		rendered["listen"] = "::"
+	}
+	rendered["listen_port"] = inbound.Port
+
+	if _, ok := rendered["tls"]; !ok {
+		if tls := tlsFromStreamSettings(inbound.StreamSettings); tls != nil {
+			rendered["tls"] = tls
+		}
+	}
+
+	renderedUsers := make([]map[string]interface{}, 0, len(users))
+	for i := range users {
+		user, err := proto.User(&users[i])
+		if err != nil {
+			log.Warn().Err(err).Str("inbound", inbound.Tag).Str("email", users[i].Email).Msg("Skipping invalid user")
+			continue
+		}
+		renderedUsers = append(renderedUsers, user)
+	}
+	rendered["users"] = renderedUsers
+
+	if err := proto.Prepare(rendered); err != nil {
+		return nil, err
+	}
+	return rendered, nil
+}
+
+// buildOutbounds translates freedom/blackhole outbounds and routes users
+// with a dedicated egress IP to a bound direct outbound
+func (g *ConfigGenerator) buildOutbounds(outbounds []types.OutboundConfig, users []types.UserConfig) ([]map[string]interface{}, *RouteConfig) {
+	result := []map[string]interface{}{
+		{"type": "direct", "tag": "direct"},
+	}
+	seen := map[string]bool{"direct": true}
+	add := func(ob map[string]interface{}) {
+		tag := ob["tag"].(string)
+		if seen[tag] {
+			return
+		}
+		seen[tag] = true
+		result = append(result, ob)
+	}
+
+	for _, ob := range outbounds {
+		switch ob.Protocol {
+		case "freedom":
+			rendered := map[string]interface{}{"type": "direct", "tag": ob.Tag}
+			setBindAddress(rendered, ob.SendThrough)
+			add(rendered)
+		case "blackhole":
+			add(map[string]interface{}{"type": "block", "tag": ob.Tag})
+		default:
+			log.Debug().Str("tag", ob.Tag).Str("protocol", ob.Protocol).Msg("Skipping outbound not translated for sing-box")
+		}
+	}
+
+	route := &RouteConfig{Final: "direct"}
+	bindings, _ := xray.ResolveEgressBindings(users, xray.LocalIPs())
+	ips := make([]string, 0, len(bindings))
+	for ip := range bindings {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	for _, ip := range ips {
+		tag := xray.EgressOutboundTag(ip)
+		rendered := map[string]interface{}{"type": "direct", "tag": tag}
+		setBindAddress(rendered, ip)
+		add(rendered)
+		route.Rules = append(route.Rules, map[string]interface{}{
+			"auth_user": bindings[ip],
+			"outbound":  tag,
+		})
+	}
+
+	return result, route
+}
+
+// setBindAddress binds a direct outbound to a local address
+func setBindAddress(outbound map[string]interface{}, addr string) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return
+	}
+	if ip.To4() != nil {
+		outbound["inet4_bind_address"] = ip.String()
+	} else {
+		outbound["inet6_bind_address"] = ip.String()
+	}
+}
+
+// tlsFromStreamSettings translates Xray style tlsSettings into a sing-box tls block
+func tlsFromStreamSettings(streamSettings interface{}) map[string]interface{} {
+	stream := cloneMap(streamSettings)
+	tlsSettings, ok := stream["tlsSettings"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	tls := map[string]interface{}{"enabled": true}
+	if serverName, ok := tlsSettings["serverName"].(string); ok && serverName != "" {
+		tls["server_name"] = serverName
+	}
+	if alpn, ok := tlsSettings["alpn"]; ok {
+		tls["alpn"] = alpn
+	}
+	if certs, ok := tlsSettings["certificates"].([]interface{}); ok && len(certs) > 0 {
+		if cert, ok := certs[0].(map[string]interface{}); ok {
+			if path, ok := cert["certificateFile"].(string); ok {
+				tls["certificate_path"] = path
+			}
+			if path, ok := cert["keyFile"].(string); ok {
+				tls["key_path"] = path
+			}
+		}
+	}
+	return tls
+}
+
+// cloneMap deep copies a JSON object, returning an empty map for anything else
+func cloneMap(v interface{}) map[string]interface{} {
+	result := make(map[string]interface{})
+	if v == nil {
+		return result
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return result
+	}
+	if err := json.Unmarshal(data, &result); err != nil || result == nil {
+		return make(map[string]interface{})
+	}
+	return result
+}
+
+// WriteConfig writes configuration to file
+func (g *ConfigGenerator) WriteConfig(config *Config) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(g.configPath, data, 0644)
+}
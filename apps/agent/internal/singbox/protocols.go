@@ -0,0 +1,89 @@
+package singbox
+
+import (
+	"fmt"
+
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// Protocol renders users and inbound settings for one sing-box inbound type
+type Protocol interface {
+	// User renders a user entry of the inbound
+	User(user *types.UserConfig) (map[string]interface{}, error)
+
+	// Prepare finalizes the rendered inbound, e.g. bandwidth hints
+	Prepare(inbound map[string]interface{}) error
+}
+
+var protocols = map[string]Protocol{
+	"hysteria2": hysteria2Protocol{},
+	"tuic":      tuicProtocol{},
+}
+
+// LookupProtocol returns the renderer for a sing-box inbound type
+func LookupProtocol(name string) (Protocol, bool) {
+	p, ok := protocols[name]
+	return p, ok
+}
+
+// IsSingboxProtocol reports whether an inbound protocol is served by sing-box
+func IsSingboxProtocol(name string) bool {
+	_, ok := protocols[name]
+	return ok
+}
+
+// requireTLS checks that a QUIC based inbound has a tls block
+func requireTLS(inbound map[string]interface{}) error {
+	if _, ok := inbound["tls"]; !ok {
+		return fmt.Errorf("inbound %v: %v requires tls settings", inbound["tag"], inbound["type"])
+	}
+	return nil
+}
+
+// hysteria2Protocol renders Hysteria2 inbounds
+type hysteria2Protocol struct{}
+
+func (hysteria2Protocol) User(user *types.UserConfig) (map[string]interface{}, error) {
+	if user.Password == "" {
+		return nil, fmt.Errorf("user %s has no password", user.Email)
+	}
+	return map[string]interface{}{
+		"name":     user.Email,
+		"password": user.Password,
+	}, nil
+}
+
+// Prepare maps the panel's upMbps/downMbps bandwidth hints to sing-box fields
+func (hysteria2Protocol) Prepare(inbound map[string]interface{}) error {
+	for from, to := range map[string]string{"upMbps": "up_mbps", "downMbps": "down_mbps"} {
+		if v, ok := inbound[from]; ok {
+			if _, exists := inbound[to]; !exists {
+				inbound[to] = v
+			}
+			delete(inbound, from)
+		}
+	}
+	return requireTLS(inbound)
+}
+
+// tuicProtocol renders TUIC v5 inbounds
+type tuicProtocol struct{}
+
+func (tuicProtocol) User(user *types.UserConfig) (map[string]interface{}, error) {
+	if user.UUID == "" {
+		return nil, fmt.Errorf("user %s has no uuid", user.Email)
+	}
+	return map[string]interface{}{
+		"name":     user.Email,
+		"uuid":     user.UUID,
+		"password": user.Password,
+	}, nil
+}
+
+func (tuicProtocol) Prepare(inbound map[string]interface{}) error {
+	if v, ok := inbound["congestionControl"]; ok {
+		inbound["congestion_control"] = v
+		delete(inbound, "congestionControl")
+	}
+	return requireTLS(inbound)
+}
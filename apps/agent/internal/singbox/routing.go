@@ -0,0 +1,218 @@
+package singbox
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// translateRules converts Xray routing rules to sing-box route rules.
+// Rules on inbounds sing-box does not serve are left to Xray. A rule with a
+// matcher or target sing-box cannot express is skipped as a whole, since
+// dropping only the matcher would widen it to other traffic.
+func translateRules(rules []interface{}, inbounds, outbounds map[string]bool) []map[string]interface{} {
+	var result []map[string]interface{}
+	for i, rule := range rules {
+		fields, ok := rule.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		translated, err := translateRule(fields, inbounds, outbounds)
+		if err != nil {
+			log.Warn().Err(err).Int("rule", i).Msg("Skipping routing rule not translated for sing-box")
+			continue
+		}
+		if translated != nil {
+			result = append(result, translated)
+		}
+	}
+	return result
+}
+
+// translateRule converts one rule, returning nil when it only concerns
+// inbounds of other cores
+func translateRule(fields map[string]interface{}, inbounds, outbounds map[string]bool) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	for key, value := range fields {
+		switch key {
+		case "type", "ruleTag":
+		case "inboundTag":
+			var tags []string
+			for _, tag := range stringList(value) {
+				if inbounds[tag] {
+					tags = append(tags, tag)
+				}
+			}
+			if len(tags) == 0 {
+				return nil, nil
+			}
+			result["inbound"] = tags
+		case "outboundTag":
+			tag, _ := value.(string)
+			if !outbounds[tag] {
+				return nil, fmt.Errorf("outbound %q is not translated for sing-box", tag)
+			}
+			result["outbound"] = tag
+		case "balancerTag":
+			return nil, fmt.Errorf("balancers are not supported by sing-box")
+		case "user":
+			result["auth_user"] = stringList(value)
+		case "domain":
+			if err := translateDomains(result, stringList(value)); err != nil {
+				return nil, err
+			}
+		case "ip", "source":
+			cidrs, err := translateIPs(stringList(value))
+			if err != nil {
+				return nil, err
+			}
+			if key == "ip" {
+				result["ip_cidr"] = cidrs
+			} else {
+				result["source_ip_cidr"] = cidrs
+			}
+		case "port", "sourcePort":
+			ports, ranges, err := translatePorts(value)
+			if err != nil {
+				return nil, err
+			}
+			prefix := ""
+			if key == "sourcePort" {
+				prefix = "source_"
+			}
+			if len(ports) > 0 {
+				result[prefix+"port"] = ports
+			}
+			if len(ranges) > 0 {
+				result[prefix+"port_range"] = ranges
+			}
+		case "network":
+			result["network"] = splitList(value)
+		case "protocol":
+			result["protocol"] = stringList(value)
+		default:
+			return nil, fmt.Errorf("matcher %q is not supported by sing-box", key)
+		}
+	}
+	if _, ok := result["outbound"]; !ok {
+		return nil, fmt.Errorf("rule has no outboundTag")
+	}
+	return result, nil
+}
+
+// translateDomains maps Xray domain matchers to the sing-box fields
+func translateDomains(result map[string]interface{}, domains []string) error {
+	fields := map[string][]string{}
+	for _, domain := range domains {
+		kind, value, found := strings.Cut(domain, ":")
+		if !found {
+			// A plain Xray domain matches as a substring
+			fields["domain_keyword"] = append(fields["domain_keyword"], domain)
+			continue
+		}
+		switch kind {
+		case "domain":
+			fields["domain_suffix"] = append(fields["domain_suffix"], value)
+		case "full":
+			fields["domain"] = append(fields["domain"], value)
+		case "keyword":
+			fields["domain_keyword"] = append(fields["domain_keyword"], value)
+		case "regexp":
+			fields["domain_regex"] = append(fields["domain_regex"], value)
+		default:
+			return fmt.Errorf("domain %q: %s: lists are not supported by sing-box", domain, kind)
+		}
+	}
+	for field, values := range fields {
+		result[field] = values
+	}
+	return nil
+}
+
+// translateIPs accepts IPs and CIDRs, geoip and ext lists have no
+// equivalent without rule sets
+func translateIPs(ips []string) ([]string, error) {
+	cidrs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		if _, _, err := net.ParseCIDR(ip); err == nil {
+			cidrs = append(cidrs, ip)
+			continue
+		}
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return nil, fmt.Errorf("ip %q is not supported by sing-box", ip)
+		}
+		if parsed.To4() != nil {
+			cidrs = append(cidrs, parsed.String()+"/32")
+		} else {
+			cidrs = append(cidrs, parsed.String()+"/128")
+		}
+	}
+	return cidrs, nil
+}
+
+// translatePorts splits an Xray port list ("53,1000-2000" or a number) into
+// sing-box ports and "start:end" ranges
+func translatePorts(value interface{}) ([]int, []string, error) {
+	var ports []int
+	var ranges []string
+	for _, part := range splitList(value) {
+		start, end, isRange := strings.Cut(part, "-")
+		from, err := strconv.Atoi(strings.TrimSpace(start))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid port %q", part)
+		}
+		if !isRange {
+			ports = append(ports, from)
+			continue
+		}
+		to, err := strconv.Atoi(strings.TrimSpace(end))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid port range %q", part)
+		}
+		ranges = append(ranges, fmt.Sprintf("%d:%d", from, to))
+	}
+	return ports, ranges, nil
+}
+
+// stringList reads a JSON string array, or a single string
+func stringList(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// splitList reads a comma separated value such as "tcp,udp" or a port
+func splitList(value interface{}) []string {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case float64:
+		s = strconv.Itoa(int(v))
+	case int:
+		s = strconv.Itoa(v)
+	}
+	var result []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			result = append(result, part)
+		}
+	}
+	return result
+}
@@ -0,0 +1,163 @@
+// Package standalone runs the agent without Panel: the node config and users
+// come from local YAML or JSON files and traffic is accumulated locally
+package standalone
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ghodss/yaml"
+	"github.com/synexim/panel-agent/internal/client"
+	"github.com/synexim/panel-agent/internal/config"
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// Source serves the config and users from local files and stores the
+// reported traffic. It implements client.Panel.
+type Source struct {
+	cfg config.StandaloneConfig
+
+	mu         sync.Mutex
+	configHash string // of the last config returned, like an ETag
+	usersHash  string
+
+	traffic *trafficStore
+}
+
+var (
+	_ client.Panel   = (*Source)(nil)
+	_ client.Watcher = (*Source)(nil)
+)
+
+// NewSource creates the standalone source
+func NewSource(cfg *config.Config) *Source {
+	return &Source{
+		cfg:     cfg.Standalone,
+		traffic: newTrafficStore(cfg.Standalone.TrafficFile),
+	}
+}
+
+// Register answers locally, there is nothing to register with
+func (s *Source) Register(ctx context.Context, req *types.RegisterRequest) (*types.RegisterResponse, error) {
+	return &types.RegisterResponse{NodeID: "standalone", NodeName: req.Hostname}, nil
+}
+
+// GetConfig reads the node config file, reporting no change while its
+// content stays the same
+func (s *Source) GetConfig(ctx context.Context) (*types.NodeConfig, bool, error) {
+	var nodeConfig types.NodeConfig
+	hash, changed, err := s.load(s.cfg.ConfigFile, &s.configHash, &nodeConfig)
+	if err != nil || !changed {
+		return nil, false, err
+	}
+	if nodeConfig.Version == "" {
+		nodeConfig.Version = "local-" + hash[:8]
+	}
+	nodeConfig.ETag = `"` + hash[:16] + `"`
+	return &nodeConfig, true, nil
+}
+
+// GetUsers reads the users file, reporting no change while its content stays the same
+func (s *Source) GetUsers(ctx context.Context) (*types.UserListResponse, bool, error) {
+	var users types.UserListResponse
+	hash, changed, err := s.load(s.cfg.UsersFile, &s.usersHash, &users)
+	if err != nil || !changed {
+		return nil, false, err
+	}
+	if users.Version == "" {
+		users.Version = "local-" + hash[:8]
+	}
+	users.ETag = `"` + hash[:16] + `"`
+	return &users, true, nil
+}
+
+// load parses a YAML or JSON file into v when its hash differs from *last,
+// and records the new hash once it parsed
+func (s *Source) load(path string, last *string, v interface{}) (string, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("read %s: %w", path, err)
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if hash == *last {
+		return hash, false, nil
+	}
+	// JSON is valid YAML, both go through the json tags of the types
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return "", false, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if err := json.Unmarshal(jsonData, v); err != nil {
+		return "", false, fmt.Errorf("parse %s: %w", path, err)
+	}
+	*last = hash
+	return hash, true, nil
+}
+
+// ReportTraffic adds the traffic to the local store
+func (s *Source) ReportTraffic(ctx context.Context, traffics []types.TrafficReport) error {
+	return s.traffic.add(traffics)
+}
+
+// Traffic returns the traffic accumulated per user
+func (s *Source) Traffic() ([]types.UserTraffic, error) {
+	return s.traffic.list()
+}
+
+// ResetTraffic clears the traffic of one user, or of every user when email is empty
+func (s *Source) ResetTraffic(email string) error {
+	return s.traffic.reset(email)
+}
+
+// ReportAlive never asks to kick users, device limits need Panel
+func (s *Source) ReportAlive(ctx context.Context, users []types.AliveUser) (*types.AliveResponse, error) {
+	return &types.AliveResponse{Success: true}, nil
+}
+
+// ReportStatus is a no-op, the admin API serves the node state
+func (s *Source) ReportStatus(ctx context.Context, status *types.StatusReport) error {
+	return nil
+}
+
+// ReportEgressIPs is a no-op
+func (s *Source) ReportEgressIPs(ctx context.Context, ips []types.EgressIP) error {
+	return nil
+}
+
+// ReportEgressBindings is a no-op, the manager already logs the issues
+func (s *Source) ReportEgressBindings(ctx context.Context, issues []types.EgressBindingIssue) error {
+	return nil
+}
+
+// ReportPortConflicts is a no-op, the manager already logs the conflicts
+func (s *Source) ReportPortConflicts(ctx context.Context, report *types.PortConflictReport) error {
+	return nil
+}
+
+// ReportConfigValidation is a no-op, the manager already logs the diagnostics
+func (s *Source) ReportConfigValidation(ctx context.Context, report *types.ConfigValidationReport) error {
+	return nil
+}
+
+// ReportApplyResult is a no-op, the manager already logs the result
+func (s *Source) ReportApplyResult(ctx context.Context, result *types.ApplyResult) error {
+	return nil
+}
+
+// files maps the watched file names to the change they signal
+func (s *Source) files() map[string]string {
+	return map[string]string{
+		filepath.Clean(s.cfg.ConfigFile): "config",
+		filepath.Clean(s.cfg.UsersFile):  "users",
+	}
+}
@@ -0,0 +1,154 @@
+package standalone
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// trafficStore accumulates user traffic in a JSON file
+type trafficStore struct {
+	path string
+
+	mu     sync.Mutex
+	users  map[string]*types.UserTraffic
+	loaded bool
+}
+
+func newTrafficStore(path string) *trafficStore {
+	return &trafficStore{path: path}
+}
+
+// load reads the file once; a missing file is an empty store
+func (t *trafficStore) load() error {
+	if t.loaded {
+		return nil
+	}
+	t.users = make(map[string]*types.UserTraffic)
+	data, err := os.ReadFile(t.path)
+	if errors.Is(err, os.ErrNotExist) {
+		t.loaded = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read traffic store: %w", err)
+	}
+	var users []types.UserTraffic
+	if err := json.Unmarshal(data, &users); err != nil {
+		return fmt.Errorf("parse traffic store %s: %w", t.path, err)
+	}
+	for i := range users {
+		t.users[users[i].Email] = &users[i]
+	}
+	t.loaded = true
+	return nil
+}
+
+func (t *trafficStore) add(traffics []types.TrafficReport) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.load(); err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	for _, r := range traffics {
+		u, ok := t.users[r.Email]
+		if !ok {
+			u = &types.UserTraffic{Email: r.Email}
+			t.users[r.Email] = u
+		}
+		u.Upload += r.Upload
+		u.Download += r.Download
+		u.UpdatedAt = now
+	}
+	return t.save()
+}
+
+func (t *trafficStore) list() ([]types.UserTraffic, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+	return t.sorted(), nil
+}
+
+func (t *trafficStore) reset(email string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.load(); err != nil {
+		return err
+	}
+	if email == "" {
+		t.users = make(map[string]*types.UserTraffic)
+	} else {
+		delete(t.users, email)
+	}
+	return t.save()
+}
+
+func (t *trafficStore) sorted() []types.UserTraffic {
+	users := make([]types.UserTraffic, 0, len(t.users))
+	for _, u := range t.users {
+		users = append(users, *u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Email < users[j].Email })
+	return users
+}
+
+// save writes the store atomically so a crash never leaves half a file
+func (t *trafficStore) save() error {
+	data, err := json.MarshalIndent(t.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(t.path), 0755); err != nil {
+		return fmt.Errorf("create traffic store dir: %w", err)
+	}
+	tmp := t.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("write traffic store: %w", err)
+	}
+	if err := os.Rename(tmp, t.path); err != nil {
+		return fmt.Errorf("write traffic store: %w", err)
+	}
+	return nil
+}
+
+// TrafficHandler serves the local traffic store on the admin API:
+// GET lists it, DELETE resets it, or one user with ?email=
+func (s *Source) TrafficHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			result interface{}
+			err    error
+		)
+		switch r.Method {
+		case http.MethodGet:
+			result, err = s.Traffic()
+		case http.MethodDelete:
+			err = s.ResetTraffic(r.URL.Query().Get("email"))
+			result = map[string]bool{"ok": true}
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			result = map[string]string{"error": err.Error()}
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+}
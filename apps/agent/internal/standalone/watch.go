@@ -0,0 +1,76 @@
+package standalone
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+)
+
+// watchDebounce collects the bursts of events editors and config management
+// produce when saving a file
+const watchDebounce = 500 * time.Millisecond
+
+// Watch signals "config" or "users" when the file changes. The directories
+// are watched so files replaced by rename are picked up too.
+func (s *Source) Watch(ctx context.Context) (<-chan string, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("create watcher: %w", err)
+	}
+	files := s.files()
+	dirs := make(map[string]bool)
+	for path := range files {
+		dir := filepath.Dir(path)
+		if dirs[dir] {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("watch %s: %w", dir, err)
+		}
+		dirs[dir] = true
+	}
+
+	changes := make(chan string, 2)
+	go func() {
+		defer watcher.Close()
+		defer close(changes)
+
+		pending := make(map[string]bool)
+		timer := time.NewTimer(watchDebounce)
+		timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if kind, watched := files[filepath.Clean(event.Name)]; watched {
+					pending[kind] = true
+					timer.Reset(watchDebounce)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Warn().Err(err).Msg("Standalone file watch error")
+			case <-timer.C:
+				for kind := range pending {
+					select {
+					case changes <- kind:
+					case <-ctx.Done():
+						return
+					}
+				}
+				pending = make(map[string]bool)
+			}
+		}
+	}()
+	return changes, nil
+}
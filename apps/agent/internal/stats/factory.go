@@ -12,8 +12,8 @@ func NewCollector(coreType types.CoreType, apiAddr string) (Collector, error) {
 	case types.CoreTypeXray:
 		return NewXrayCollector(apiAddr), nil
 	case types.CoreTypeSingbox:
-		// TODO: Implement singbox collector when needed
-		return nil, fmt.Errorf("singbox collector not yet implemented")
+		// sing-box's v2ray_api serves the Xray compatible StatsService
+		return NewXrayCollector(apiAddr), nil
 	default:
 		return nil, fmt.Errorf("unsupported core type: %s", coreType)
 	}
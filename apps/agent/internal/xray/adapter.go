@@ -8,7 +8,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/rs/zerolog/log"
 	"github.com/synexim/panel-agent/pkg/types"
 )
 
@@ -60,8 +59,9 @@ type Adapter struct {
 	configPath string
 	mu         sync.RWMutex
 	
-	// Cached inbound tags for user management
+	// Cached inbound tags and protocols for user management
 	inboundTags []string
+	inbounds    map[string]types.InboundConfig
 }
 
 // NewAdapter creates a new Xray adapter
@@ -97,9 +97,8 @@ func (a *Adapter) Start(config []byte) error {
 		return fmt.Errorf("start xray: %w", err)
 	}
 
-	// Wait for gRPC API to be ready
-	time.Sleep(2 * time.Second)
-	return nil
+	// Wait for gRPC API and inbounds to be ready
+	return a.waitReady(ctx)
 }
 
 // Stop stops Xray
@@ -124,7 +123,22 @@ func (a *Adapter) Restart(config []byte) error {
 
 	// Restart process
 	ctx := context.Background()
-	return a.process.Restart(ctx)
+	if err := a.process.Restart(ctx); err != nil {
+		return err
+	}
+	return a.waitReady(ctx)
+}
+
+// waitReady waits until the API answers and the cached inbounds are listening
+func (a *Adapter) waitReady(ctx context.Context) error {
+	inbounds := make([]types.InboundConfig, 0, len(a.inbounds))
+	for _, inbound := range a.inbounds {
+		inbounds = append(inbounds, inbound)
+	}
+	if err := WaitReady(ctx, a.grpcClient, inbounds, DefaultReadyTimeout, a.process.IsRunning); err != nil {
+		return fmt.Errorf("xray not ready: %w", err)
+	}
+	return nil
 }
 
 // QueryStats queries traffic statistics
@@ -159,6 +173,7 @@ func (a *Adapter) QueryStats() (*StatsResult, error) {
 func (a *Adapter) AddUser(user UserConfig) error {
 	a.mu.RLock()
 	tags := a.inboundTags
+	inbounds := a.inbounds
 	a.mu.RUnlock()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -177,7 +192,8 @@ func (a *Adapter) AddUser(user UserConfig) error {
 
 	// Add to all inbounds
 	for _, tag := range tags {
-		if err := a.grpcClient.AddUser(ctx, tag, userConfig); err != nil {
+		inbound := inbounds[tag]
+		if err := a.grpcClient.AddUser(ctx, &inbound, userConfig); err != nil {
 			log.Warn().Err(err).Str("tag", tag).Str("email", user.Email).Msg("Failed to add user to inbound")
 		}
 	}
@@ -210,21 +226,22 @@ func (a *Adapter) IsRunning() bool {
 // extractInboundTags extracts inbound tags from config
 func (a *Adapter) extractInboundTags(config []byte) {
 	var cfg struct {
-		Inbounds []struct {
-			Tag string `json:"tag"`
-		} `json:"inbounds"`
+		Inbounds []types.InboundConfig `json:"inbounds"`
 	}
 	if err := json.Unmarshal(config, &cfg); err != nil {
 		return
 	}
 
 	tags := make([]string, 0)
+	inbounds := make(map[string]types.InboundConfig)
 	for _, ib := range cfg.Inbounds {
-		if ib.Tag != "" && ib.Tag != "api" {
+		if ib.Tag != "" && ib.Tag != APIInboundTag {
 			tags = append(tags, ib.Tag)
+			inbounds[ib.Tag] = ib
 		}
 	}
 	a.inboundTags = tags
+	a.inbounds = inbounds
 }
 
 // GetGRPCClient returns the gRPC client for advanced operations
@@ -0,0 +1,109 @@
+package xray
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// Reserved tags of the generated API inbound, outbound and routing rule
+const (
+	APIInboundTag  = "api-inbound"
+	APIOutboundTag = "api"
+)
+
+// APIEndpoint is where a core serves its gRPC API: a TCP address or a unix socket
+type APIEndpoint struct {
+	Network string // tcp or unix
+	Listen  string // IP for tcp, socket path for unix ("@name" for abstract sockets)
+	Port    int
+}
+
+// ParseAPIEndpoint parses "host:port", "unix:/path", "unix:///path", "/path" or "@name"
+func ParseAPIEndpoint(addr string) (APIEndpoint, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		path = strings.TrimPrefix(path, "//")
+		if path == "" {
+			return APIEndpoint{}, fmt.Errorf("invalid api address %q: empty socket path", addr)
+		}
+		return APIEndpoint{Network: "unix", Listen: path}, nil
+	}
+	if strings.HasPrefix(addr, "/") || strings.HasPrefix(addr, "@") {
+		return APIEndpoint{Network: "unix", Listen: addr}, nil
+	}
+
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return APIEndpoint{}, fmt.Errorf("invalid api address %q: %w", addr, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return APIEndpoint{}, fmt.Errorf("invalid api port %q", portStr)
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return APIEndpoint{Network: "tcp", Listen: host, Port: port}, nil
+}
+
+// Target returns the gRPC dial target of the endpoint
+func (e APIEndpoint) Target() string {
+	if e.Network == "unix" {
+		if name, ok := strings.CutPrefix(e.Listen, "@"); ok {
+			return "unix-abstract:" + name
+		}
+		return "unix://" + e.Listen
+	}
+	return net.JoinHostPort(e.Listen, strconv.Itoa(e.Port))
+}
+
+// String returns the endpoint in config notation
+func (e APIEndpoint) String() string {
+	if e.Network == "unix" {
+		return "unix:" + e.Listen
+	}
+	return e.Target()
+}
+
+// inbound renders the dokodemo-door inbound serving the API
+func (e APIEndpoint) inbound() map[string]interface{} {
+	inbound := map[string]interface{}{
+		"tag":      APIInboundTag,
+		"listen":   e.Listen,
+		"protocol": "dokodemo-door",
+		"settings": map[string]interface{}{
+			"address": "127.0.0.1",
+		},
+	}
+	if e.Network == "tcp" {
+		inbound["port"] = e.Port
+	}
+	return inbound
+}
+
+// conflictsWith reports whether an inbound would share the endpoint's listener
+func (e APIEndpoint) conflictsWith(inbound *types.InboundConfig) bool {
+	if e.Network == "unix" {
+		return inbound.Listen == e.Listen
+	}
+	if inbound.Port != e.Port {
+		return false
+	}
+	return isWildcardListen(inbound.Listen) || isWildcardListen(e.Listen) || inbound.Listen == e.Listen
+}
+
+// isWildcardListen reports whether a listen address binds every interface
+func isWildcardListen(listen string) bool {
+	return listen == "" || listen == "0.0.0.0" || listen == "::"
+}
+
+// dialTarget converts a configured API address into a gRPC dial target
+func dialTarget(addr string) string {
+	if endpoint, err := ParseAPIEndpoint(addr); err == nil {
+		return endpoint.Target()
+	}
+	return addr
+}
@@ -5,6 +5,8 @@ import (
 	"regexp"
 	"strings"
 
+	xcore "github.com/xtls/xray-core/core"
+
 	"github.com/synexim/panel-agent/pkg/types"
 )
 
@@ -21,6 +23,19 @@ func DetectCapabilities(binaryPath string) *types.CoreCapabilities {
 	}
 }
 
+// DetectEmbeddedCapabilities reports the capabilities of the linked xray-core
+func DetectEmbeddedCapabilities() *types.CoreCapabilities {
+	version := xcore.Version()
+
+	return &types.CoreCapabilities{
+		CoreType:   "xray",
+		Version:    version,
+		Protocols:  getXrayProtocols(),
+		Transports: getXrayTransports(),
+		Features:   append(getXrayFeatures(version), "embedded"),
+	}
+}
+
 // detectVersion runs xray version command and parses output
 func detectVersion(binaryPath string) string {
 	cmd := exec.Command(binaryPath, "version")
@@ -92,6 +107,9 @@ func getXrayFeatures(version string) []string {
 	if compareVersion(version, "1.4.0") >= 0 {
 		features = append(features, "xtls-vision")
 	}
+	if compareVersion(version, "1.6.1") >= 0 {
+		features = append(features, "shadowsocks-2022")
+	}
 	if compareVersion(version, "1.8.0") >= 0 {
 		features = append(features, "reality")
 	}
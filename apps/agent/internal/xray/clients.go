@@ -4,12 +4,11 @@ import (
 	"encoding/json"
 	"os"
 
-	"github.com/rs/zerolog/log"
 	"github.com/synexim/panel-agent/pkg/types"
 )
 
 // buildInboundsWithClients injects clients into inbound configurations
-func (g *ConfigGenerator) buildInboundsWithClients(inbounds []types.InboundConfig, users []types.UserConfig) []interface{} {
+func (g *ConfigGenerator) buildInboundsWithClients(inbounds []types.InboundConfig, users []types.UserConfig) ([]interface{}, error) {
 	// Group users by inbound tag
 	usersByInbound := make(map[string][]types.UserConfig)
 	for _, user := range users {
@@ -21,7 +20,8 @@ func (g *ConfigGenerator) buildInboundsWithClients(inbounds []types.InboundConfi
 	log.Debug().Int("totalUsers", len(users)).Int("totalInbounds", len(inbounds)).Msg("Building inbounds with clients")
 
 	result := make([]interface{}, 0, len(inbounds))
-	for _, inbound := range inbounds {
+	for i := range inbounds {
+		inbound := &inbounds[i]
 		inboundUsers := usersByInbound[inbound.Tag]
 		
 		log.Debug().Str("tag", inbound.Tag).Str("protocol", inbound.Protocol).Int("users", len(inboundUsers)).Msg("Processing inbound")
@@ -32,16 +32,22 @@ func (g *ConfigGenerator) buildInboundsWithClients(inbounds []types.InboundConfi
 			settings = make(map[string]interface{})
 		}
 
-		// Socks5 uses "accounts" instead of "clients"
-		if inbound.Protocol == "socks" {
-			accounts := g.buildSocksAccounts(inboundUsers)
-			settings["accounts"] = accounts
-			// Also remove clients array for socks (not needed)
-			delete(settings, "clients")
-			log.Debug().Int("accountsCount", len(accounts)).Msg("Built socks accounts")
-		} else {
-			clients := g.buildClients(inbound.Protocol, inboundUsers)
-			settings["clients"] = clients
+		// Inject users under the key the protocol expects ("clients" or "accounts")
+		if proto, ok := LookupProtocol(inbound.Protocol); ok {
+			if v, ok := proto.(SettingsValidator); ok {
+				// One bad inbound must not take the others down with it
+				if err := v.ValidateSettings(inbound, inboundUsers); err != nil {
+					log.Warn().Err(err).Str("inbound", inbound.Tag).Msg("Skipping invalid inbound")
+					continue
+				}
+			}
+			key := proto.SettingsKey()
+			clients := g.buildClients(proto, inbound, inboundUsers)
+			settings[key] = clients
+			if key != "clients" {
+				delete(settings, "clients")
+			}
+			log.Debug().Str("protocol", inbound.Protocol).Str("key", key).Int("count", len(clients)).Msg("Built inbound users")
 		}
 
 		inboundConfig := map[string]interface{}{
@@ -65,55 +71,20 @@ func (g *ConfigGenerator) buildInboundsWithClients(inbounds []types.InboundConfi
 		result = append(result, inboundConfig)
 	}
 
-	return result
-}
-
-// buildSocksAccounts builds accounts list for Socks5 protocol
-func (g *ConfigGenerator) buildSocksAccounts(users []types.UserConfig) []map[string]interface{} {
-	accounts := make([]map[string]interface{}, 0, len(users))
-	for _, user := range users {
-		account := map[string]interface{}{
-			"user": user.Email,
-			"pass": user.Password,
-		}
-		accounts = append(accounts, account)
-	}
-	return accounts
+	return result, nil
 }
 
-// buildClients builds client list for specific protocol
-func (g *ConfigGenerator) buildClients(protocol string, users []types.UserConfig) []map[string]interface{} {
+// buildClients builds the user list for an inbound, skipping users the protocol rejects
+func (g *ConfigGenerator) buildClients(proto Protocol, inbound *types.InboundConfig, users []types.UserConfig) []map[string]interface{} {
 	clients := make([]map[string]interface{}, 0, len(users))
-
-	for _, user := range users {
-		client := make(map[string]interface{})
-		client["email"] = user.Email
-		client["level"] = user.Level
-
-		switch protocol {
-		case "vless":
-			client["id"] = user.UUID
-			if user.Flow != "" {
-				client["flow"] = user.Flow
-			}
-		case "vmess":
-			client["id"] = user.UUID
-			client["alterId"] = user.AlterID
-			if user.Security != "" {
-				client["security"] = user.Security
-			}
-		case "trojan":
-			client["password"] = user.Password
-		case "shadowsocks":
-			client["password"] = user.Password
-			if user.Method != "" {
-				client["method"] = user.Method
-			}
+	for i := range users {
+		client, err := proto.ConfigClient(inbound, &users[i])
+		if err != nil {
+			log.Warn().Err(err).Str("inbound", inbound.Tag).Str("email", users[i].Email).Msg("Skipping invalid user")
+			continue
 		}
-
 		clients = append(clients, client)
 	}
-
 	return clients
 }
 
@@ -144,3 +115,44 @@ func (g *ConfigGenerator) WriteConfig(config *XrayConfig) error {
 	}
 	return os.WriteFile(g.configPath, data, 0644)
 }
+
+// InboundsByTag maps every inbound tag, including expanded template instances, to its inbound
+func InboundsByTag(nodeConfig *types.NodeConfig) map[string]types.InboundConfig {
+	inbounds := make(map[string]types.InboundConfig)
+	for _, inbound := range AllInbounds(nodeConfig) {
+		inbounds[inbound.Tag] = inbound
+	}
+	return inbounds
+}
+
+// AllInbounds lists the inbounds a node config renders, plain inbounds first and
+// then expanded template instances, leaving out excluded inbounds
+func AllInbounds(nodeConfig *types.NodeConfig) []types.InboundConfig {
+	if nodeConfig == nil {
+		return nil
+	}
+	excluded := excludedInbounds(nodeConfig)
+	var inbounds []types.InboundConfig
+	for _, inbound := range nodeConfig.Inbounds {
+		if !excluded[inbound.Tag] {
+			inbounds = append(inbounds, inbound)
+		}
+	}
+	templateInbounds, _, _, err := ExpandInboundTemplates(nodeConfig.InboundTemplates, excluded, nil)
+	if err != nil {
+		return inbounds
+	}
+	return append(inbounds, templateInbounds...)
+}
+
+// excludedInbounds returns the set of inbound tags left out of the rendered config
+func excludedInbounds(nodeConfig *types.NodeConfig) map[string]bool {
+	if nodeConfig == nil || len(nodeConfig.ExcludedInbounds) == 0 {
+		return nil
+	}
+	excluded := make(map[string]bool, len(nodeConfig.ExcludedInbounds))
+	for _, tag := range nodeConfig.ExcludedInbounds {
+		excluded[tag] = true
+	}
+	return excluded
+}
@@ -0,0 +1,47 @@
+package xray
+
+import (
+	"context"
+
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// CoreProcess controls the lifecycle of a proxy core, either an external
+// process (ProcessManager) or an in-process instance (EmbeddedCore)
+type CoreProcess interface {
+	Start(ctx context.Context) error
+	Stop() error
+	Restart(ctx context.Context) error
+	IsRunning() bool
+	GetVersion() string
+}
+
+// CoreAPI is the stats and user management API of a running core,
+// reached over gRPC (GRPCClient) or directly in-process (EmbeddedCore)
+type CoreAPI interface {
+	Ping(ctx context.Context) error
+	QueryTrafficStats(ctx context.Context, reset bool) ([]types.TrafficReport, error)
+	GetUserOnlineCount(ctx context.Context, email string) (int64, error)
+	GetAllOnlineUsers(ctx context.Context, emails []string) ([]types.AliveUser, error)
+	AddUser(ctx context.Context, inbound *types.InboundConfig, user *types.UserConfig) error
+	RemoveUser(ctx context.Context, inboundTag string, email string) error
+	KickUser(ctx context.Context, email string, inboundTags []string) error
+	SetUserRateLimit(ctx context.Context, email string, uplinkBytesPerSec, downlinkBytesPerSec int64) error
+	RemoveUserRateLimit(ctx context.Context, email string) error
+}
+
+// TagTrafficQuerier is implemented by cores that count traffic per inbound
+// and outbound tag. Counters are read without reset and restart with the core.
+type TagTrafficQuerier interface {
+	QueryTagTraffic(ctx context.Context) (*StatsResult, error)
+}
+
+var (
+	_ CoreProcess = (*ProcessManager)(nil)
+	_ CoreAPI     = (*GRPCClient)(nil)
+	_ CoreProcess = (*EmbeddedCore)(nil)
+	_ CoreAPI     = (*EmbeddedCore)(nil)
+
+	_ TagTrafficQuerier = (*GRPCClient)(nil)
+	_ TagTrafficQuerier = (*EmbeddedCore)(nil)
+)
@@ -0,0 +1,141 @@
+package xray
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// EgressOutboundPrefix is the tag prefix of generated per-IP egress outbounds
+const EgressOutboundPrefix = "egress-"
+
+// EgressOutboundTag returns the outbound tag used for an egress IP
+func EgressOutboundTag(ip string) string {
+	return EgressOutboundPrefix + strings.ReplaceAll(ip, ":", "_")
+}
+
+// LocalIPs returns the set of IP addresses assigned to local interfaces
+func LocalIPs() map[string]bool {
+	ips := make(map[string]bool)
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ips
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			ips[ipnet.IP.String()] = true
+		}
+	}
+	return ips
+}
+
+// ResolveEgressBindings groups users by their assigned egress IP.
+// Bindings to IPs that are invalid or not present on a local interface
+// are returned as issues instead of being bound.
+func ResolveEgressBindings(users []types.UserConfig, localIPs map[string]bool) (map[string][]string, []types.EgressBindingIssue) {
+	bindings := make(map[string][]string) // ip -> emails
+	var issues []types.EgressBindingIssue
+
+	for _, user := range users {
+		if user.EgressIP == "" {
+			continue
+		}
+		normalized, reason := checkEgressIP(user.EgressIP, localIPs)
+		if reason != "" {
+			issues = append(issues, types.EgressBindingIssue{
+				Email:    user.Email,
+				EgressIP: user.EgressIP,
+				Reason:   reason,
+			})
+			continue
+		}
+		bindings[normalized] = append(bindings[normalized], user.Email)
+	}
+
+	return bindings, issues
+}
+
+// checkEgressIP returns an egress IP in normalized form, or the reason it
+// cannot be bound
+func checkEgressIP(raw string, localIPs map[string]bool) (string, string) {
+	ip := net.ParseIP(raw)
+	if ip == nil {
+		return "", "invalid ip address"
+	}
+	if !localIPs[ip.String()] {
+		return "", "ip not present on any local interface"
+	}
+	return ip.String(), ""
+}
+
+// buildEgressRouting builds one freedom outbound with sendThrough per bound
+// egress IP and a user routing rule pointing at it. Output is sorted by IP so
+// the rendered config is stable across syncs.
+func buildEgressRouting(bindings map[string][]string) ([]types.OutboundConfig, []interface{}) {
+	ips := make([]string, 0, len(bindings))
+	for ip := range bindings {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+
+	outbounds := make([]types.OutboundConfig, 0, len(ips))
+	rules := make([]interface{}, 0, len(ips))
+	for _, ip := range ips {
+		tag := EgressOutboundTag(ip)
+		outbounds = append(outbounds, types.OutboundConfig{
+			Tag:         tag,
+			Protocol:    "freedom",
+			SendThrough: ip,
+			Settings:    map[string]interface{}{},
+		})
+		rules = append(rules, map[string]interface{}{
+			"type":        "field",
+			"user":        bindings[ip],
+			"outboundTag": tag,
+		})
+	}
+	return outbounds, rules
+}
+
+// SplitBlockRules splits routing rules before the first one that does not
+// send traffic to a blackhole outbound. Generated egress rules go between the
+// two parts: block lists still apply to bound users, while a catch-all rule
+// of Panel does not shadow them.
+func SplitBlockRules(rules []interface{}, outbounds []types.OutboundConfig) ([]interface{}, []interface{}) {
+	blackholes := make(map[string]bool)
+	for _, ob := range outbounds {
+		if ob.Protocol == "blackhole" {
+			blackholes[ob.Tag] = true
+		}
+	}
+	for i, rule := range rules {
+		fields, _ := rule.(map[string]interface{})
+		if tag, _ := fields["outboundTag"].(string); !blackholes[tag] {
+			return rules[:i], rules[i:]
+		}
+	}
+	return rules, nil
+}
+
+// EgressBindingsChanged reports whether any user's egress IP differs between two user lists
+func EgressBindingsChanged(oldUsers, newUsers []types.UserConfig) bool {
+	oldMap := make(map[string]string)
+	for _, u := range oldUsers {
+		if u.EgressIP != "" {
+			oldMap[u.Email] = u.EgressIP
+		}
+	}
+	newCount := 0
+	for _, u := range newUsers {
+		if u.EgressIP == "" {
+			continue
+		}
+		newCount++
+		if oldMap[u.Email] != u.EgressIP {
+			return true
+		}
+	}
+	return newCount != len(oldMap)
+}
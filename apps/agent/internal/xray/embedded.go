@@ -0,0 +1,350 @@
+package xray
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	xcore "github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/features/inbound"
+	"github.com/xtls/xray-core/features/stats"
+	"github.com/xtls/xray-core/infra/conf/serial"
+	"github.com/xtls/xray-core/proxy"
+
+	// Register all Xray features, proxies and transports
+	_ "github.com/xtls/xray-core/main/distro/all"
+
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// ErrRateLimitUnsupported is returned by the embedded core for per-user rate limits,
+// which are only exposed by the patched core's HandlerService
+var ErrRateLimitUnsupported = errors.New("per-user rate limits are not supported by the embedded core")
+
+// EmbeddedCore runs Xray in-process from the generated config and manages
+// users and stats through the core's feature managers, without gRPC
+type EmbeddedCore struct {
+	configPath string
+	assetPath  string
+
+	instance *xcore.Instance
+	mu       sync.RWMutex
+}
+
+// NewEmbeddedCore creates a new in-process Xray core
+func NewEmbeddedCore(configPath, assetPath string) *EmbeddedCore {
+	return &EmbeddedCore{
+		configPath: configPath,
+		assetPath:  assetPath,
+	}
+}
+
+// Start loads the config file and starts the Xray instance.
+// Inbounds are listening once Start returns, no readiness wait is needed.
+func (e *EmbeddedCore) Start(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.instance != nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(e.configPath)
+	if err != nil {
+		return fmt.Errorf("read xray config: %w", err)
+	}
+
+	// Geo assets are resolved while the config is built
+	os.Setenv("XRAY_LOCATION_ASSET", e.assetPath)
+	config, err := serial.LoadJSONConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("load xray config: %w", err)
+	}
+
+	instance, err := xcore.New(config)
+	if err != nil {
+		return fmt.Errorf("create xray instance: %w", err)
+	}
+	if err := instance.Start(); err != nil {
+		instance.Close()
+		return fmt.Errorf("start xray instance: %w", err)
+	}
+
+	e.instance = instance
+	log.Info().Str("core", "xray").Str("version", xcore.Version()).Msg("Embedded core started")
+	return nil
+}
+
+// Stop closes the Xray instance and releases its listeners
+func (e *EmbeddedCore) Stop() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.instance == nil {
+		return nil
+	}
+
+	log.Info().Str("core", "xray").Msg("Stopping embedded core")
+	err := e.instance.Close()
+	e.instance = nil
+	if err != nil {
+		return fmt.Errorf("close xray instance: %w", err)
+	}
+	return nil
+}
+
+// Restart restarts the instance with the current config file
+func (e *EmbeddedCore) Restart(ctx context.Context) error {
+	if err := e.Stop(); err != nil {
+		return err
+	}
+	return e.Start(ctx)
+}
+
+// IsRunning returns whether the instance is running
+func (e *EmbeddedCore) IsRunning() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.instance != nil && e.instance.IsRunning()
+}
+
+// GetVersion returns the linked xray-core version
+func (e *EmbeddedCore) GetVersion() string {
+	return xcore.Version()
+}
+
+// Ping checks that the instance is running with a stats manager
+func (e *EmbeddedCore) Ping(ctx context.Context) error {
+	_, err := e.statsManager()
+	return err
+}
+
+// ListInboundTags returns the tags of the running inbounds
+func (e *EmbeddedCore) ListInboundTags(ctx context.Context) ([]string, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.instance == nil {
+		return nil, errors.New("embedded core not running")
+	}
+	ihm, ok := e.instance.GetFeature(inbound.ManagerType()).(inbound.Manager)
+	if !ok {
+		return nil, errors.New("inbound manager not available")
+	}
+	handlers := ihm.ListHandlers(ctx)
+	tags := make([]string, 0, len(handlers))
+	for _, handler := range handlers {
+		tags = append(tags, handler.Tag())
+	}
+	return tags, nil
+}
+
+// ========================================
+// Stats - Traffic & Online Users
+// ========================================
+
+// statsManager returns the running instance's stats manager
+func (e *EmbeddedCore) statsManager() (stats.Manager, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.instance == nil {
+		return nil, errors.New("embedded core not running")
+	}
+	sm, ok := e.instance.GetFeature(stats.ManagerType()).(stats.Manager)
+	if !ok {
+		return nil, errors.New("stats manager not available")
+	}
+	return sm, nil
+}
+
+// QueryTrafficStats reads user traffic counters (with reset option to clear after read)
+func (e *EmbeddedCore) QueryTrafficStats(ctx context.Context, reset bool) ([]types.TrafficReport, error) {
+	sm, err := e.statsManager()
+	if err != nil {
+		return nil, err
+	}
+	visitor, ok := sm.(interface {
+		VisitCounters(func(string, stats.Counter) bool)
+	})
+	if !ok {
+		return nil, errors.New("stats manager cannot enumerate counters")
+	}
+
+	trafficMap := make(map[string]*types.TrafficReport)
+	visitor.VisitCounters(func(name string, counter stats.Counter) bool {
+		// Only user counters are reset, tag counters stay cumulative for QueryTagTraffic
+		if !strings.HasPrefix(name, "user>>>") {
+			return true
+		}
+		value := counter.Value()
+		if reset {
+			value = counter.Set(0)
+		}
+		addUserTraffic(trafficMap, name, value)
+		return true
+	})
+	return trafficReports(trafficMap), nil
+}
+
+// QueryTagTraffic reads the cumulative traffic of every inbound and outbound tag
+func (e *EmbeddedCore) QueryTagTraffic(ctx context.Context) (*StatsResult, error) {
+	sm, err := e.statsManager()
+	if err != nil {
+		return nil, err
+	}
+	visitor, ok := sm.(interface {
+		VisitCounters(func(string, stats.Counter) bool)
+	})
+	if !ok {
+		return nil, errors.New("stats manager cannot enumerate counters")
+	}
+
+	tags := newTagTraffic()
+	visitor.VisitCounters(func(name string, counter stats.Counter) bool {
+		tags.add(name, counter.Value())
+		return true
+	})
+	return tags.result(), nil
+}
+
+// GetUserOnlineCount gets the online session count for a user
+func (e *EmbeddedCore) GetUserOnlineCount(ctx context.Context, email string) (int64, error) {
+	sm, err := e.statsManager()
+	if err != nil {
+		return 0, err
+	}
+	online := sm.GetOnlineMap(fmt.Sprintf("user>>>%s>>>online", email))
+	if online == nil {
+		return 0, nil
+	}
+	return int64(online.Count()), nil
+}
+
+// GetUserOnlineIPs gets the online IP addresses for a user
+func (e *EmbeddedCore) GetUserOnlineIPs(ctx context.Context, email string) (*OnlineIPInfo, error) {
+	sm, err := e.statsManager()
+	if err != nil {
+		return nil, err
+	}
+	info := &OnlineIPInfo{
+		Email: email,
+		IPs:   make(map[string]int64),
+	}
+	online := sm.GetOnlineMap(fmt.Sprintf("user>>>%s>>>online", email))
+	if online == nil {
+		return info, nil
+	}
+	for ip, lastSeen := range online.IpTimeMap() {
+		info.IPs[ip] = lastSeen.Unix()
+	}
+	return info, nil
+}
+
+// GetAllOnlineUsers queries all online users and their IPs
+func (e *EmbeddedCore) GetAllOnlineUsers(ctx context.Context, emails []string) ([]types.AliveUser, error) {
+	var aliveUsers []types.AliveUser
+
+	for _, email := range emails {
+		info, err := e.GetUserOnlineIPs(ctx, email)
+		if err != nil {
+			continue // Skip users with errors
+		}
+		for ip := range info.IPs {
+			aliveUsers = append(aliveUsers, types.AliveUser{
+				Email: email,
+				IP:    ip,
+			})
+		}
+	}
+	return aliveUsers, nil
+}
+
+// ========================================
+// Handler - User Management
+// ========================================
+
+// userManager returns the user manager of an inbound
+func (e *EmbeddedCore) userManager(ctx context.Context, inboundTag string) (proxy.UserManager, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.instance == nil {
+		return nil, errors.New("embedded core not running")
+	}
+	ihm, ok := e.instance.GetFeature(inbound.ManagerType()).(inbound.Manager)
+	if !ok {
+		return nil, errors.New("inbound manager not available")
+	}
+	handler, err := ihm.GetHandler(ctx, inboundTag)
+	if err != nil {
+		return nil, fmt.Errorf("get inbound %s: %w", inboundTag, err)
+	}
+	gi, ok := handler.(proxy.GetInbound)
+	if !ok {
+		return nil, fmt.Errorf("inbound %s has no proxy", inboundTag)
+	}
+	um, ok := gi.GetInbound().(proxy.UserManager)
+	if !ok {
+		return nil, fmt.Errorf("inbound %s does not manage users", inboundTag)
+	}
+	return um, nil
+}
+
+// AddUser adds a user to an inbound (hot reload, no restart needed)
+func (e *EmbeddedCore) AddUser(ctx context.Context, inbound *types.InboundConfig, user *types.UserConfig) error {
+	um, err := e.userManager(ctx, inbound.Tag)
+	if err != nil {
+		return err
+	}
+	protoUser, err := buildProtocolUser(inbound, user)
+	if err != nil {
+		return fmt.Errorf("build protocol user: %w", err)
+	}
+	memoryUser, err := protoUser.ToMemoryUser()
+	if err != nil {
+		return fmt.Errorf("build memory user: %w", err)
+	}
+	if err := um.AddUser(ctx, memoryUser); err != nil {
+		return fmt.Errorf("add user: %w", err)
+	}
+	log.Debug().Str("email", user.Email).Str("inbound", inbound.Tag).Msg("User added to inbound")
+	return nil
+}
+
+// RemoveUser removes a user from an inbound
+func (e *EmbeddedCore) RemoveUser(ctx context.Context, inboundTag string, email string) error {
+	um, err := e.userManager(ctx, inboundTag)
+	if err != nil {
+		return err
+	}
+	if err := um.RemoveUser(ctx, email); err != nil {
+		return fmt.Errorf("remove user: %w", err)
+	}
+	log.Debug().Str("email", email).Str("inbound", inboundTag).Msg("User removed from inbound")
+	return nil
+}
+
+// KickUser removes a user from all specified inbounds
+func (e *EmbeddedCore) KickUser(ctx context.Context, email string, inboundTags []string) error {
+	for _, tag := range inboundTags {
+		if err := e.RemoveUser(ctx, tag, email); err != nil {
+			log.Debug().Err(err).Str("email", email).Str("inbound", tag).Msg("Failed to remove user (may not exist)")
+		}
+	}
+	return nil
+}
+
+// SetUserRateLimit is not supported in-process
+func (e *EmbeddedCore) SetUserRateLimit(ctx context.Context, email string, uplinkBytesPerSec, downlinkBytesPerSec int64) error {
+	return ErrRateLimitUnsupported
+}
+
+// RemoveUserRateLimit is not supported in-process
+func (e *EmbeddedCore) RemoveUserRateLimit(ctx context.Context, email string) error {
+	return ErrRateLimitUnsupported
+}
@@ -1,29 +1,47 @@
 package xray
 
 import (
+	"fmt"
+
 	"github.com/synexim/panel-agent/pkg/types"
 )
 
 // ConfigGenerator generates Xray configuration
 type ConfigGenerator struct {
 	configPath string
+	apiAddress string
+	apiEnabled bool
+	reserved   []string // other API addresses panel inbounds must not use
+}
+
+// NewConfigGenerator creates a new config generator whose API inbound listens on
+// apiAddress ("host:port" or a unix socket, see ParseAPIEndpoint)
+func NewConfigGenerator(configPath, apiAddress string) *ConfigGenerator {
+	return &ConfigGenerator{configPath: configPath, apiAddress: apiAddress, apiEnabled: true}
 }
 
-// NewConfigGenerator creates a new config generator
-func NewConfigGenerator(configPath string) *ConfigGenerator {
-	return &ConfigGenerator{configPath: configPath}
+// ReserveAPIAddress keeps panel inbounds off another API endpoint on this host,
+// e.g. the handover standby address or the sing-box API
+func (g *ConfigGenerator) ReserveAPIAddress(addr string) {
+	g.reserved = append(g.reserved, addr)
+}
+
+// DisableAPI omits the loopback API inbound, used by the embedded core
+// which talks to the stats and handler managers directly
+func (g *ConfigGenerator) DisableAPI() {
+	g.apiEnabled = false
 }
 
 // XrayConfig represents the full Xray configuration
 type XrayConfig struct {
-	Log       *LogConfig           `json:"log,omitempty"`
-	API       *APIConfig           `json:"api,omitempty"`
-	Stats     *StatsConfig         `json:"stats,omitempty"`
-	Policy    interface{}          `json:"policy,omitempty"`
-	DNS       interface{}          `json:"dns,omitempty"`
-	Inbounds  []interface{}        `json:"inbounds"`
+	Log       *LogConfig             `json:"log,omitempty"`
+	API       *APIConfig             `json:"api,omitempty"`
+	Stats     *StatsConfig           `json:"stats,omitempty"`
+	Policy    interface{}            `json:"policy,omitempty"`
+	DNS       interface{}            `json:"dns,omitempty"`
+	Inbounds  []interface{}          `json:"inbounds"`
 	Outbounds []types.OutboundConfig `json:"outbounds"`
-	Routing   *types.RoutingConfig `json:"routing,omitempty"`
+	Routing   *types.RoutingConfig   `json:"routing,omitempty"`
 }
 
 // LogConfig represents Xray log configuration
@@ -65,57 +83,104 @@ func (g *ConfigGenerator) Generate(nodeConfig *types.NodeConfig, users []types.U
 	}
 
 	config := &XrayConfig{
-		Log: &LogConfig{Loglevel: "warning"},
-		API: &APIConfig{
-			Tag:      "api",
-			Services: []string{"HandlerService", "StatsService"},
-		},
-		Stats:     &StatsConfig{},
-		Policy:    policy,
-		DNS:       nodeConfig.DNS,
-		Outbounds: nodeConfig.Outbounds,
-		Routing:   nodeConfig.Routing,
-	}
-
-	// Build inbounds with injected clients
-	inbounds := g.buildInboundsWithClients(nodeConfig.Inbounds, users)
-	
-	// Add API inbound for stats
-	apiInbound := map[string]interface{}{
-		"tag":      "api-inbound",
-		"listen":   "127.0.0.1",
-		"port":     10085,
-		"protocol": "dokodemo-door",
-		"settings": map[string]interface{}{
-			"address": "127.0.0.1",
-		},
-	}
-	config.Inbounds = append([]interface{}{apiInbound}, inbounds...)
-
-	// Add API routing rule
+		Log:    &LogConfig{Loglevel: "warning"},
+		Stats:  &StatsConfig{},
+		Policy: policy,
+		DNS:    nodeConfig.DNS,
+		// Copied: generated outbounds and rules are appended below and must
+		// not end up in the node config kept for the next generate
+		Outbounds: append([]types.OutboundConfig(nil), nodeConfig.Outbounds...),
+	}
+	if nodeConfig.Routing != nil {
+		routing := *nodeConfig.Routing
+		routing.Rules = append([]interface{}(nil), routing.Rules...)
+		config.Routing = &routing
+	}
+
+	// Expand inbound templates into concrete inbounds and their egress routing
+	excluded := excludedInbounds(nodeConfig)
+	localIPs := LocalIPs()
+	templateInbounds, templateOutbounds, templateRules, err := ExpandInboundTemplates(nodeConfig.InboundTemplates, excluded, localIPs)
+	if err != nil {
+		return nil, err
+	}
+	users = ExpandUserInboundTags(users, TemplateInboundTags(nodeConfig))
+
+	// Build inbounds with injected clients, leaving out excluded inbounds
+	var allInbounds []types.InboundConfig
+	for _, inbound := range nodeConfig.Inbounds {
+		if !excluded[inbound.Tag] {
+			allInbounds = append(allInbounds, inbound)
+		}
+	}
+	allInbounds = append(allInbounds, templateInbounds...)
+	if err := g.checkReservedAPI(allInbounds, nodeConfig.Outbounds); err != nil {
+		return nil, err
+	}
+	inbounds, err := g.buildInboundsWithClients(allInbounds, users)
+	if err != nil {
+		return nil, err
+	}
+
+	config.Inbounds = inbounds
+
 	if config.Routing == nil {
 		config.Routing = &types.RoutingConfig{DomainStrategy: "AsIs"}
 	}
-	apiRule := map[string]interface{}{
-		"type":        "field",
-		"inboundTag":  []string{"api-inbound"},
-		"outboundTag": "api",
-	}
-	
-	// Filter out invalid routing rules (must have outboundTag or balancerTag)
-	validRules := []interface{}{apiRule}
-	for _, rule := range config.Routing.Rules {
-		if ruleMap, ok := rule.(map[string]interface{}); ok {
-			if _, hasOutbound := ruleMap["outboundTag"]; hasOutbound {
-				validRules = append(validRules, rule)
-			} else if _, hasBalancer := ruleMap["balancerTag"]; hasBalancer {
-				validRules = append(validRules, rule)
-			}
-			// Skip rules without outboundTag or balancerTag
+	validRules := []interface{}{}
+
+	if g.apiEnabled {
+		endpoint, err := ParseAPIEndpoint(g.apiAddress)
+		if err != nil {
+			return nil, err
+		}
+		config.API = &APIConfig{
+			Tag:      APIOutboundTag,
+			Services: []string{"HandlerService", "StatsService"},
+		}
+
+		// Add API inbound for stats
+		config.Inbounds = append([]interface{}{endpoint.inbound()}, inbounds...)
+
+		// Add API routing rule
+		validRules = append(validRules, map[string]interface{}{
+			"type":        "field",
+			"inboundTag":  []string{APIInboundTag},
+			"outboundTag": APIOutboundTag,
+		})
+	}
+
+	// Drop rules Xray would refuse (must have outboundTag or balancerTag).
+	// ValidateNodeConfig reports them to Panel as missing_target.
+	var panelRules []interface{}
+	for i, rule := range config.Routing.Rules {
+		ruleMap, ok := rule.(map[string]interface{})
+		if !ok {
+			log.Warn().Int("rule", i).Msg("Dropping routing rule that is not an object")
+			continue
 		}
+		if problem := ruleTargetProblem(ruleMap); problem != "" {
+			log.Warn().Int("rule", i).Msg("Dropping routing rule: " + problem)
+			continue
+		}
+		panelRules = append(panelRules, rule)
 	}
+
+	// Route users with a dedicated egress IP to a generated sendThrough
+	// outbound, and template instances to theirs, after the leading block
+	// rules of Panel and before the others
+	bindings, _ := ResolveEgressBindings(users, localIPs)
+	egressOutbounds, egressRules := buildEgressRouting(bindings)
+	blockRules, otherRules := SplitBlockRules(panelRules, config.Outbounds)
+	validRules = append(validRules, blockRules...)
+	validRules = append(validRules, egressRules...)
+	validRules = append(validRules, templateRules...)
+	validRules = append(validRules, otherRules...)
+	config.Outbounds = append(config.Outbounds, egressOutbounds...)
+	config.Outbounds = append(config.Outbounds, templateOutbounds...)
+
 	config.Routing.Rules = validRules
-	
+
 	// Ensure there's a default outbound (direct) if not present
 	hasDirectOutbound := false
 	for _, ob := range config.Outbounds {
@@ -134,16 +199,16 @@ func (g *ConfigGenerator) Generate(nodeConfig *types.NodeConfig, users []types.U
 
 	// Add API outbound
 	apiOutbound := types.OutboundConfig{
-		Tag:      "api",
+		Tag:      APIOutboundTag,
 		Protocol: "blackhole",
 		Settings: map[string]interface{}{},
 	}
-	
+
 	// Deduplicate outbounds by tag (keep first occurrence)
 	// IMPORTANT: 'direct' must be the FIRST outbound (Xray uses first outbound as default)
 	// 'api' outbound is only for API inbound routing, not for default traffic
 	seenTags := map[string]bool{}
-	
+
 	// First, find and add 'direct' outbound as the first one
 	var directOutbound *types.OutboundConfig
 	for i := range config.Outbounds {
@@ -152,7 +217,7 @@ func (g *ConfigGenerator) Generate(nodeConfig *types.NodeConfig, users []types.U
 			break
 		}
 	}
-	
+
 	var dedupedOutbounds []types.OutboundConfig
 	if directOutbound != nil {
 		dedupedOutbounds = append(dedupedOutbounds, *directOutbound)
@@ -166,11 +231,13 @@ func (g *ConfigGenerator) Generate(nodeConfig *types.NodeConfig, users []types.U
 		})
 		seenTags["direct"] = true
 	}
-	
+
 	// Add api outbound second (for API routing)
-	dedupedOutbounds = append(dedupedOutbounds, apiOutbound)
-	seenTags["api"] = true
-	
+	if g.apiEnabled {
+		dedupedOutbounds = append(dedupedOutbounds, apiOutbound)
+		seenTags[APIOutboundTag] = true
+	}
+
 	// Then add remaining outbounds
 	for _, ob := range config.Outbounds {
 		if !seenTags[ob.Tag] {
@@ -182,3 +249,40 @@ func (g *ConfigGenerator) Generate(nodeConfig *types.NodeConfig, users []types.U
 
 	return config, nil
 }
+
+// checkReservedAPI rejects panel inbounds and outbounds that would collide
+// with the generated API inbound, outbound or any reserved API endpoint
+func (g *ConfigGenerator) checkReservedAPI(inbounds []types.InboundConfig, outbounds []types.OutboundConfig) error {
+	var endpoints []APIEndpoint
+	addresses := g.reserved
+	if g.apiEnabled {
+		addresses = append([]string{g.apiAddress}, addresses...)
+	}
+	for _, addr := range addresses {
+		endpoint, err := ParseAPIEndpoint(addr)
+		if err != nil {
+			return err
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+
+	for i := range inbounds {
+		inbound := &inbounds[i]
+		if inbound.Tag == APIInboundTag || inbound.Tag == APIOutboundTag {
+			return fmt.Errorf("inbound %q uses a tag reserved for the agent API", inbound.Tag)
+		}
+		for _, endpoint := range endpoints {
+			if endpoint.conflictsWith(inbound) {
+				return fmt.Errorf("inbound %q conflicts with the agent API endpoint %s", inbound.Tag, endpoint)
+			}
+		}
+	}
+	if g.apiEnabled {
+		for _, ob := range outbounds {
+			if ob.Tag == APIOutboundTag {
+				return fmt.Errorf("outbound %q uses a tag reserved for the agent API", ob.Tag)
+			}
+		}
+	}
+	return nil
+}
@@ -4,18 +4,13 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/rs/zerolog/log"
 	handlerService "github.com/xtls/xray-core/app/proxyman/command"
 	statsService "github.com/xtls/xray-core/app/stats/command"
 	"github.com/xtls/xray-core/common/protocol"
 	"github.com/xtls/xray-core/common/serial"
-	"github.com/xtls/xray-core/common/uuid"
-	"github.com/xtls/xray-core/proxy/shadowsocks"
-	"github.com/xtls/xray-core/proxy/trojan"
-	"github.com/xtls/xray-core/proxy/vless"
-	"github.com/xtls/xray-core/proxy/vmess"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 
@@ -26,6 +21,7 @@ import (
 type GRPCClient struct {
 	addr    string
 	timeout time.Duration
+	mu      sync.RWMutex
 }
 
 // NewGRPCClient creates a new Xray gRPC client
@@ -36,16 +32,46 @@ func NewGRPCClient(addr string) *GRPCClient {
 	}
 }
 
+// Address returns the API address the client dials
+func (c *GRPCClient) Address() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.addr
+}
+
+// SetAddress points the client at another API address, e.g. after a handover
+func (c *GRPCClient) SetAddress(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.addr = addr
+}
+
 // dial creates a gRPC connection
 func (c *GRPCClient) dial(ctx context.Context) (*grpc.ClientConn, error) {
 	dialCtx, cancel := context.WithTimeout(ctx, c.timeout)
 	defer cancel()
-	return grpc.DialContext(dialCtx, c.addr,
+	return grpc.DialContext(dialCtx, dialTarget(c.Address()),
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 		grpc.WithBlock(),
 	)
 }
 
+// Ping checks that the API answers a stats request
+func (c *GRPCClient) Ping(ctx context.Context) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("dial xray api: %w", err)
+	}
+	defer conn.Close()
+
+	client := statsService.NewStatsServiceClient(conn)
+	// An API without GetSysStats still answered
+	if _, err := client.GetSysStats(ctx, &statsService.SysStatsRequest{}); err != nil && !isUnimplemented(err) {
+		return fmt.Errorf("get sys stats: %w", err)
+	}
+	return nil
+}
+
 // ========================================
 // Stats Service - Traffic & Online Users
 // ========================================
@@ -74,34 +100,123 @@ func (c *GRPCClient) QueryTrafficStats(ctx context.Context, reset bool) ([]types
 		return nil, fmt.Errorf("query stats: %w", err)
 	}
 
-	// Parse stats: user>>>email>>>traffic>>>uplink/downlink
 	trafficMap := make(map[string]*types.TrafficReport)
 	for _, stat := range resp.Stat {
-		parts := strings.Split(stat.Name, ">>>")
-		if len(parts) != 4 || parts[0] != "user" || parts[2] != "traffic" {
-			continue
-		}
-		email := parts[1]
-		direction := parts[3]
+		addUserTraffic(trafficMap, stat.Name, stat.Value)
+	}
+	return trafficReports(trafficMap), nil
+}
+
+// addUserTraffic records a user>>>email>>>traffic>>>uplink/downlink counter
+func addUserTraffic(trafficMap map[string]*types.TrafficReport, name string, value int64) {
+	parts := strings.Split(name, ">>>")
+	if len(parts) != 4 || parts[0] != "user" || parts[2] != "traffic" {
+		return
+	}
+	email := parts[1]
+	direction := parts[3]
+
+	if _, ok := trafficMap[email]; !ok {
+		trafficMap[email] = &types.TrafficReport{Email: email}
+	}
+	switch direction {
+	case "uplink":
+		trafficMap[email].Upload = value
+	case "downlink":
+		trafficMap[email].Download = value
+	}
+}
+
+// QueryTagTraffic reads the cumulative traffic of every inbound and outbound tag
+func (c *GRPCClient) QueryTagTraffic(ctx context.Context) (*StatsResult, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("dial xray api: %w", err)
+	}
+	defer conn.Close()
+
+	client := statsService.NewStatsServiceClient(conn)
+	resp, err := client.QueryStats(ctx, &statsService.QueryStatsRequest{
+		Pattern: "bound>>>",
+		Reset_:  false,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query stats: %w", err)
+	}
+
+	tags := newTagTraffic()
+	for _, stat := range resp.Stat {
+		tags.add(stat.Name, stat.Value)
+	}
+	return tags.result(), nil
+}
+
+// tagTraffic collects inbound>>>tag>>>traffic>>>uplink/downlink counters
+type tagTraffic struct {
+	inbounds  map[string]*InboundStats
+	outbounds map[string]*OutboundStats
+}
+
+func newTagTraffic() *tagTraffic {
+	return &tagTraffic{
+		inbounds:  make(map[string]*InboundStats),
+		outbounds: make(map[string]*OutboundStats),
+	}
+}
 
-		if _, ok := trafficMap[email]; !ok {
-			trafficMap[email] = &types.TrafficReport{Email: email}
+func (t *tagTraffic) add(name string, value int64) {
+	parts := strings.Split(name, ">>>")
+	if len(parts) != 4 || parts[2] != "traffic" {
+		return
+	}
+	tag, direction := parts[1], parts[3]
+	if tag == APIInboundTag || tag == APIOutboundTag {
+		return
+	}
+
+	var upload, download *int64
+	switch parts[0] {
+	case "inbound":
+		if _, ok := t.inbounds[tag]; !ok {
+			t.inbounds[tag] = &InboundStats{Tag: tag}
 		}
-		switch direction {
-		case "uplink":
-			trafficMap[email].Upload = stat.Value
-		case "downlink":
-			trafficMap[email].Download = stat.Value
+		upload, download = &t.inbounds[tag].Upload, &t.inbounds[tag].Download
+	case "outbound":
+		if _, ok := t.outbounds[tag]; !ok {
+			t.outbounds[tag] = &OutboundStats{Tag: tag}
 		}
+		upload, download = &t.outbounds[tag].Upload, &t.outbounds[tag].Download
+	default:
+		return
+	}
+	switch direction {
+	case "uplink":
+		*upload = value
+	case "downlink":
+		*download = value
+	}
+}
+
+func (t *tagTraffic) result() *StatsResult {
+	result := &StatsResult{}
+	for _, s := range t.inbounds {
+		result.Inbounds = append(result.Inbounds, *s)
+	}
+	for _, s := range t.outbounds {
+		result.Outbounds = append(result.Outbounds, *s)
 	}
+	return result
+}
 
+// trafficReports returns the users with non-zero traffic
+func trafficReports(trafficMap map[string]*types.TrafficReport) []types.TrafficReport {
 	reports := make([]types.TrafficReport, 0, len(trafficMap))
 	for _, r := range trafficMap {
 		if r.Upload > 0 || r.Download > 0 {
 			reports = append(reports, *r)
 		}
 	}
-	return reports, nil
+	return reports
 }
 
 // GetUserOnlineCount gets the online session count for a user
@@ -166,7 +281,8 @@ func (c *GRPCClient) GetAllOnlineUsers(ctx context.Context, emails []string) ([]
 // ========================================
 
 // AddUser adds a user to an inbound (hot reload, no restart needed)
-func (c *GRPCClient) AddUser(ctx context.Context, inboundTag string, user *types.UserConfig) error {
+// The inbound protocol selects the account type from the protocol registry
+func (c *GRPCClient) AddUser(ctx context.Context, inbound *types.InboundConfig, user *types.UserConfig) error {
 	conn, err := c.dial(ctx)
 	if err != nil {
 		return fmt.Errorf("dial xray api: %w", err)
@@ -174,19 +290,19 @@ func (c *GRPCClient) AddUser(ctx context.Context, inboundTag string, user *types
 	defer conn.Close()
 
 	client := handlerService.NewHandlerServiceClient(conn)
-	protoUser, err := c.buildProtocolUser(user)
+	protoUser, err := buildProtocolUser(inbound, user)
 	if err != nil {
 		return fmt.Errorf("build protocol user: %w", err)
 	}
 
 	_, err = client.AlterInbound(ctx, &handlerService.AlterInboundRequest{
-		Tag:       inboundTag,
+		Tag:       inbound.Tag,
 		Operation: serial.ToTypedMessage(&handlerService.AddUserOperation{User: protoUser}),
 	})
 	if err != nil {
 		return fmt.Errorf("alter inbound add user: %w", err)
 	}
-	log.Debug().Str("email", user.Email).Str("inbound", inboundTag).Msg("User added to inbound")
+	log.Debug().Str("email", user.Email).Str("inbound", inbound.Tag).Msg("User added to inbound")
 	return nil
 }
 
@@ -210,6 +326,42 @@ func (c *GRPCClient) RemoveUser(ctx context.Context, inboundTag string, email st
 	return nil
 }
 
+// ListInboundTags returns the tags of the inbounds the core is running
+func (c *GRPCClient) ListInboundTags(ctx context.Context) ([]string, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("dial xray api: %w", err)
+	}
+	defer conn.Close()
+
+	client := handlerService.NewHandlerServiceClient(conn)
+	resp, err := client.ListInbounds(ctx, &handlerService.ListInboundsRequest{IsOnlyTags: true})
+	if err != nil {
+		return nil, fmt.Errorf("list inbounds: %w", err)
+	}
+	tags := make([]string, 0, len(resp.Inbounds))
+	for _, inbound := range resp.Inbounds {
+		tags = append(tags, inbound.Tag)
+	}
+	return tags, nil
+}
+
+// RemoveInbound closes an inbound listener, established connections are kept
+func (c *GRPCClient) RemoveInbound(ctx context.Context, tag string) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("dial xray api: %w", err)
+	}
+	defer conn.Close()
+
+	client := handlerService.NewHandlerServiceClient(conn)
+	if _, err := client.RemoveInbound(ctx, &handlerService.RemoveInboundRequest{Tag: tag}); err != nil {
+		return fmt.Errorf("remove inbound: %w", err)
+	}
+	log.Debug().Str("inbound", tag).Msg("Inbound removed")
+	return nil
+}
+
 // RemoveUserFromAllInbounds removes a user from all specified inbounds
 func (c *GRPCClient) RemoveUserFromAllInbounds(ctx context.Context, email string, inboundTags []string) error {
 	conn, err := c.dial(ctx)
@@ -233,7 +385,8 @@ func (c *GRPCClient) RemoveUserFromAllInbounds(ctx context.Context, email string
 }
 
 // SyncUsers synchronizes users with Xray (add new, remove old) without restart
-func (c *GRPCClient) SyncUsers(ctx context.Context, inboundTag string, currentEmails []string, newUsers []*types.UserConfig) error {
+func (c *GRPCClient) SyncUsers(ctx context.Context, inbound *types.InboundConfig, currentEmails []string, newUsers []*types.UserConfig) error {
+	inboundTag := inbound.Tag
 	conn, err := c.dial(ctx)
 	if err != nil {
 		return fmt.Errorf("dial xray api: %w", err)
@@ -266,7 +419,7 @@ func (c *GRPCClient) SyncUsers(ctx context.Context, inboundTag string, currentEm
 	// Add users not in current list
 	for email, user := range newUserMap {
 		if !currentSet[email] {
-			protoUser, err := c.buildProtocolUser(user)
+			protoUser, err := buildProtocolUser(inbound, user)
 			if err != nil {
 				log.Warn().Err(err).Str("email", email).Msg("Failed to build user")
 				continue
@@ -286,62 +439,21 @@ func (c *GRPCClient) SyncUsers(ctx context.Context, inboundTag string, currentEm
 // Helper Methods
 // ========================================
 
-// buildProtocolUser builds a protocol.User from UserConfig
-func (c *GRPCClient) buildProtocolUser(user *types.UserConfig) (*protocol.User, error) {
-	protoUser := &protocol.User{
-		Level: uint32(user.Level),
-		Email: user.Email,
+// buildProtocolUser builds a protocol.User for an inbound
+func buildProtocolUser(inbound *types.InboundConfig, user *types.UserConfig) (*protocol.User, error) {
+	proto, ok := LookupProtocol(inbound.Protocol)
+	if !ok {
+		return nil, fmt.Errorf("unsupported inbound protocol %q for user %s", inbound.Protocol, user.Email)
 	}
-
-	// Determine protocol by available credentials and build account
-	if user.UUID != "" {
-		uid, err := uuid.ParseString(user.UUID)
-		if err != nil {
-			return nil, fmt.Errorf("parse uuid: %w", err)
-		}
-
-		if user.Flow != "" {
-			// VLESS account
-			protoUser.Account = serial.ToTypedMessage(&vless.Account{
-				Id:   uid.String(),
-				Flow: user.Flow,
-			})
-		} else {
-			// VMess account (AlterId deprecated in xray-core, use SecuritySettings)
-			protoUser.Account = serial.ToTypedMessage(&vmess.Account{
-				Id: uid.String(),
-				// Note: AlterId is deprecated in xray-core v1.8.x
-			})
-		}
-		return protoUser, nil
-	}
-
-	if user.Password != "" {
-		if user.Method != "" {
-			// Shadowsocks account
-			cipherType := shadowsocks.CipherType_AES_256_GCM
-			switch user.Method {
-			case "aes-128-gcm":
-				cipherType = shadowsocks.CipherType_AES_128_GCM
-			case "chacha20-poly1305", "chacha20-ietf-poly1305":
-				cipherType = shadowsocks.CipherType_CHACHA20_POLY1305
-			case "none":
-				cipherType = shadowsocks.CipherType_NONE
-			}
-			protoUser.Account = serial.ToTypedMessage(&shadowsocks.Account{
-				Password:   user.Password,
-				CipherType: cipherType,
-			})
-		} else {
-			// Trojan account
-			protoUser.Account = serial.ToTypedMessage(&trojan.Account{
-				Password: user.Password,
-			})
-		}
-		return protoUser, nil
+	account, err := proto.Account(inbound, user)
+	if err != nil {
+		return nil, err
 	}
-
-	return nil, fmt.Errorf("cannot determine protocol for user %s: no uuid or password", user.Email)
+	return &protocol.User{
+		Level:   uint32(user.Level),
+		Email:   user.Email,
+		Account: account,
+	}, nil
 }
 
 // KickUser kicks a user from all inbounds (convenience method)
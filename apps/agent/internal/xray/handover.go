@@ -0,0 +1,287 @@
+package xray
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// ReadyFunc waits until a core's API answers and its inbounds listen
+type ReadyFunc func(ctx context.Context, api CoreAPI, alive func() bool) error
+
+// Handover restarts an Xray process blue/green: the replacement starts on the
+// same ports (Xray listens with SO_REUSEPORT), and once its API is healthy the
+// old process stops accepting and drains its connections for a grace period.
+// The two processes alternate between two API addresses so both stay reachable.
+type Handover struct {
+	process   *ProcessManager
+	api       *GRPCClient
+	addresses [2]string
+	active    int
+	grace     time.Duration
+	restartMu sync.Mutex
+
+	mu       sync.Mutex
+	draining map[*coreProc]*GRPCClient
+	drained  map[string]*types.TrafficReport
+}
+
+// NewHandover creates a blue/green restarter for an Xray process and its API client
+func NewHandover(process *ProcessManager, api *GRPCClient, standbyAPIAddress string, grace time.Duration) *Handover {
+	return &Handover{
+		process:   process,
+		api:       api,
+		addresses: [2]string{api.Address(), standbyAPIAddress},
+		grace:     grace,
+		draining:  make(map[*coreProc]*GRPCClient),
+		drained:   make(map[string]*types.TrafficReport),
+	}
+}
+
+// Restart replaces the running process with one started from the current config.
+// If the replacement never becomes ready it is killed and the old process keeps serving.
+func (h *Handover) Restart(ctx context.Context, ready ReadyFunc) error {
+	h.restartMu.Lock()
+	defer h.restartMu.Unlock()
+
+	pm := h.process
+	pm.mu.Lock()
+	old := pm.proc
+	running := pm.running
+	pm.mu.Unlock()
+	if !running || old == nil {
+		// Nothing to hand over
+		if err := h.coldStart(ctx); err != nil {
+			return err
+		}
+		return ready(ctx, h.api, pm.IsRunning)
+	}
+
+	next := 1 - h.active
+	nextAddress := h.addresses[next]
+	configPath, err := h.writeSlotConfig(next, nextAddress)
+	if err != nil {
+		return err
+	}
+
+	proc, err := pm.spawn(ctx, configPath)
+	if err != nil {
+		return err
+	}
+
+	nextAPI := NewGRPCClient(nextAddress)
+	alive := func() bool {
+		select {
+		case <-proc.done:
+			return false
+		default:
+			return true
+		}
+	}
+	if err := ready(ctx, nextAPI, alive); err != nil {
+		log.Warn().Err(err).Str("core", pm.name).Msg("Replacement core not ready, keeping current process")
+		pm.terminate(proc, 5*time.Second)
+		return fmt.Errorf("handover: %w", err)
+	}
+
+	// Promote the replacement; the old process is now only drained
+	pm.mu.Lock()
+	pm.proc = proc
+	pm.running = true
+	pm.mu.Unlock()
+
+	oldAPI := NewGRPCClient(h.api.Address())
+	h.api.SetAddress(nextAddress)
+	h.active = next
+
+	h.mu.Lock()
+	h.draining[old] = oldAPI
+	h.mu.Unlock()
+
+	log.Info().Str("core", pm.name).Str("api", nextAddress).Dur("grace", h.grace).Msg("Core handed over, draining previous process")
+	go h.drain(old, oldAPI)
+	return nil
+}
+
+// ColdRestart stops the process and starts it from the config path on the
+// primary API address, moving the API client back there. Restarts that do
+// not hand over, such as a rollback, go through it so the client does not
+// stay on the standby address of a process that is gone.
+func (h *Handover) ColdRestart(ctx context.Context) error {
+	h.restartMu.Lock()
+	defer h.restartMu.Unlock()
+
+	if err := h.process.Stop(); err != nil {
+		return err
+	}
+	return h.coldStart(ctx)
+}
+
+// coldStart starts the process in slot 0; h.restartMu must be held
+func (h *Handover) coldStart(ctx context.Context) error {
+	if _, err := h.writeSlotConfig(0, h.addresses[0]); err != nil {
+		return err
+	}
+	h.active = 0
+	h.api.SetAddress(h.addresses[0])
+	return h.process.Start(ctx)
+}
+
+// drain closes the old process's listeners, waits out the grace period and
+// collects its final traffic counters before terminating it
+func (h *Handover) drain(old *coreProc, oldAPI *GRPCClient) {
+	ctx := context.Background()
+
+	tags, err := oldAPI.ListInboundTags(ctx)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to list inbounds of draining core")
+	}
+	for _, tag := range tags {
+		if tag == APIInboundTag {
+			continue
+		}
+		if err := oldAPI.RemoveInbound(ctx, tag); err != nil {
+			log.Debug().Err(err).Str("inbound", tag).Msg("Failed to close inbound of draining core")
+		}
+	}
+
+	select {
+	case <-time.After(h.grace):
+	case <-old.done:
+	}
+	h.retire(old)
+}
+
+// retire collects the final counters of a draining process and terminates it
+func (h *Handover) retire(old *coreProc) {
+	h.mu.Lock()
+	oldAPI, ok := h.draining[old]
+	delete(h.draining, old)
+	h.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	select {
+	case <-old.done:
+	default:
+		if traffics, err := oldAPI.QueryTrafficStats(context.Background(), true); err != nil {
+			log.Warn().Err(err).Msg("Failed to collect traffic from draining core")
+		} else {
+			h.mu.Lock()
+			for _, t := range traffics {
+				h.addDrained(t)
+			}
+			h.mu.Unlock()
+		}
+		h.process.terminate(old, 5*time.Second)
+	}
+}
+
+// addDrained accumulates traffic of draining processes until the next report
+func (h *Handover) addDrained(t types.TrafficReport) {
+	if existing, ok := h.drained[t.Email]; ok {
+		existing.Upload += t.Upload
+		existing.Download += t.Download
+		return
+	}
+	report := t
+	h.drained[t.Email] = &report
+}
+
+// DrainedTraffic returns the traffic of draining processes since the last call
+func (h *Handover) DrainedTraffic(ctx context.Context) []types.TrafficReport {
+	h.mu.Lock()
+	draining := make(map[*coreProc]*GRPCClient, len(h.draining))
+	for proc, api := range h.draining {
+		draining[proc] = api
+	}
+	h.mu.Unlock()
+
+	for _, api := range draining {
+		traffics, err := api.QueryTrafficStats(ctx, true)
+		if err != nil {
+			log.Debug().Err(err).Msg("Failed to collect traffic from draining core")
+			continue
+		}
+		h.mu.Lock()
+		for _, t := range traffics {
+			h.addDrained(t)
+		}
+		h.mu.Unlock()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	reports := make([]types.TrafficReport, 0, len(h.drained))
+	for _, r := range h.drained {
+		reports = append(reports, *r)
+	}
+	h.drained = make(map[string]*types.TrafficReport)
+	return reports
+}
+
+// StopDraining terminates draining processes without waiting out the grace period
+func (h *Handover) StopDraining() {
+	h.mu.Lock()
+	procs := make([]*coreProc, 0, len(h.draining))
+	for proc := range h.draining {
+		procs = append(procs, proc)
+	}
+	h.mu.Unlock()
+
+	for _, proc := range procs {
+		h.retire(proc)
+	}
+}
+
+// writeSlotConfig writes the current config with its API inbound moved to the
+// slot's address. Slot 0 runs from the config path itself.
+func (h *Handover) writeSlotConfig(slot int, apiAddress string) (string, error) {
+	data, err := os.ReadFile(h.process.configPath)
+	if err != nil {
+		return "", fmt.Errorf("read xray config: %w", err)
+	}
+	data, err = withAPIAddress(data, apiAddress)
+	if err != nil {
+		return "", err
+	}
+
+	path := h.process.configPath
+	if slot == 1 {
+		path = strings.TrimSuffix(path, ".json") + ".standby.json"
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("write xray config: %w", err)
+	}
+	return path, nil
+}
+
+// withAPIAddress rewrites the listener of the api inbound
+func withAPIAddress(data []byte, apiAddress string) ([]byte, error) {
+	endpoint, err := ParseAPIEndpoint(apiAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	var config map[string]interface{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("parse xray config: %w", err)
+	}
+	inbounds, _ := config["inbounds"].([]interface{})
+	for i, raw := range inbounds {
+		inbound, ok := raw.(map[string]interface{})
+		if !ok || inbound["tag"] != APIInboundTag {
+			continue
+		}
+		inbounds[i] = endpoint.inbound()
+		return json.MarshalIndent(config, "", "  ")
+	}
+	return nil, fmt.Errorf("xray config has no api inbound")
+}
@@ -0,0 +1,6 @@
+package xray
+
+import "github.com/synexim/panel-agent/internal/logging"
+
+// log is the Xray core's logger, its level is log.components.xray
+var log = logging.Component("xray")
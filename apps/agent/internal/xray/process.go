@@ -8,31 +8,42 @@ import (
 	"sync"
 	"syscall"
 	"time"
-
-	"github.com/rs/zerolog/log"
 )
 
-// ProcessManager manages the Xray process
+// ProcessManager manages a proxy core process (Xray or sing-box)
 type ProcessManager struct {
+	name       string
 	binaryPath string
 	configPath string
 	assetPath  string
 
-	cmd     *exec.Cmd
+	proc    *coreProc
 	mu      sync.Mutex
 	running bool
 }
 
-// NewProcessManager creates a new process manager
+// coreProc is one spawned core process
+type coreProc struct {
+	cmd  *exec.Cmd
+	done chan struct{} // closed when the process exits
+}
+
+// NewProcessManager creates a new process manager for Xray
 func NewProcessManager(binaryPath, configPath, assetPath string) *ProcessManager {
+	return NewCoreProcessManager("xray", binaryPath, configPath, assetPath)
+}
+
+// NewCoreProcessManager creates a process manager for any core that runs as "<binary> run -c <config>"
+func NewCoreProcessManager(name, binaryPath, configPath, assetPath string) *ProcessManager {
 	return &ProcessManager{
+		name:       name,
 		binaryPath: binaryPath,
 		configPath: configPath,
 		assetPath:  assetPath,
 	}
 }
 
-// Start starts the Xray process
+// Start starts the core process
 func (m *ProcessManager) Start(ctx context.Context) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -41,84 +52,105 @@ func (m *ProcessManager) Start(ctx context.Context) error {
 		return nil
 	}
 
-	m.cmd = exec.CommandContext(ctx, m.binaryPath, "run", "-c", m.configPath)
-	m.cmd.Env = append(os.Environ(), fmt.Sprintf("XRAY_LOCATION_ASSET=%s", m.assetPath))
-	m.cmd.Stdout = os.Stdout
-	m.cmd.Stderr = os.Stderr
+	proc, err := m.spawn(ctx, m.configPath)
+	if err != nil {
+		return err
+	}
+	m.proc = proc
+	m.running = true
+	return nil
+}
+
+// spawn starts a core process from a config file and monitors it in background
+func (m *ProcessManager) spawn(ctx context.Context, configPath string) (*coreProc, error) {
+	cmd := exec.CommandContext(ctx, m.binaryPath, "run", "-c", configPath)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("XRAY_LOCATION_ASSET=%s", m.assetPath))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
 
-	if err := m.cmd.Start(); err != nil {
-		return fmt.Errorf("start xray: %w", err)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start %s: %w", m.name, err)
 	}
+	log.Info().Str("core", m.name).Int("pid", cmd.Process.Pid).Msg("Core process started")
 
-	m.running = true
-	log.Info().Int("pid", m.cmd.Process.Pid).Msg("Xray process started")
+	proc := &coreProc{cmd: cmd, done: make(chan struct{})}
 
 	// Monitor process in background
 	go func() {
-		err := m.cmd.Wait()
+		err := cmd.Wait()
+		close(proc.done)
 		m.mu.Lock()
-		m.running = false
+		if m.proc == proc {
+			m.running = false
+		}
 		m.mu.Unlock()
 		if err != nil {
-			log.Error().Err(err).Msg("Xray process exited with error")
+			log.Error().Err(err).Str("core", m.name).Int("pid", cmd.Process.Pid).Msg("Core process exited with error")
 		} else {
-			log.Info().Msg("Xray process exited")
+			log.Info().Str("core", m.name).Int("pid", cmd.Process.Pid).Msg("Core process exited")
 		}
 	}()
 
-	return nil
+	return proc, nil
 }
 
-// Stop stops the Xray process
+// Stop stops the core process
 func (m *ProcessManager) Stop() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if !m.running || m.cmd == nil || m.cmd.Process == nil {
+	if !m.running || m.proc == nil {
 		return nil
 	}
 
-	log.Info().Msg("Stopping Xray process")
-	if err := m.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+	log.Info().Str("core", m.name).Msg("Stopping core process")
+	if err := m.terminate(m.proc, 5*time.Second); err != nil {
+		return err
+	}
+
+	m.running = false
+	return nil
+}
+
+// terminate sends SIGTERM and kills the process if it does not exit within timeout
+func (m *ProcessManager) terminate(proc *coreProc, timeout time.Duration) error {
+	if err := proc.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		select {
+		case <-proc.done:
+			return nil
+		default:
+		}
 		return fmt.Errorf("send SIGTERM: %w", err)
 	}
 
 	// Wait for graceful shutdown
-	done := make(chan struct{})
-	go func() {
-		m.cmd.Wait()
-		close(done)
-	}()
-
 	select {
-	case <-done:
-		log.Info().Msg("Xray process stopped gracefully")
-	case <-time.After(5 * time.Second):
-		log.Warn().Msg("Xray process did not stop gracefully, killing")
-		m.cmd.Process.Kill()
+	case <-proc.done:
+		log.Info().Str("core", m.name).Int("pid", proc.cmd.Process.Pid).Msg("Core process stopped gracefully")
+	case <-time.After(timeout):
+		log.Warn().Str("core", m.name).Int("pid", proc.cmd.Process.Pid).Msg("Core process did not stop gracefully, killing")
+		proc.cmd.Process.Kill()
+		<-proc.done
 	}
-
-	m.running = false
 	return nil
 }
 
-// Restart restarts the Xray process
+// Restart restarts the core process
 func (m *ProcessManager) Restart(ctx context.Context) error {
 	if err := m.Stop(); err != nil {
 		return err
 	}
-	time.Sleep(500 * time.Millisecond)
 	return m.Start(ctx)
 }
 
-// IsRunning returns whether Xray is running
+// IsRunning returns whether the core is running
 func (m *ProcessManager) IsRunning() bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	return m.running
 }
 
-// GetVersion returns Xray version
+// GetVersion returns the core version
 func (m *ProcessManager) GetVersion() string {
 	cmd := exec.Command(m.binaryPath, "version")
 	output, err := cmd.Output()
@@ -0,0 +1,183 @@
+package xray
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/xtls/xray-core/common/serial"
+	"github.com/xtls/xray-core/common/uuid"
+	"github.com/xtls/xray-core/proxy/trojan"
+	"github.com/xtls/xray-core/proxy/vless"
+	"github.com/xtls/xray-core/proxy/vmess"
+
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// Protocol builds users for one inbound protocol, both for the generated
+// config file and for hot add through the Handler API
+type Protocol interface {
+	// SettingsKey returns the inbound settings key users are written to ("clients" or "accounts")
+	SettingsKey() string
+
+	// ConfigClient renders a user as an entry of the inbound settings
+	ConfigClient(inbound *types.InboundConfig, user *types.UserConfig) (map[string]interface{}, error)
+
+	// Account builds the typed account for the Handler API
+	Account(inbound *types.InboundConfig, user *types.UserConfig) (*serial.TypedMessage, error)
+}
+
+// SettingsValidator is implemented by protocols that validate inbound-level
+// settings, given the users assigned to the inbound
+type SettingsValidator interface {
+	ValidateSettings(inbound *types.InboundConfig, users []types.UserConfig) error
+}
+
+// coldUsers is implemented by protocols whose Xray inbound is not a user
+// manager, so users can only change with a restart
+type coldUsers interface {
+	coldUsers()
+}
+
+// HotUsers reports whether users of a protocol's inbounds can be added and
+// removed through the Handler API
+func HotUsers(protocol string) bool {
+	p, ok := LookupProtocol(protocol)
+	if !ok {
+		return true
+	}
+	_, cold := p.(coldUsers)
+	return !cold
+}
+
+var (
+	protocolsMu sync.RWMutex
+	protocols   = make(map[string]Protocol)
+)
+
+// RegisterProtocol registers the user builder for an inbound protocol
+func RegisterProtocol(name string, p Protocol) {
+	protocolsMu.Lock()
+	defer protocolsMu.Unlock()
+	protocols[name] = p
+}
+
+// LookupProtocol returns the user builder for an inbound protocol
+func LookupProtocol(name string) (Protocol, bool) {
+	protocolsMu.RLock()
+	defer protocolsMu.RUnlock()
+	p, ok := protocols[name]
+	return p, ok
+}
+
+func init() {
+	RegisterProtocol("vless", vlessProtocol{})
+	RegisterProtocol("vmess", vmessProtocol{})
+	RegisterProtocol("trojan", trojanProtocol{})
+	RegisterProtocol("shadowsocks", shadowsocksProtocol{})
+	RegisterProtocol("socks", accountProtocol{})
+	RegisterProtocol("http", accountProtocol{})
+}
+
+// baseClient returns the fields shared by all "clients" entries
+func baseClient(user *types.UserConfig) map[string]interface{} {
+	return map[string]interface{}{
+		"email": user.Email,
+		"level": user.Level,
+	}
+}
+
+// parseUserUUID validates a user's UUID
+func parseUserUUID(user *types.UserConfig) (string, error) {
+	if user.UUID == "" {
+		return "", fmt.Errorf("user %s has no uuid", user.Email)
+	}
+	uid, err := uuid.ParseString(user.UUID)
+	if err != nil {
+		return "", fmt.Errorf("parse uuid: %w", err)
+	}
+	return uid.String(), nil
+}
+
+// vlessProtocol builds VLESS users
+type vlessProtocol struct{}
+
+func (vlessProtocol) SettingsKey() string { return "clients" }
+
+func (vlessProtocol) ConfigClient(_ *types.InboundConfig, user *types.UserConfig) (map[string]interface{}, error) {
+	client := baseClient(user)
+	client["id"] = user.UUID
+	if user.Flow != "" {
+		client["flow"] = user.Flow
+	}
+	return client, nil
+}
+
+func (vlessProtocol) Account(_ *types.InboundConfig, user *types.UserConfig) (*serial.TypedMessage, error) {
+	id, err := parseUserUUID(user)
+	if err != nil {
+		return nil, err
+	}
+	return serial.ToTypedMessage(&vless.Account{Id: id, Flow: user.Flow}), nil
+}
+
+// vmessProtocol builds VMess users
+type vmessProtocol struct{}
+
+func (vmessProtocol) SettingsKey() string { return "clients" }
+
+func (vmessProtocol) ConfigClient(_ *types.InboundConfig, user *types.UserConfig) (map[string]interface{}, error) {
+	client := baseClient(user)
+	client["id"] = user.UUID
+	client["alterId"] = user.AlterID
+	if user.Security != "" {
+		client["security"] = user.Security
+	}
+	return client, nil
+}
+
+func (vmessProtocol) Account(_ *types.InboundConfig, user *types.UserConfig) (*serial.TypedMessage, error) {
+	id, err := parseUserUUID(user)
+	if err != nil {
+		return nil, err
+	}
+	// AlterId is deprecated in xray-core, only the id is needed server side
+	return serial.ToTypedMessage(&vmess.Account{Id: id}), nil
+}
+
+// trojanProtocol builds Trojan users
+type trojanProtocol struct{}
+
+func (trojanProtocol) SettingsKey() string { return "clients" }
+
+func (trojanProtocol) ConfigClient(_ *types.InboundConfig, user *types.UserConfig) (map[string]interface{}, error) {
+	client := baseClient(user)
+	client["password"] = user.Password
+	return client, nil
+}
+
+func (trojanProtocol) Account(_ *types.InboundConfig, user *types.UserConfig) (*serial.TypedMessage, error) {
+	if user.Password == "" {
+		return nil, fmt.Errorf("user %s has no password", user.Email)
+	}
+	return serial.ToTypedMessage(&trojan.Account{Password: user.Password}), nil
+}
+
+// accountProtocol builds username/password accounts for SOCKS and HTTP.
+// Accounts are keyed by email. Xray's SOCKS and HTTP servers are not user
+// managers, so there is no hot add: account changes restart the core.
+type accountProtocol struct{}
+
+func (accountProtocol) coldUsers() {}
+
+func (accountProtocol) SettingsKey() string { return "accounts" }
+
+func (accountProtocol) ConfigClient(_ *types.InboundConfig, user *types.UserConfig) (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"user": user.Email,
+		"pass": user.Password,
+	}, nil
+}
+
+func (accountProtocol) Account(_ *types.InboundConfig, user *types.UserConfig) (*serial.TypedMessage, error) {
+	return nil, fmt.Errorf("user %s: socks and http accounts cannot be added through the Handler API", user.Email)
+}
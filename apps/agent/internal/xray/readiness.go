@@ -0,0 +1,155 @@
+package xray
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// DefaultReadyTimeout bounds how long a core may take to become ready
+const DefaultReadyTimeout = 15 * time.Second
+
+// readyPollInterval is the delay between readiness checks
+const readyPollInterval = 200 * time.Millisecond
+
+// InboundLister is implemented by core APIs that can list their running inbounds
+type InboundLister interface {
+	ListInboundTags(ctx context.Context) ([]string, error)
+}
+
+// NotReadyError describes why a core did not become ready
+type NotReadyError struct {
+	MissingInbounds []string
+	Cause           error
+}
+
+func (e *NotReadyError) Error() string {
+	if len(e.MissingInbounds) > 0 {
+		return fmt.Sprintf("inbounds not listening: %s", strings.Join(e.MissingInbounds, ", "))
+	}
+	return fmt.Sprintf("core not ready: %v", e.Cause)
+}
+
+func (e *NotReadyError) Unwrap() error {
+	return e.Cause
+}
+
+// WaitReady polls until the core's API answers and every expected inbound is
+// listening. It fails early when alive reports the core has exited.
+func WaitReady(ctx context.Context, api CoreAPI, inbounds []types.InboundConfig, timeout time.Duration, alive func() bool) error {
+	if timeout <= 0 {
+		timeout = DefaultReadyTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	for {
+		if alive != nil && !alive() {
+			return &NotReadyError{Cause: fmt.Errorf("core exited")}
+		}
+
+		checkCtx, cancel := context.WithTimeout(ctx, time.Second)
+		err := CheckReady(checkCtx, api, inbounds)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return &NotReadyError{Cause: ctx.Err()}
+		case <-time.After(readyPollInterval):
+		}
+	}
+}
+
+// CheckReady checks once that the API answers and every expected inbound is listening
+func CheckReady(ctx context.Context, api CoreAPI, inbounds []types.InboundConfig) error {
+	if err := api.Ping(ctx); err != nil {
+		return &NotReadyError{Cause: err}
+	}
+
+	var missing []string
+	running, listed := runningInbounds(ctx, api)
+	for _, inbound := range inbounds {
+		if listed && !running[inbound.Tag] {
+			missing = append(missing, inbound.Tag)
+			continue
+		}
+		if network, address, ok := inboundDialAddress(&inbound); ok {
+			conn, err := net.DialTimeout(network, address, 500*time.Millisecond)
+			if err != nil {
+				missing = append(missing, inbound.Tag)
+				continue
+			}
+			conn.Close()
+		}
+	}
+	if len(missing) > 0 {
+		return &NotReadyError{MissingInbounds: missing}
+	}
+	return nil
+}
+
+// runningInbounds lists the inbound tags the core reports, if its API can
+func runningInbounds(ctx context.Context, api CoreAPI) (map[string]bool, bool) {
+	lister, ok := api.(InboundLister)
+	if !ok {
+		return nil, false
+	}
+	tags, err := lister.ListInboundTags(ctx)
+	if err != nil {
+		// sing-box's v2ray_api has no HandlerService, rely on dialing
+		return nil, false
+	}
+	running := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		running[tag] = true
+	}
+	return running, true
+}
+
+// inboundDialAddress returns where a stream inbound accepts connections.
+// UDP based inbounds (QUIC, mKCP) cannot be probed by dialing.
+func inboundDialAddress(inbound *types.InboundConfig) (string, string, bool) {
+	switch inbound.Protocol {
+	case "hysteria2", "tuic":
+		return "", "", false
+	}
+	if stream, ok := inbound.StreamSettings.(map[string]interface{}); ok {
+		switch stream["network"] {
+		case "kcp", "mkcp", "quic":
+			return "", "", false
+		}
+	}
+
+	listen := inbound.Listen
+	if strings.HasPrefix(listen, "/") || strings.HasPrefix(listen, "@") {
+		return "unix", listen, true
+	}
+	if inbound.Port <= 0 {
+		return "", "", false
+	}
+	switch listen {
+	case "", "0.0.0.0":
+		listen = "127.0.0.1"
+	case "::":
+		listen = "::1"
+	}
+	return "tcp", net.JoinHostPort(listen, strconv.Itoa(inbound.Port)), true
+}
+
+// isUnimplemented reports whether a gRPC call failed because the service is missing
+func isUnimplemented(err error) bool {
+	return status.Code(err) == codes.Unimplemented
+}
@@ -0,0 +1,165 @@
+package xray
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/xtls/xray-core/common/serial"
+	"github.com/xtls/xray-core/proxy/shadowsocks"
+	"github.com/xtls/xray-core/proxy/shadowsocks_2022"
+
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// shadowsocksCiphers maps legacy AEAD method names to Xray cipher types
+var shadowsocksCiphers = map[string]shadowsocks.CipherType{
+	"aes-128-gcm":             shadowsocks.CipherType_AES_128_GCM,
+	"aead_aes_128_gcm":        shadowsocks.CipherType_AES_128_GCM,
+	"aes-256-gcm":             shadowsocks.CipherType_AES_256_GCM,
+	"aead_aes_256_gcm":        shadowsocks.CipherType_AES_256_GCM,
+	"chacha20-poly1305":       shadowsocks.CipherType_CHACHA20_POLY1305,
+	"chacha20-ietf-poly1305":  shadowsocks.CipherType_CHACHA20_POLY1305,
+	"aead_chacha20_poly1305":  shadowsocks.CipherType_CHACHA20_POLY1305,
+	"xchacha20-poly1305":      shadowsocks.CipherType_XCHACHA20_POLY1305,
+	"xchacha20-ietf-poly1305": shadowsocks.CipherType_XCHACHA20_POLY1305,
+	"aead_xchacha20_poly1305": shadowsocks.CipherType_XCHACHA20_POLY1305,
+	"none":                    shadowsocks.CipherType_NONE,
+	"plain":                   shadowsocks.CipherType_NONE,
+}
+
+// shadowsocks2022KeySizes maps Shadowsocks 2022 methods to their key length in bytes
+var shadowsocks2022KeySizes = map[string]int{
+	"2022-blake3-aes-128-gcm":       16,
+	"2022-blake3-aes-256-gcm":       32,
+	"2022-blake3-chacha20-poly1305": 32,
+}
+
+// IsShadowsocks2022 reports whether a method is a Shadowsocks 2022 method
+func IsShadowsocks2022(method string) bool {
+	_, ok := shadowsocks2022KeySizes[method]
+	return ok
+}
+
+// multiUser2022 reports whether Xray serves users on a Shadowsocks 2022
+// method, which it only does for the AES ones
+func multiUser2022(method string) bool {
+	return strings.Contains(method, "aes")
+}
+
+// validateShadowsocks2022Key checks that a key is base64 of the method's key length
+func validateShadowsocks2022Key(method, key string) error {
+	data, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return fmt.Errorf("%s key is not valid base64", method)
+	}
+	if size := shadowsocks2022KeySizes[method]; len(data) != size {
+		return fmt.Errorf("%s key must be %d bytes, got %d", method, size, len(data))
+	}
+	return nil
+}
+
+// inboundSetting reads a string field from an inbound's settings
+func inboundSetting(inbound *types.InboundConfig, key string) string {
+	if inbound == nil {
+		return ""
+	}
+	settings, ok := inbound.Settings.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	value, _ := settings[key].(string)
+	return value
+}
+
+// shadowsocksProtocol builds Shadowsocks users. Legacy AEAD inbounds carry a
+// method per user; Shadowsocks 2022 inbounds are multi-user with a server PSK
+// and a per-user key, and users must not carry a method.
+type shadowsocksProtocol struct{}
+
+func (shadowsocksProtocol) SettingsKey() string { return "clients" }
+
+// ValidateSettings checks the inbound-level method and server PSK. A
+// 2022-blake3-chacha20-poly1305 inbound only serves its own password, so it
+// must not have users assigned.
+func (shadowsocksProtocol) ValidateSettings(inbound *types.InboundConfig, users []types.UserConfig) error {
+	method := inboundSetting(inbound, "method")
+	if method == "" {
+		return nil // Legacy multi-user inbound, methods are per user
+	}
+	if IsShadowsocks2022(method) {
+		if !multiUser2022(method) && len(users) > 0 {
+			return fmt.Errorf("inbound %s: %s does not support multiple users, use a 2022-blake3-aes method", inbound.Tag, method)
+		}
+		if err := validateShadowsocks2022Key(method, inboundSetting(inbound, "password")); err != nil {
+			return fmt.Errorf("inbound %s: server psk: %w", inbound.Tag, err)
+		}
+		return nil
+	}
+	if _, ok := shadowsocksCiphers[method]; !ok {
+		return fmt.Errorf("inbound %s: unsupported shadowsocks method %q", inbound.Tag, method)
+	}
+	return nil
+}
+
+// userMethod resolves the method a user is served with
+func (shadowsocksProtocol) userMethod(inbound *types.InboundConfig, user *types.UserConfig) (string, error) {
+	inboundMethod := inboundSetting(inbound, "method")
+	if IsShadowsocks2022(inboundMethod) {
+		if !multiUser2022(inboundMethod) {
+			return "", fmt.Errorf("user %s: %s does not support multiple users", user.Email, inboundMethod)
+		}
+		if user.Method != "" && user.Method != inboundMethod {
+			return "", fmt.Errorf("user %s method %s does not match inbound method %s", user.Email, user.Method, inboundMethod)
+		}
+		return inboundMethod, nil
+	}
+	method := user.Method
+	if method == "" {
+		method = inboundMethod
+	}
+	if IsShadowsocks2022(method) {
+		return "", fmt.Errorf("user %s uses %s on a non-2022 inbound", user.Email, method)
+	}
+	if _, ok := shadowsocksCiphers[method]; !ok {
+		return "", fmt.Errorf("user %s: unsupported shadowsocks method %q", user.Email, method)
+	}
+	return method, nil
+}
+
+func (p shadowsocksProtocol) ConfigClient(inbound *types.InboundConfig, user *types.UserConfig) (map[string]interface{}, error) {
+	method, err := p.userMethod(inbound, user)
+	if err != nil {
+		return nil, err
+	}
+	client := baseClient(user)
+	client["password"] = user.Password
+	if IsShadowsocks2022(method) {
+		if err := validateShadowsocks2022Key(method, user.Password); err != nil {
+			return nil, fmt.Errorf("user %s: %w", user.Email, err)
+		}
+	} else {
+		client["method"] = method
+	}
+	return client, nil
+}
+
+func (p shadowsocksProtocol) Account(inbound *types.InboundConfig, user *types.UserConfig) (*serial.TypedMessage, error) {
+	if user.Password == "" {
+		return nil, fmt.Errorf("user %s has no password", user.Email)
+	}
+	method, err := p.userMethod(inbound, user)
+	if err != nil {
+		return nil, err
+	}
+	if IsShadowsocks2022(method) {
+		if err := validateShadowsocks2022Key(method, user.Password); err != nil {
+			return nil, fmt.Errorf("user %s: %w", user.Email, err)
+		}
+		return serial.ToTypedMessage(&shadowsocks_2022.Account{Key: user.Password}), nil
+	}
+	return serial.ToTypedMessage(&shadowsocks.Account{
+		Password:   user.Password,
+		CipherType: shadowsocksCiphers[method],
+	}), nil
+}
@@ -0,0 +1,211 @@
+package xray
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// maxTemplateInstances caps how many inbounds a single template may expand to
+const maxTemplateInstances = 65535
+
+// templateInstance is one concrete inbound produced by an inbound template
+type templateInstance struct {
+	Tag      string
+	Listen   string
+	Port     int
+	EgressIP string
+}
+
+// templateInstances expands a template into its concrete instances.
+// Instances are ordered by listen IP then port so expansion is deterministic.
+func templateInstances(t *types.InboundTemplate) ([]templateInstance, error) {
+	if t.Tag == "" {
+		return nil, fmt.Errorf("inbound template missing tag")
+	}
+	if t.PortStart <= 0 || t.PortEnd > 65535 || t.PortEnd < t.PortStart {
+		return nil, fmt.Errorf("inbound template %s: invalid port range %d-%d", t.Tag, t.PortStart, t.PortEnd)
+	}
+	listenIPs := t.ListenIPs
+	if len(listenIPs) == 0 {
+		listenIPs = []string{"0.0.0.0"}
+	}
+	count := len(listenIPs) * (t.PortEnd - t.PortStart + 1)
+	if count > maxTemplateInstances {
+		return nil, fmt.Errorf("inbound template %s: expands to %d inbounds (max %d)", t.Tag, count, maxTemplateInstances)
+	}
+
+	switch t.Egress {
+	case types.TemplateEgressNone, types.TemplateEgressListen:
+	case types.TemplateEgressIPs:
+		if len(t.EgressIPs) == 0 {
+			return nil, fmt.Errorf("inbound template %s: egress mode %q requires egressIps", t.Tag, t.Egress)
+		}
+	default:
+		return nil, fmt.Errorf("inbound template %s: unknown egress mode %q", t.Tag, t.Egress)
+	}
+
+	instances := make([]templateInstance, 0, count)
+	for _, listen := range listenIPs {
+		if net.ParseIP(listen) == nil {
+			return nil, fmt.Errorf("inbound template %s: invalid listen ip %q", t.Tag, listen)
+		}
+		for port := t.PortStart; port <= t.PortEnd; port++ {
+			inst := templateInstance{
+				Tag:    fmt.Sprintf("%s-%s-%d", t.Tag, strings.ReplaceAll(listen, ":", "_"), port),
+				Listen: listen,
+				Port:   port,
+			}
+			switch t.Egress {
+			case types.TemplateEgressListen:
+				inst.EgressIP = listen
+			case types.TemplateEgressIPs:
+				inst.EgressIP = t.EgressIPs[len(instances)%len(t.EgressIPs)]
+			}
+			instances = append(instances, inst)
+		}
+	}
+	return instances, nil
+}
+
+// ExpandInboundTemplates expands all inbound templates of a node config into
+// concrete inbounds plus the egress outbounds and routing rules they need.
+// Excluded instances are left out along with their egress routing. Instances
+// whose egress IP is not in localIPs use the default outbound, see
+// TemplateEgressIssues.
+func ExpandInboundTemplates(templates []types.InboundTemplate, excluded, localIPs map[string]bool) ([]types.InboundConfig, []types.OutboundConfig, []interface{}, error) {
+	var inbounds []types.InboundConfig
+	egressTags := make(map[string][]string) // egress ip -> inbound tags
+	var egressOrder []string
+
+	for i := range templates {
+		t := &templates[i]
+		instances, err := templateInstances(t)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for _, inst := range instances {
+			if excluded[inst.Tag] {
+				continue
+			}
+			inbounds = append(inbounds, types.InboundConfig{
+				Tag:            inst.Tag,
+				Protocol:       t.Protocol,
+				Port:           inst.Port,
+				Listen:         inst.Listen,
+				Settings:       t.Settings,
+				StreamSettings: t.StreamSettings,
+				Sniffing:       t.Sniffing,
+			})
+			if inst.EgressIP == "" {
+				continue
+			}
+			ip, reason := checkEgressIP(inst.EgressIP, localIPs)
+			if reason != "" {
+				continue
+			}
+			if _, ok := egressTags[ip]; !ok {
+				egressOrder = append(egressOrder, ip)
+			}
+			egressTags[ip] = append(egressTags[ip], inst.Tag)
+		}
+	}
+
+	outbounds := make([]types.OutboundConfig, 0, len(egressOrder))
+	rules := make([]interface{}, 0, len(egressOrder))
+	for _, ip := range egressOrder {
+		tag := EgressOutboundTag(ip)
+		outbounds = append(outbounds, types.OutboundConfig{
+			Tag:         tag,
+			Protocol:    "freedom",
+			SendThrough: ip,
+			Settings:    map[string]interface{}{},
+		})
+		rules = append(rules, map[string]interface{}{
+			"type":        "field",
+			"inboundTag":  egressTags[ip],
+			"outboundTag": tag,
+		})
+	}
+
+	return inbounds, outbounds, rules, nil
+}
+
+// TemplateEgressIssues lists the egress IPs of inbound templates that are
+// invalid or not present on a local interface. Their instances are routed
+// through the default outbound instead.
+func TemplateEgressIssues(templates []types.InboundTemplate, localIPs map[string]bool) []types.EgressBindingIssue {
+	var issues []types.EgressBindingIssue
+	for i := range templates {
+		t := &templates[i]
+		var ips []string
+		switch t.Egress {
+		case types.TemplateEgressIPs:
+			ips = t.EgressIPs
+		case types.TemplateEgressListen:
+			ips = t.ListenIPs
+			if len(ips) == 0 {
+				ips = []string{"0.0.0.0"}
+			}
+		}
+		for _, ip := range ips {
+			if _, reason := checkEgressIP(ip, localIPs); reason != "" {
+				issues = append(issues, types.EgressBindingIssue{
+					Template: t.Tag,
+					EgressIP: ip,
+					Reason:   reason,
+				})
+			}
+		}
+	}
+	return issues
+}
+
+// TemplateInboundTags maps each inbound template tag to its expanded inbound tags
+func TemplateInboundTags(nodeConfig *types.NodeConfig) map[string][]string {
+	result := make(map[string][]string)
+	if nodeConfig == nil {
+		return result
+	}
+	excluded := excludedInbounds(nodeConfig)
+	for i := range nodeConfig.InboundTemplates {
+		t := &nodeConfig.InboundTemplates[i]
+		instances, err := templateInstances(t)
+		if err != nil {
+			continue
+		}
+		tags := make([]string, 0, len(instances))
+		for _, inst := range instances {
+			if excluded[inst.Tag] {
+				continue
+			}
+			tags = append(tags, inst.Tag)
+		}
+		result[t.Tag] = tags
+	}
+	return result
+}
+
+// ExpandUserInboundTags replaces template tags in users' inbound tags with the
+// expanded instance tags, so a user assigned to a template joins every instance
+func ExpandUserInboundTags(users []types.UserConfig, templateTags map[string][]string) []types.UserConfig {
+	if len(templateTags) == 0 {
+		return users
+	}
+	result := make([]types.UserConfig, len(users))
+	for i, user := range users {
+		result[i] = user
+		expanded := make([]string, 0, len(user.InboundTags))
+		for _, tag := range user.InboundTags {
+			if instanceTags, ok := templateTags[tag]; ok {
+				expanded = append(expanded, instanceTags...)
+			} else {
+				expanded = append(expanded, tag)
+			}
+		}
+		result[i].InboundTags = expanded
+	}
+	return result
+}
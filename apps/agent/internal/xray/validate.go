@@ -0,0 +1,268 @@
+package xray
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/synexim/panel-agent/pkg/types"
+)
+
+// diagnostics collects the findings of a config validation
+type diagnostics []types.ConfigDiagnostic
+
+func (d *diagnostics) add(severity, code, path, format string, args ...interface{}) {
+	*d = append(*d, types.ConfigDiagnostic{
+		Severity: severity,
+		Code:     code,
+		Path:     path,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// HasErrors reports whether any diagnostic is an error
+func HasErrors(diags []types.ConfigDiagnostic) bool {
+	for _, d := range diags {
+		if d.Severity == types.DiagnosticError {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateNodeConfig checks a node config for duplicate and reserved tags,
+// invalid ports, protocols the cores do not support and routing references
+// to inbounds, outbounds or balancers that do not exist.
+// Protocols are not checked when caps is nil.
+func ValidateNodeConfig(nodeConfig *types.NodeConfig, caps *types.CoreCapabilities) []types.ConfigDiagnostic {
+	var diags diagnostics
+	if nodeConfig == nil {
+		return diags
+	}
+
+	inboundTags := validateInbounds(&diags, nodeConfig, caps)
+	outboundTags := validateOutbounds(&diags, nodeConfig, caps)
+	if nodeConfig.Routing != nil {
+		balancerTags := validateBalancers(&diags, nodeConfig.Routing.Balancers, outboundTags)
+		validateRules(&diags, nodeConfig.Routing.Rules, inboundTags, outboundTags, balancerTags)
+	}
+	return diags
+}
+
+// validateInbounds checks inbounds and templates, returning every inbound tag a
+// routing rule can match (plain inbounds and expanded template instances)
+func validateInbounds(diags *diagnostics, nodeConfig *types.NodeConfig, caps *types.CoreCapabilities) map[string]bool {
+	tags := make(map[string]bool)
+	seen := map[string]string{APIInboundTag: "the agent's api inbound"}
+	claim := func(tag, path string) {
+		if owner, ok := seen[tag]; ok {
+			diags.add(types.DiagnosticError, "duplicate_tag", path, "inbound tag %q is already used by %s", tag, owner)
+			return
+		}
+		seen[tag] = path
+	}
+
+	for i := range nodeConfig.Inbounds {
+		inbound := &nodeConfig.Inbounds[i]
+		path := fmt.Sprintf("inbounds[%d]", i)
+		if inbound.Tag == "" {
+			diags.add(types.DiagnosticError, "missing_tag", path+".tag", "inbound has no tag")
+		} else {
+			claim(inbound.Tag, path+".tag")
+			tags[inbound.Tag] = true
+		}
+
+		unixListen := strings.HasPrefix(inbound.Listen, "/") || strings.HasPrefix(inbound.Listen, "@")
+		if inbound.Port < 0 || inbound.Port > 65535 || (inbound.Port == 0 && !unixListen) {
+			diags.add(types.DiagnosticError, "invalid_port", path+".port", "inbound %s: port %d is out of range 1-65535", inbound.Tag, inbound.Port)
+		}
+		checkProtocol(diags, path+".protocol", inbound.Protocol, caps, true)
+	}
+
+	for i := range nodeConfig.InboundTemplates {
+		t := &nodeConfig.InboundTemplates[i]
+		path := fmt.Sprintf("inboundTemplates[%d]", i)
+		checkProtocol(diags, path+".protocol", t.Protocol, caps, true)
+
+		instances, err := templateInstances(t)
+		if err != nil {
+			diags.add(types.DiagnosticError, "invalid_template", path, "%v", err)
+			continue
+		}
+		claim(t.Tag, path+".tag")
+		for _, inst := range instances {
+			claim(inst.Tag, path)
+			tags[inst.Tag] = true
+		}
+	}
+	return tags
+}
+
+// validateOutbounds checks outbounds, returning every outbound tag a routing
+// rule can target, including the outbounds the generator adds
+func validateOutbounds(diags *diagnostics, nodeConfig *types.NodeConfig, caps *types.CoreCapabilities) map[string]bool {
+	tags := map[string]bool{"direct": true}
+	seen := make(map[string]bool)
+	for i := range nodeConfig.Outbounds {
+		outbound := &nodeConfig.Outbounds[i]
+		path := fmt.Sprintf("outbounds[%d]", i)
+		switch {
+		case outbound.Tag == APIOutboundTag:
+			diags.add(types.DiagnosticError, "reserved_tag", path+".tag", "outbound tag %q is reserved for the agent's api", outbound.Tag)
+		case outbound.Tag != "" && seen[outbound.Tag]:
+			diags.add(types.DiagnosticError, "duplicate_tag", path+".tag", "outbound tag %q is used more than once", outbound.Tag)
+		}
+		if outbound.Tag != "" {
+			seen[outbound.Tag] = true
+			tags[outbound.Tag] = true
+		}
+		checkProtocol(diags, path+".protocol", outbound.Protocol, caps, false)
+	}
+
+	for i := range nodeConfig.InboundTemplates {
+		t := &nodeConfig.InboundTemplates[i]
+		instances, err := templateInstances(t)
+		if err != nil {
+			continue
+		}
+		for _, inst := range instances {
+			if inst.EgressIP != "" {
+				tags[EgressOutboundTag(inst.EgressIP)] = true
+			}
+		}
+	}
+	return tags
+}
+
+// validateBalancers checks balancer tags and fallbacks, returning the balancer tags
+func validateBalancers(diags *diagnostics, balancers []interface{}, outboundTags map[string]bool) map[string]bool {
+	tags := make(map[string]bool)
+	for i, raw := range balancers {
+		path := fmt.Sprintf("routing.balancers[%d]", i)
+		balancer, ok := raw.(map[string]interface{})
+		if !ok {
+			diags.add(types.DiagnosticError, "invalid_balancer", path, "balancer is not an object")
+			continue
+		}
+		tag, _ := balancer["tag"].(string)
+		switch {
+		case tag == "":
+			diags.add(types.DiagnosticError, "missing_tag", path+".tag", "balancer has no tag")
+		case tags[tag]:
+			diags.add(types.DiagnosticError, "duplicate_tag", path+".tag", "balancer tag %q is used more than once", tag)
+		}
+		tags[tag] = true
+
+		selectors := stringList(balancer["selector"])
+		if len(selectors) == 0 {
+			diags.add(types.DiagnosticError, "empty_selector", path+".selector", "balancer %s selects no outbounds", tag)
+		}
+		for _, prefix := range selectors {
+			if !matchesPrefix(outboundTags, prefix) {
+				diags.add(types.DiagnosticWarning, "unknown_outbound", path+".selector", "balancer %s: selector %q matches no outbound", tag, prefix)
+			}
+		}
+		if fallback, ok := balancer["fallbackTag"].(string); ok && fallback != "" && !outboundTags[fallback] {
+			diags.add(types.DiagnosticError, "unknown_outbound", path+".fallbackTag", "balancer %s: fallback outbound %q does not exist", tag, fallback)
+		}
+	}
+	return tags
+}
+
+// validateRules checks that every routing rule has a target and references
+// existing inbounds, outbounds and balancers
+func validateRules(diags *diagnostics, rules []interface{}, inboundTags, outboundTags, balancerTags map[string]bool) {
+	for i, raw := range rules {
+		path := fmt.Sprintf("routing.rules[%d]", i)
+		rule, ok := raw.(map[string]interface{})
+		if !ok {
+			diags.add(types.DiagnosticError, "invalid_rule", path, "rule is not an object and is dropped")
+			continue
+		}
+
+		if problem := ruleTargetProblem(rule); problem != "" {
+			diags.add(types.DiagnosticError, "missing_target", path, "%s, rule is dropped", problem)
+		}
+		if tag, ok := rule["outboundTag"].(string); ok && tag != "" && !outboundTags[tag] {
+			diags.add(types.DiagnosticError, "unknown_outbound", path+".outboundTag", "outbound %q does not exist", tag)
+		}
+		if tag, ok := rule["balancerTag"].(string); ok && tag != "" && !balancerTags[tag] {
+			diags.add(types.DiagnosticError, "unknown_balancer", path+".balancerTag", "balancer %q does not exist", tag)
+		}
+		for _, tag := range stringList(rule["inboundTag"]) {
+			if tag != APIInboundTag && !inboundTags[tag] {
+				diags.add(types.DiagnosticWarning, "unknown_inbound", path+".inboundTag", "inbound %q does not exist, rule never matches it", tag)
+			}
+		}
+	}
+}
+
+// ruleTargetProblem explains why a rule has no usable target, or returns ""
+func ruleTargetProblem(rule map[string]interface{}) string {
+	outbound, hasOutbound := rule["outboundTag"].(string)
+	balancer, hasBalancer := rule["balancerTag"].(string)
+	if (hasOutbound && outbound != "") || (hasBalancer && balancer != "") {
+		return ""
+	}
+	return "rule has neither outboundTag nor balancerTag"
+}
+
+// outboundAliases maps Panel's Xray outbound protocols to the names sing-box
+// reports for them; its generator translates freedom and blackhole
+var outboundAliases = map[string]string{
+	"freedom":   "direct",
+	"blackhole": "block",
+}
+
+// checkProtocol reports a protocol the cores do not support
+func checkProtocol(diags *diagnostics, path, protocol string, caps *types.CoreCapabilities, inbound bool) {
+	if protocol == "" {
+		diags.add(types.DiagnosticError, "missing_protocol", path, "protocol is empty")
+		return
+	}
+	if caps == nil {
+		return
+	}
+	supported, direction := caps.Protocols.Outbound, "outbound"
+	if inbound {
+		supported, direction = caps.Protocols.Inbound, "inbound"
+	}
+	alias := ""
+	if !inbound {
+		alias = outboundAliases[protocol]
+	}
+	for _, p := range supported {
+		if p == protocol || (alias != "" && p == alias) {
+			return
+		}
+	}
+	diags.add(types.DiagnosticError, "unsupported_protocol", path, "%s protocol %q is not supported by the configured cores", direction, protocol)
+}
+
+// stringList reads a string or list of strings from decoded JSON
+func stringList(v interface{}) []string {
+	switch value := v.(type) {
+	case string:
+		return []string{value}
+	case []string:
+		return value
+	case []interface{}:
+		list := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// matchesPrefix reports whether any tag starts with prefix, as balancer selectors match
+func matchesPrefix(tags map[string]bool, prefix string) bool {
+	for tag := range tags {
+		if strings.HasPrefix(tag, prefix) {
+			return true
+		}
+	}
+	return false
+}
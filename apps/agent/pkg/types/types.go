@@ -32,13 +32,18 @@ func ParseCoreType(s string) CoreType {
 
 // NodeConfig represents the configuration pulled from Panel
 type NodeConfig struct {
-	Version   string           `json:"version"`
-	ETag      string           `json:"etag"`
-	Inbounds  []InboundConfig  `json:"inbounds"`
-	Outbounds []OutboundConfig `json:"outbounds"`
-	Routing   *RoutingConfig   `json:"routing"`
-	DNS       interface{}      `json:"dns"`
-	Policy    interface{}      `json:"policy"`
+	Version          string            `json:"version"`
+	ETag             string            `json:"etag"`
+	Inbounds         []InboundConfig   `json:"inbounds"`
+	InboundTemplates []InboundTemplate `json:"inboundTemplates,omitempty"`
+	Outbounds        []OutboundConfig  `json:"outbounds"`
+	Routing          *RoutingConfig    `json:"routing"`
+	DNS              interface{}       `json:"dns"`
+	Policy           interface{}       `json:"policy"`
+
+	// ExcludedInbounds lists inbound tags the agent dropped locally (e.g. port
+	// conflicts); never sent by Panel
+	ExcludedInbounds []string `json:"-"`
 }
 
 // InboundConfig represents Xray inbound configuration
@@ -53,6 +58,28 @@ type InboundConfig struct {
 	Allocate       interface{} `json:"allocate,omitempty"`
 }
 
+// InboundTemplate describes a batch of near-identical inbounds that the agent
+// expands locally into one inbound per listen IP and port
+type InboundTemplate struct {
+	Tag            string      `json:"tag"` // Prefix for generated inbound tags
+	Protocol       string      `json:"protocol"`
+	PortStart      int         `json:"portStart"`
+	PortEnd        int         `json:"portEnd"`
+	ListenIPs      []string    `json:"listenIps"`
+	Settings       interface{} `json:"settings"`
+	StreamSettings interface{} `json:"streamSettings,omitempty"`
+	Sniffing       interface{} `json:"sniffing,omitempty"`
+	Egress         string      `json:"egress,omitempty"`    // "", "listen" or "ips"
+	EgressIPs      []string    `json:"egressIps,omitempty"` // Assigned round-robin per instance when Egress is "ips"
+}
+
+// Inbound template egress modes
+const (
+	TemplateEgressNone   = ""
+	TemplateEgressListen = "listen"
+	TemplateEgressIPs    = "ips"
+)
+
 // OutboundConfig represents Xray outbound configuration
 type OutboundConfig struct {
 	Tag            string      `json:"tag"`
@@ -91,6 +118,7 @@ type UserConfig struct {
 	Level         int      `json:"level"`
 	InboundTags   []string `json:"inboundTags"`
 	OutboundTag   string   `json:"outboundTag,omitempty"`
+	EgressIP      string   `json:"egressIp,omitempty"`
 	TotalBytes    int64    `json:"totalBytes"`
 	UsedBytes     int64    `json:"usedBytes"`
 	ExpiryTime    int64    `json:"expiryTime"`
@@ -114,6 +142,82 @@ type StatusReport struct {
 	Uptime      int64   `json:"uptime"`
 	OnlineUsers int     `json:"onlineUsers"`
 	XrayVersion string  `json:"xrayVersion,omitempty"`
+
+	Cores  []CoreStatus    `json:"cores,omitempty"`
+	Active *ActiveVersions `json:"active,omitempty"`
+}
+
+// ActiveVersions identifies the config and user list the node is running
+type ActiveVersions struct {
+	ConfigVersion string `json:"configVersion,omitempty"`
+	ConfigETag    string `json:"configEtag,omitempty"`
+	UsersVersion  string `json:"usersVersion,omitempty"`
+	UsersETag     string `json:"usersEtag,omitempty"`
+	ConfigHash    string `json:"configHash,omitempty"` // sha256 of the rendered core configs
+}
+
+// Apply statuses reported after a config or user sync
+const (
+	ApplyStatusApplied    = "applied"     // rendered and cores restarted
+	ApplyStatusHotApplied = "hot-applied" // applied through the core API without restart
+	ApplyStatusPartial    = "partial"     // hot applied, but some users could not be added or removed
+	ApplyStatusRejected   = "rejected"    // not applied, previous config keeps running
+	ApplyStatusRolledBack = "rolled-back" // cores failed with it, previous config restored
+	ApplyStatusFailed     = "failed"      // cores failed and could not be rolled back
+)
+
+// ApplyResult acknowledges a config or user sync
+type ApplyResult struct {
+	Kind       string   `json:"kind"` // config or users
+	Version    string   `json:"version"`
+	ETag       string   `json:"etag"`
+	ConfigHash string   `json:"configHash,omitempty"`
+	Status     string   `json:"status"`
+	DurationMs int64    `json:"durationMs"`
+	Errors     []string `json:"errors,omitempty"`
+	Reason     string   `json:"reason,omitempty"` // why a config was rolled back after probation
+	AppliedAt  int64    `json:"appliedAt"`        // unix seconds
+}
+
+// CoreStatus reports the readiness of one proxy core
+type CoreStatus struct {
+	CoreType        CoreType `json:"coreType"`
+	Version         string   `json:"version,omitempty"`
+	Running         bool     `json:"running"`
+	Ready           bool     `json:"ready"`
+	Error           string   `json:"error,omitempty"`
+	MissingInbounds []string `json:"missingInbounds,omitempty"`
+	RolledBack      bool     `json:"rolledBack,omitempty"` // last config failed readiness, previous config restored
+}
+
+// AgentState is the local admin API's view of a running agent
+type AgentState struct {
+	StartedAt        int64                  `json:"startedAt"` // unix seconds
+	CoreMode         string                 `json:"coreMode"`
+	Cores            []CoreStatus           `json:"cores"`
+	Active           ActiveVersions         `json:"active"`
+	Users            UserCounts             `json:"users"`
+	ExcludedInbounds []string               `json:"excludedInbounds,omitempty"`
+	Tasks            map[string]TaskResult  `json:"tasks"`     // last run of each periodic task
+	LastApply        map[string]ApplyResult `json:"lastApply"` // by kind: config, users
+}
+
+// UserCounts summarizes the users a node serves
+type UserCounts struct {
+	Total     int            `json:"total"`
+	Online    int            `json:"online"`
+	ByInbound map[string]int `json:"byInbound"`
+}
+
+// TaskResult is the outcome of the last run of a periodic task
+type TaskResult struct {
+	LastRun    int64  `json:"lastRun"` // unix seconds
+	DurationMs int64  `json:"durationMs"`
+	OK         bool   `json:"ok"`
+	Error      string `json:"error,omitempty"`
+	Failures   int    `json:"failures,omitempty"`   // consecutive failed runs
+	IntervalMs int64  `json:"intervalMs,omitempty"` // current interval of a periodic task
+	NextRun    int64  `json:"nextRun,omitempty"`    // unix seconds, jittered
 }
 
 // AliveUser represents an online user
@@ -125,8 +229,9 @@ type AliveUser struct {
 
 // AliveResponse represents the response from alive endpoint
 type AliveResponse struct {
-	Success   bool     `json:"success"`
-	KickUsers []string `json:"kickUsers"`
+	Success   bool       `json:"success"`
+	KickUsers []string   `json:"kickUsers"`
+	Intervals *Intervals `json:"intervals,omitempty"` // retunes the loops when set
 }
 
 // RateLimitConfig represents rate limit configuration for a user
@@ -147,6 +252,53 @@ type EgressIP struct {
 	IsActive      bool   `json:"isActive"`
 }
 
+// EgressBindingIssue represents a per-user or inbound template egress IP
+// binding that cannot be honored
+type EgressBindingIssue struct {
+	Email    string `json:"email"`
+	Template string `json:"template,omitempty"` // set for inbound template egress IPs
+	EgressIP string `json:"egressIp"`
+	Reason   string `json:"reason"`
+}
+
+// PortConflict represents an inbound whose port cannot be bound
+type PortConflict struct {
+	Tag           string `json:"tag"`
+	Listen        string `json:"listen"`
+	Port          int    `json:"port"`
+	Network       string `json:"network"` // tcp or udp
+	Reason        string `json:"reason"`
+	ConflictsWith string `json:"conflictsWith,omitempty"` // inbound tag for duplicates within the config
+}
+
+// PortConflictReport is sent to Panel after a preflight found conflicts
+type PortConflictReport struct {
+	ConfigVersion string         `json:"configVersion"`
+	Policy        string         `json:"policy"` // exclude or reject
+	Conflicts     []PortConflict `json:"conflicts"`
+}
+
+// Config diagnostic severities
+const (
+	DiagnosticError   = "error"   // the core will reject or ignore this part of the config
+	DiagnosticWarning = "warning" // accepted, but likely not what was intended
+)
+
+// ConfigDiagnostic describes one problem found while validating a node config
+type ConfigDiagnostic struct {
+	Severity string `json:"severity"` // error or warning
+	Code     string `json:"code"`     // e.g. duplicate_tag, unknown_outbound
+	Path     string `json:"path"`     // e.g. routing.rules[3].outboundTag
+	Message  string `json:"message"`
+}
+
+// ConfigValidationReport is sent to Panel after validating a synced config
+type ConfigValidationReport struct {
+	ConfigVersion string             `json:"configVersion"`
+	Valid         bool               `json:"valid"` // no error diagnostics
+	Diagnostics   []ConfigDiagnostic `json:"diagnostics"`
+}
+
 // RegisterRequest represents node registration request
 type RegisterRequest struct {
 	Hostname     string            `json:"hostname"`
@@ -174,11 +326,108 @@ type Protocols struct {
 
 // RegisterResponse represents node registration response
 type RegisterResponse struct {
-	NodeID                string `json:"nodeId"`
-	NodeName              string `json:"nodeName"`
-	ConfigPollInterval    int    `json:"configPollInterval"`
-	UserPollInterval      int    `json:"userPollInterval"`
-	TrafficReportInterval int    `json:"trafficReportInterval"`
-	StatusReportInterval  int    `json:"statusReportInterval"`
-	AlivePollInterval     int    `json:"alivePollInterval"`
+	NodeID   string `json:"nodeId"`
+	NodeName string `json:"nodeName"`
+	Intervals
+}
+
+// Intervals are the periods in seconds Panel wants the node's periodic
+// tasks to run at; 0 leaves an interval unchanged
+type Intervals struct {
+	ConfigPollInterval    int `json:"configPollInterval"`
+	UserPollInterval      int `json:"userPollInterval"`
+	TrafficReportInterval int `json:"trafficReportInterval"`
+	StatusReportInterval  int `json:"statusReportInterval"`
+	AlivePollInterval     int `json:"alivePollInterval"`
+}
+
+// LiveUser is a synced user with its online sessions and the traffic
+// collected since the agent started
+type LiveUser struct {
+	Email    string `json:"email"`
+	Sessions int64  `json:"sessions"`
+	Upload   int64  `json:"upload"`
+	Download int64  `json:"download"`
+}
+
+// Doctor check outcomes
+const (
+	CheckPass = "pass"
+	CheckWarn = "warn"
+	CheckFail = "fail"
+)
+
+// DoctorCheck is one check of `agent doctor`
+type DoctorCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"` // pass, warn, fail
+	Detail string `json:"detail"`
+}
+
+// DoctorReport is the self-diagnosis of a node
+type DoctorReport struct {
+	AgentVersion string        `json:"agentVersion"`
+	Healthy      bool          `json:"healthy"` // no failed checks
+	Checks       []DoctorCheck `json:"checks"`
+	CreatedAt    int64         `json:"createdAt"`
+}
+
+// AgentEvent is a notable event of the running agent, such as an apply or a core restart
+type AgentEvent struct {
+	At      int64  `json:"at"` // unix seconds
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// TopSnapshot is the live view of a node served to `agent top`
+type TopSnapshot struct {
+	At        int64        `json:"at"`      // unix milliseconds
+	Window    float64      `json:"window"`  // seconds the rates are averaged over
+	AliveAt   int64        `json:"aliveAt"` // unix seconds of the online IP poll, 0 before the first
+	Cores     []CoreStatus `json:"cores"`
+	Users     []TopUser    `json:"users"` // users with traffic in the window or online IPs
+	Inbounds  []TagRate    `json:"inbounds"`
+	Outbounds []TagRate    `json:"outbounds"`
+	Events    []AgentEvent `json:"events"` // most recent last
+}
+
+// TopUser is one user's live bandwidth
+type TopUser struct {
+	Email        string   `json:"email"`
+	Inbounds     []string `json:"inbounds"`
+	UploadRate   int64    `json:"uploadRate"` // bytes/s
+	DownloadRate int64    `json:"downloadRate"`
+	IPs          int      `json:"ips"`
+	Upload       int64    `json:"upload"` // since the agent started
+	Download     int64    `json:"download"`
+}
+
+// TagRate is the throughput of an inbound or outbound tag
+type TagRate struct {
+	Core         string `json:"core"`
+	Tag          string `json:"tag"`
+	UploadRate   int64  `json:"uploadRate"` // bytes/s
+	DownloadRate int64  `json:"downloadRate"`
+}
+
+// UserTraffic is a user's traffic accumulated locally in standalone mode
+type UserTraffic struct {
+	Email     string `json:"email"`
+	Upload    int64  `json:"upload"`
+	Download  int64  `json:"download"`
+	UpdatedAt int64  `json:"updatedAt"` // unix seconds
+}
+
+// ImportRequest is a node imported from an existing Xray or x-ui installation
+type ImportRequest struct {
+	Source string       `json:"source"` // xray or x-ui
+	Config *NodeConfig  `json:"config"`
+	Users  []UserConfig `json:"users"`
+}
+
+// ImportResponse is the answer of Panel to an import
+type ImportResponse struct {
+	Inbounds int      `json:"inbounds"`
+	Users    int      `json:"users"`
+	Skipped  []string `json:"skipped,omitempty"` // emails or tags that already existed
 }